@@ -0,0 +1,142 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveSuffixes are the file extensions of snapshots stored as archives.
+// .tar.zst archives are written by -compact.
+var archiveSuffixes = []string{".tar.gz", ".tar.zst", ".tar"}
+
+// archiveSuffix returns the extension used for new archive snapshots.
+func archiveSuffix(config *Config) string {
+	if config.ArchiveCompress {
+		return ".tar.gz"
+	}
+	return ".tar"
+}
+
+// compressor wraps the writer of an archive in a compressing one; nil
+// leaves the tar uncompressed.
+type compressor func(io.Writer) (io.WriteCloser, error)
+
+// gzipCompressor compresses at the default gzip level.
+func gzipCompressor(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+// archiveCompressor returns the compressor for new archive snapshots.
+func archiveCompressor(config *Config) compressor {
+	if config.ArchiveCompress {
+		return gzipCompressor
+	}
+	return nil
+}
+
+// trimArchiveSuffix strips a snapshot archive extension from name. It
+// returns false if name isn't an archive.
+func trimArchiveSuffix(name string) (string, bool) {
+	for _, suffix := range archiveSuffixes {
+		if base, ok := strings.CutSuffix(name, suffix); ok {
+			return base, true
+		}
+	}
+	return name, false
+}
+
+// createArchive writes the contents of srcDir as a tar file at destFile,
+// compressed with compress unless it is nil. The file is written under a
+// temporary name and renamed into place once complete.
+func createArchive(srcDir string, destFile string, compress compressor) error {
+	tmpFile := filepath.Join(filepath.Dir(destFile), ".unfinished"+filepath.Ext(destFile))
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	//nolint:errcheck
+	defer os.Remove(tmpFile)
+
+	if err := writeTar(f, srcDir, compress); err != nil {
+		//nolint:errcheck
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := os.Rename(tmpFile, destFile); err != nil {
+		return fmt.Errorf("failed to rename archive: %w", err)
+	}
+	return nil
+}
+
+func writeTar(w io.Writer, srcDir string, compress compressor) error {
+	var cw io.WriteCloser
+	if compress != nil {
+		var err error
+		if cw, err = compress(w); err != nil {
+			return err
+		}
+		w = cw
+	}
+	tw := tar.NewWriter(w)
+
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		//nolint:errcheck
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if cw != nil {
+		if err := cw.Close(); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+	}
+	return nil
+}
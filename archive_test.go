@@ -0,0 +1,145 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunSnapshotBackupArchive(t *testing.T) {
+	assumeSourcesExist(t)
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Destination:     tmpDir,
+		SnapshotPrefix:  "test",
+		Source:          []string{"/tmp/source1"},
+		Archive:         true,
+		ArchiveCompress: true,
+	}
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, "sending incremental file list\n", 0)
+	defer func() { execCommand = exec.CommandContext }()
+
+	if err := runSnapshotBackup(context.Background(), config, runOptions{}, false); err != nil {
+		t.Fatalf("runSnapshotBackup failed: %v", err)
+	}
+
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to read dir: %v", err)
+	}
+	// Besides the archive, only the latest symlink pointing at it.
+	if len(entries) != 2 || entries[0].Name() != "latest-test" {
+		t.Fatalf("Expected only the archive in the destination, got %v", entries)
+	}
+	name := entries[1].Name()
+	if target, err := os.Readlink(filepath.Join(tmpDir, "latest-test")); err != nil || target != name {
+		t.Errorf("Expected latest to point at %s, got %q (%v)", name, target, err)
+	}
+	if base, ok := trimArchiveSuffix(name); !ok || filepath.Ext(name) != ".gz" {
+		t.Fatalf("Expected a .tar.gz snapshot, got %s", name)
+	} else if _, ok := parseSnapshotTime(base); !ok {
+		t.Errorf("Expected archive name %s to contain the snapshot time", name)
+	}
+
+	f, err := os.Open(filepath.Join(tmpDir, name))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Failed to read gzip: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	found := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read tar: %v", err)
+		}
+		if hdr.Name == "rsync.log" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected rsync.log inside the archive")
+	}
+
+	// A second run must not pass an archive as --link-dest.
+	if err := runSnapshotBackup(context.Background(), config, runOptions{}, false); err != nil {
+		t.Fatalf("second runSnapshotBackup failed: %v", err)
+	}
+	for _, arg := range gotArgs {
+		if strings.HasPrefix(arg, "--link-dest=") {
+			t.Errorf("Expected no --link-dest in archive mode, got %v", gotArgs)
+		}
+	}
+}
+
+func TestPurgeBackupsArchives(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Now()
+	var names []string
+	for i, suffix := range []string{".tar", ".tar.gz", ".tar"} {
+		modTime := now.AddDate(0, 0, i-3)
+		name := "test_" + modTime.Format(snapshotTimeFormat) + suffix
+		names = append(names, name)
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte("archive"), 0644); err != nil {
+			t.Fatalf("Failed to create archive: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+	}
+	// Other files are not snapshots.
+	if err := os.WriteFile(filepath.Join(tmpDir, "notes.txt"), []byte("keep me"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	snapshots, err := getSnapshots(context.Background(), tmpDir)
+	if err != nil {
+		t.Fatalf("getSnapshots failed: %v", err)
+	}
+	if len(snapshots) != len(names) {
+		t.Fatalf("Expected %d archive snapshots, got %d", len(names), len(snapshots))
+	}
+
+	if err := purgeBackups(context.Background(), &Config{Destination: tmpDir, SnapshotPrefix: "test", Keep: Keep{Daily: 1}, MaxPurgePercent: 100}, false); err != nil {
+		t.Fatalf("purgeBackups failed: %v", err)
+	}
+	for i, name := range names {
+		_, err := os.Stat(filepath.Join(tmpDir, name))
+		if i < 2 && !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be purged", name)
+		}
+		if i == 2 && err != nil {
+			t.Errorf("Expected %s to be kept, got %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "notes.txt")); err != nil {
+		t.Errorf("Expected notes.txt to be left alone, got %v", err)
+	}
+}
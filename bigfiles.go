@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/mattn/go-isatty"
+	"github.com/rs/zerolog/log"
+)
+
+// largeFile is a source file above warn_file_size.
+type largeFile struct {
+	Path      string
+	Size      int64
+	Allocated int64 // bytes actually stored, less than Size for sparse files
+}
+
+func (f largeFile) sparse() bool {
+	return f.Allocated < f.Size
+}
+
+// findLargeFiles walks the sources for regular files larger than threshold.
+// Directories and files matching the exclude rules are skipped; rsync's
+// "**" and character class extensions aren't understood, so a file excluded
+// that way may still be reported.
+func findLargeFiles(config *Config, threshold int64) []largeFile {
+	var found []largeFile
+	for _, src := range config.Source {
+		anchor := sourceAnchor(src)
+		err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// Unreadable directories are rsync's to report.
+				return nil
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			if rel == "." {
+				rel = ""
+			}
+			anchored := strings.TrimSuffix(anchor+filepath.ToSlash(rel), "/")
+			if anchored != "" && excludedByRules(anchored, d.IsDir(), config.Exclude) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || info.Size() <= threshold {
+				return nil
+			}
+			f := largeFile{Path: p, Size: info.Size(), Allocated: info.Size()}
+			if st, ok := info.Sys().(*syscall.Stat_t); ok {
+				f.Allocated = st.Blocks * 512
+			}
+			found = append(found, f)
+			return nil
+		})
+		if err != nil {
+			log.Warn().Err(err).Str("source", src).Msg("Could not look for large files")
+		}
+	}
+	return found
+}
+
+// excludedByRules reports whether an rsync exclude rule matches p, a path
+// anchored at the transfer root such as "/home/user/file". A rule without a
+// slash matches the last component, a rule starting with a slash matches
+// from the root, and any other rule matches the end of the path. A rule
+// ending in a slash only matches directories.
+func excludedByRules(p string, isDir bool, rules []string) bool {
+	for _, rule := range rules {
+		dirOnly := strings.HasSuffix(rule, "/")
+		rule = strings.TrimSuffix(rule, "/")
+		if rule == "" || (dirOnly && !isDir) {
+			continue
+		}
+		var ok bool
+		switch {
+		case strings.HasPrefix(rule, "/"):
+			ok, _ = path.Match(rule, p)
+		case !strings.Contains(rule, "/"):
+			ok, _ = path.Match(rule, path.Base(p))
+		default:
+			n := strings.Count(rule, "/") + 1
+			parts := strings.Split(p, "/")
+			if len(parts) >= n {
+				ok, _ = path.Match(rule, strings.Join(parts[len(parts)-n:], "/"))
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// preflightLargeFiles warns about every source file larger than
+// warn_file_size. On a terminal, the user is asked whether to go on unless
+// -yes is given; otherwise the backup goes ahead after the warnings.
+func preflightLargeFiles(config *Config, dryRun bool) error {
+	if config.WarnFileSize == "" {
+		return nil
+	}
+	// validateConfig has already checked the size.
+	threshold, _ := parseSize(config.WarnFileSize)
+	files := findLargeFiles(config, threshold)
+	for _, f := range files {
+		event := log.Warn().Str("path", f.Path).Str("size", humanizeBytes(f.Size))
+		if f.sparse() {
+			event = event.Str("allocated", humanizeBytes(f.Allocated))
+		}
+		event.Msg("File is larger than warn_file_size")
+	}
+	if len(files) == 0 || dryRun || *yes || !isatty.IsTerminal(os.Stdin.Fd()) {
+		return nil
+	}
+	if !confirm(os.Stdin, os.Stdout, fmt.Sprintf("%d files are larger than %s. Back them up?", len(files), config.WarnFileSize)) {
+		return fmt.Errorf("stopped: files larger than warn_file_size")
+	}
+	return nil
+}
+
+// confirm asks question on out and reports whether the answer read from in
+// is yes.
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindLargeFiles(t *testing.T) {
+	sourceDir, err := os.MkdirTemp("", "goback-source")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(sourceDir)
+
+	// Sparse files take no space, so a large one is cheap to make.
+	for _, rel := range []string{"vm/disk.img", "isos/install.iso", "cache/blob"} {
+		path := filepath.Join(sourceDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if err := os.Truncate(path, 2<<30); err != nil {
+			t.Fatalf("Failed to extend file: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(sourceDir, "notes.txt"), []byte("small"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	config := &Config{Source: []string{sourceDir}, Exclude: []string{"*.iso", "cache/"}, WarnFileSize: "1G"}
+	if err := validateConfig(config); err != nil {
+		t.Fatalf("validateConfig failed: %v", err)
+	}
+	files := findLargeFiles(config, 1<<30)
+	if len(files) != 1 || files[0].Path != filepath.Join(sourceDir, "vm", "disk.img") {
+		t.Fatalf("Expected only vm/disk.img to be flagged, got %v", files)
+	}
+	if files[0].Size != 2<<30 || !files[0].sparse() {
+		t.Errorf("Expected a sparse file of 2G, got %+v", files[0])
+	}
+
+	if err := validateConfig(&Config{WarnFileSize: "huge"}); err == nil {
+		t.Error("Expected an invalid warn_file_size to be rejected")
+	}
+}
+
+func TestExcludedByRules(t *testing.T) {
+	for _, tc := range []struct {
+		path  string
+		isDir bool
+		rules []string
+		want  bool
+	}{
+		{"/src/a/b.iso", false, []string{"*.iso"}, true},
+		{"/src/a/b.img", false, []string{"*.iso"}, false},
+		{"/src/cache", true, []string{"cache/"}, true},
+		{"/src/cache", false, []string{"cache/"}, false},
+		{"/src/a/tmp", true, []string{"/src/a/tmp"}, true},
+		{"/src/b/a/tmp", true, []string{"/src/a/tmp"}, false},
+		{"/src/x/a/tmp", true, []string{"a/tmp"}, true},
+	} {
+		if got := excludedByRules(tc.path, tc.isDir, tc.rules); got != tc.want {
+			t.Errorf("excludedByRules(%q, %v, %q) = %v, want %v", tc.path, tc.isDir, tc.rules, got, tc.want)
+		}
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	var out strings.Builder
+	if !confirm(strings.NewReader("y\n"), &out, "Go on?") {
+		t.Error("Expected y to confirm")
+	}
+	if out.String() != "Go on? [y/N] " {
+		t.Errorf("Expected the question to be asked, got %q", out.String())
+	}
+	if confirm(strings.NewReader("\n"), &out, "Go on?") {
+		t.Error("Expected an empty answer not to confirm")
+	}
+}
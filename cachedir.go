@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// cacheDirTag is the file that marks a cache directory, see
+// https://bford.info/cachedir/.
+const cacheDirTag = "CACHEDIR.TAG"
+
+// cacheDirSignature is how a valid CACHEDIR.TAG must start.
+var cacheDirSignature = []byte("Signature: 8a477f597d28d172789f06886806bc55")
+
+// isCacheDir reports whether dir contains a valid CACHEDIR.TAG.
+func isCacheDir(dir string) bool {
+	f, err := os.Open(filepath.Join(dir, cacheDirTag))
+	if err != nil {
+		return false
+	}
+	//nolint:errcheck
+	defer f.Close()
+	buf := make([]byte, len(cacheDirSignature))
+	n, _ := f.Read(buf)
+	return bytes.Equal(buf[:n], cacheDirSignature)
+}
+
+// hasMarker reports whether dir contains a file named in markers.
+func hasMarker(dir string, markers []string) bool {
+	for _, marker := range markers {
+		if _, err := os.Lstat(filepath.Join(dir, marker)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheDirArgs returns an --exclude rule for every directory in the sources
+// that is tagged with a CACHEDIR.TAG.
+func cacheDirArgs(sources []string) []string {
+	return markedDirArgs(sources, isCacheDir, "cache directories")
+}
+
+// excludeIfPresentArgs returns an --exclude rule for every directory in the
+// sources that contains one of the marker files.
+func excludeIfPresentArgs(sources, markers []string) []string {
+	return markedDirArgs(sources, func(dir string) bool { return hasMarker(dir, markers) }, "marked directories")
+}
+
+// markedDirArgs returns an --exclude rule for every directory in the sources
+// for which marked is true. rsync filter rules can't test for the presence
+// of a file, so the sources are walked to find them; what names them in a
+// warning if that fails.
+func markedDirArgs(sources []string, marked func(dir string) bool, what string) []string {
+	var args []string
+	for _, src := range sources {
+		anchor := sourceAnchor(src)
+		err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// Unreadable directories are rsync's to report.
+				return nil
+			}
+			if !d.IsDir() || !marked(path) {
+				return nil
+			}
+			rel, err := filepath.Rel(src, path)
+			if err != nil {
+				return err
+			}
+			if rel == "." {
+				// The source itself is marked; exclude its contents.
+				args = append(args, "--exclude="+anchor+"*")
+			} else {
+				args = append(args, "--exclude="+anchor+escapeRsyncPattern(filepath.ToSlash(rel))+"/")
+			}
+			return filepath.SkipDir
+		})
+		if err != nil {
+			log.Warn().Err(err).Str("source", src).Msg("Could not look for " + what)
+		}
+	}
+	return args
+}
+
+// escapeRsyncPattern escapes the characters rsync treats as wildcards so a
+// path matches literally.
+func escapeRsyncPattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`).Replace(s)
+}
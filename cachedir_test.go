@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCacheDirArgs(t *testing.T) {
+	sourceDir, err := os.MkdirTemp("", "goback-source")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(sourceDir)
+
+	tag := "Signature: 8a477f597d28d172789f06886806bc55\n# This file is a cache directory tag.\n"
+	for dir, content := range map[string]string{
+		".cache/mozilla":       tag,
+		".cache/mozilla/inner": tag, // inside an excluded cache, not reported
+		"build [1]":            tag,
+		"docs":                 "not a real tag\n",
+		"photos":               "",
+	} {
+		path := filepath.Join(sourceDir, dir)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if content != "" {
+			if err := os.WriteFile(filepath.Join(path, cacheDirTag), []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write tag: %v", err)
+			}
+		}
+	}
+
+	base := "/" + filepath.Base(sourceDir) + "/"
+	got := cacheDirArgs([]string{sourceDir})
+	want := []string{"--exclude=" + base + ".cache/mozilla/", "--exclude=" + base + `build \[1]/`}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	config := &Config{Source: []string{sourceDir + "/"}, ExcludeCacheDirs: true}
+	if args := buildRsyncArgs(config, "/dest", "", false); !hasArg(args, "--exclude=/.cache/mozilla/") {
+		t.Errorf("Expected a cache exclude anchored at the source root, got %v", args)
+	}
+}
+
+func TestExcludeIfPresentArgs(t *testing.T) {
+	sourceDir, err := os.MkdirTemp("", "goback-source")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(sourceDir)
+
+	for dir, marker := range map[string]string{
+		"videos":       ".nobackup",
+		"videos/inner": ".nobackup", // inside an excluded directory, not reported
+		"vm/images":    ".no-backup",
+		"documents":    "",
+	} {
+		path := filepath.Join(sourceDir, dir)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if marker != "" {
+			if err := os.WriteFile(filepath.Join(path, marker), nil, 0644); err != nil {
+				t.Fatalf("Failed to write marker: %v", err)
+			}
+		}
+	}
+
+	base := "/" + filepath.Base(sourceDir) + "/"
+	got := excludeIfPresentArgs([]string{sourceDir}, []string{".nobackup", ".no-backup"})
+	want := []string{"--exclude=" + base + "videos/", "--exclude=" + base + "vm/images/"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	config := &Config{Source: []string{sourceDir}, ExcludeIfPresent: []string{".nobackup"}}
+	args := buildRsyncArgs(config, "/dest", "", false)
+	if !hasArg(args, "--exclude="+base+"videos/") || hasArg(args, "--exclude="+base+"vm/images/") {
+		t.Errorf("Expected only the .nobackup directory excluded, got %v", args)
+	}
+	if err := validateConfig(&Config{ExcludeIfPresent: []string{"sub/.nobackup"}}); err == nil {
+		t.Error("Expected a marker with a slash to be rejected")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// sourceCheckSample is how many files and directories checkSources opens
+// in each source before moving on to the next.
+var sourceCheckSample = 1000
+
+// openSource opens a path for checkSources; tests swap it to simulate
+// paths that can't be read.
+var openSource = os.Open
+
+// sourceProblem is a path in a source that the current user can't read.
+type sourceProblem struct {
+	Source string
+	Path   string
+	Err    error
+}
+
+func (p sourceProblem) String() string {
+	return fmt.Sprintf("%s: %s: %v", p.Source, p.Path, p.Err)
+}
+
+// errSampleDone stops the walk of a source once enough of it was checked.
+var errSampleDone = errors.New("sample done")
+
+// checkSources opens a sample of the files and directories in every source,
+// skipping excluded ones, and returns each path that can't be opened for
+// reading, such as a source that is missing or a file the user running
+// goback has no permission to read.
+func checkSources(ctx context.Context, config *Config) ([]sourceProblem, error) {
+	var problems []sourceProblem
+	for _, src := range config.Source {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		anchor := sourceAnchor(src)
+		checked := 0
+		err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				problems = append(problems, sourceProblem{Source: src, Path: p, Err: err})
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			if rel == "." {
+				rel = ""
+			}
+			anchored := strings.TrimSuffix(anchor+filepath.ToSlash(rel), "/")
+			if anchored != "" && excludedByRules(anchored, d.IsDir(), config.Exclude) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			// Symlinks, devices and sockets are copied as they are, not read.
+			if !d.IsDir() && !d.Type().IsRegular() {
+				return nil
+			}
+			if checked >= sourceCheckSample {
+				return errSampleDone
+			}
+			checked++
+			f, err := openSource(p)
+			if err != nil {
+				problems = append(problems, sourceProblem{Source: src, Path: p, Err: err})
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			//nolint:errcheck
+			f.Close()
+			return nil
+		})
+		if err != nil && !errors.Is(err, errSampleDone) {
+			return nil, err
+		}
+	}
+	return problems, nil
+}
+
+// errNoValidSources is returned when none of the sources can be read.
+var errNoValidSources = errors.New("no valid sources")
+
+// selectSources checks that every source exists and can be read before
+// rsync is started. If none can, the run fails with errNoValidSources
+// rather than making an empty snapshot. If only some can't, the run fails
+// too unless allow_missing_source is set, in which case they are logged
+// and left out of config.Source.
+func selectSources(config *Config) error {
+	var available []string
+	var missing []string
+	for _, src := range config.Source {
+		f, err := openSource(src)
+		if err != nil {
+			log.Warn().Str("source", src).Err(err).Msg("Source can't be read")
+			missing = append(missing, src)
+			continue
+		}
+		//nolint:errcheck
+		f.Close()
+		available = append(available, src)
+	}
+	switch {
+	case len(missing) == 0:
+		return nil
+	case len(available) == 0:
+		return fmt.Errorf("%w: none of %s can be read", errNoValidSources, strings.Join(missing, ", "))
+	case !config.AllowMissingSource:
+		return fmt.Errorf("source %s can't be read; set allow_missing_source to back up the others", strings.Join(missing, ", "))
+	}
+	log.Warn().Strs("skipped", missing).Msg("Backing up the available sources only")
+	config.Source = available
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCheckSources(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	readable := filepath.Join(tmpDir, "readable")
+	unreadable := filepath.Join(tmpDir, "unreadable")
+	for _, dir := range []string{readable, unreadable} {
+		if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "sub", "file.txt"), []byte("data"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	secret := filepath.Join(unreadable, "sub", "file.txt")
+
+	// Root can read anything, so permission errors are simulated.
+	oldOpen := openSource
+	openSource = func(name string) (*os.File, error) {
+		if name == secret {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+		}
+		return oldOpen(name)
+	}
+	defer func() { openSource = oldOpen }()
+
+	config := &Config{Source: []string{readable}}
+	problems, err := checkSources(context.Background(), config)
+	if err != nil {
+		t.Fatalf("checkSources failed: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Expected a readable source to pass, got %v", problems)
+	}
+
+	missing := filepath.Join(tmpDir, "missing")
+	config.Source = []string{readable, unreadable, missing}
+	problems, err = checkSources(context.Background(), config)
+	if err != nil {
+		t.Fatalf("checkSources failed: %v", err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("Expected 2 problems, got %v", problems)
+	}
+	if problems[0].Source != unreadable || problems[0].Path != secret || !strings.Contains(problems[0].String(), "permission denied") {
+		t.Errorf("Expected a permission error for %s, got %v", secret, problems[0])
+	}
+	if problems[1].Source != missing || !os.IsNotExist(problems[1].Err) {
+		t.Errorf("Expected %s to be reported missing, got %v", missing, problems[1])
+	}
+
+	// Excluded paths aren't checked.
+	config.Source = []string{unreadable}
+	config.Exclude = []string{"file.txt"}
+	if problems, err = checkSources(context.Background(), config); err != nil || len(problems) != 0 {
+		t.Errorf("Expected excluded files to be skipped, got %v (%v)", problems, err)
+	}
+}
+
+func TestRunSnapshotBackupNoValidSources(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, "", 0)
+	defer func() { execCommand = exec.CommandContext }()
+
+	config := &Config{
+		Destination:        filepath.Join(tmpDir, "dest"),
+		SnapshotPrefix:     "test",
+		Source:             []string{filepath.Join(tmpDir, "missing1"), filepath.Join(tmpDir, "missing2")},
+		AllowMissingSource: true,
+	}
+	err = runSnapshotBackup(context.Background(), config, runOptions{}, false)
+	if !errors.Is(err, errNoValidSources) {
+		t.Fatalf("Expected errNoValidSources, got %v", err)
+	}
+	if gotArgs != nil {
+		t.Errorf("Expected rsync not to run, got %v", gotArgs)
+	}
+	if _, err := os.Stat(filepath.Join(config.Destination, ".unfinished")); !os.IsNotExist(err) {
+		t.Errorf("Expected no .unfinished directory, got %v", err)
+	}
+}
+
+func TestSelectSourcesPartlyMissing(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	present := filepath.Join(tmpDir, "present")
+	missing := filepath.Join(tmpDir, "missing")
+	if err := os.Mkdir(present, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+
+	config := &Config{Source: []string{present, missing}}
+	err = selectSources(config)
+	if err == nil || errors.Is(err, errNoValidSources) || !strings.Contains(err.Error(), "allow_missing_source") {
+		t.Errorf("Expected an error suggesting allow_missing_source, got %v", err)
+	}
+
+	config.AllowMissingSource = true
+	if err := selectSources(config); err != nil {
+		t.Fatalf("selectSources failed: %v", err)
+	}
+	if !slices.Equal(config.Source, []string{present}) {
+		t.Errorf("Expected only %s to be backed up, got %v", present, config.Source)
+	}
+
+	if err := validateConfig(&Config{Mode: "simple", AllowMissingSource: true}); err == nil {
+		t.Error("Expected allow_missing_source to be rejected in simple mode")
+	}
+}
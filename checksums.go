@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// checksumFile is the name of the SHA-256 manifest written into a
+	// snapshot.
+	checksumFile = "checksums.sha256"
+	// checksumSuffix follows the snapshot name of a manifest kept in log_dir.
+	checksumSuffix = "." + checksumFile
+)
+
+// skipChecksum reports whether the snapshot-relative path rel is one of
+// goback's own files rather than backed up data. The logs and the manifest
+// are only in the snapshot when config has no log_dir.
+func skipChecksum(config *Config, rel string) bool {
+	switch rel {
+	case namedSnapshotMarker, tagsFile, signatureFile, solidifiedMarker:
+		return true
+	case checksumFile, "rsync.log", "changes.log":
+		return config.LogDir == ""
+	}
+	return false
+}
+
+// checksumPath returns where the manifest of the snapshot called name, whose
+// contents are in dir, is kept: in log_dir if config has one, so that the
+// snapshot holds nothing but backed up data, and in dir otherwise.
+func checksumPath(config *Config, name, dir string) string {
+	if config.LogDir != "" {
+		return filepath.Join(config.LogDir, name+checksumSuffix)
+	}
+	return filepath.Join(dir, checksumFile)
+}
+
+// writeChecksums writes a manifest of the SHA-256 of every regular file in
+// dir, the contents of the snapshot called name, to its checksumPath in the
+// format used by sha256sum.
+func writeChecksums(config *Config, name, dir string) error {
+	f, err := os.Create(checksumPath(config, name, dir))
+	if err != nil {
+		return fmt.Errorf("failed to create checksum file: %w", err)
+	}
+	w := bufio.NewWriter(f)
+
+	err = walkSnapshotFiles(config, dir, func(rel string, path string) error {
+		sum, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, checksumLine(sum, rel))
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write checksum file: %w", err)
+	}
+	return nil
+}
+
+var (
+	checksumEscaper   = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r")
+	checksumUnescaper = strings.NewReplacer("\\\\", "\\", "\\n", "\n", "\\r", "\r")
+)
+
+// checksumLine formats a manifest line the way sha256sum does: a name with
+// a backslash or line break in it is escaped, and the line then starts
+// with a backslash.
+func checksumLine(sum, rel string) string {
+	if strings.ContainsAny(rel, "\\\n\r") {
+		return "\\" + sum + "  " + checksumEscaper.Replace(rel)
+	}
+	return sum + "  " + rel
+}
+
+// parseChecksumLine undoes checksumLine.
+func parseChecksumLine(line string) (sum, rel string, ok bool) {
+	escaped := strings.HasPrefix(line, "\\")
+	if escaped {
+		line = line[1:]
+	}
+	sum, rel, ok = strings.Cut(line, "  ")
+	if escaped {
+		rel = checksumUnescaper.Replace(rel)
+	}
+	return sum, rel, ok
+}
+
+// verifySnapshotChecksums runs verifyChecksums on the snapshot of config
+// called name.
+func verifySnapshotChecksums(ctx context.Context, config *Config, name string) ([]string, error) {
+	snapshots, err := configSnapshots(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range snapshots {
+		if s.Name() != name {
+			continue
+		}
+		if !s.IsDir() {
+			return nil, fmt.Errorf("snapshot %s is an archive, which has no checksum file", name)
+		}
+		return verifyChecksums(config, name, snapshotContents(config, name))
+	}
+	return nil, fmt.Errorf("no snapshot named %s in %s", name, snapshotsDir(config))
+}
+
+// verifyChecksums recomputes the checksums of the files in dir, the contents
+// of the snapshot called name, and compares them against its manifest. It
+// returns a description of every file that doesn't match, is missing, or
+// isn't listed in the manifest.
+func verifyChecksums(config *Config, name, dir string) ([]string, error) {
+	f, err := os.Open(checksumPath(config, name, dir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checksum file: %w", err)
+	}
+	//nolint:errcheck
+	defer f.Close()
+
+	expected := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		sum, rel, ok := parseChecksumLine(scanner.Text())
+		if !ok {
+			return nil, fmt.Errorf("malformed checksum line %q", scanner.Text())
+		}
+		expected[rel] = sum
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read checksum file: %w", err)
+	}
+
+	var problems []string
+	seen := make(map[string]bool)
+	err = walkSnapshotFiles(config, dir, func(rel string, path string) error {
+		seen[rel] = true
+		want, ok := expected[rel]
+		if !ok {
+			problems = append(problems, rel+": not in checksum file")
+			return nil
+		}
+		got, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		if got != want {
+			problems = append(problems, rel+": checksum mismatch")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for rel := range expected {
+		if !seen[rel] {
+			problems = append(problems, rel+": missing")
+		}
+	}
+	return problems, nil
+}
+
+// walkSnapshotFiles calls fn for every regular file in dir that holds backed
+// up data, passing its slash separated path relative to dir.
+func walkSnapshotFiles(config *Config, dir string, fn func(rel string, path string) error) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if skipChecksum(config, rel) {
+			return nil
+		}
+		return fn(rel, path)
+	})
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	//nolint:errcheck
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
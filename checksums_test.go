@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChecksums(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{
+		"a.txt":         "alpha",
+		"sub/b.txt":     "bravo",
+		"sub/deep/c.db": "charlie",
+		"rsync.log":     "not backed up data",
+	}
+	for rel, content := range files {
+		path := filepath.Join(tmpDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	if err := writeChecksums(&Config{}, "test", tmpDir); err != nil {
+		t.Fatalf("writeChecksums failed: %v", err)
+	}
+	manifest, err := os.ReadFile(filepath.Join(tmpDir, checksumFile))
+	if err != nil {
+		t.Fatalf("Failed to read checksum file: %v", err)
+	}
+	// sha256("alpha")
+	if !strings.Contains(string(manifest), "8ed3f6ad685b959ead7022518e1af76cd816f8e8ec7ccdda1ed4018e8f2223f8  a.txt\n") {
+		t.Errorf("Unexpected checksum file contents:\n%s", manifest)
+	}
+	if strings.Contains(string(manifest), "rsync.log") {
+		t.Errorf("Expected rsync.log to be left out of the checksum file:\n%s", manifest)
+	}
+
+	problems, err := verifyChecksums(&Config{}, "test", tmpDir)
+	if err != nil {
+		t.Fatalf("verifyChecksums failed: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Expected an untouched snapshot to verify, got %v", problems)
+	}
+
+	// Tamper with one file, remove another and add a third.
+	if err := os.WriteFile(filepath.Join(tmpDir, "sub/b.txt"), []byte("bravo!"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(tmpDir, "a.txt")); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "new.txt"), []byte("new"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	problems, err = verifyChecksums(&Config{}, "test", tmpDir)
+	if err != nil {
+		t.Fatalf("verifyChecksums failed: %v", err)
+	}
+	sort.Strings(problems)
+	want := []string{"a.txt: missing", "new.txt: not in checksum file", "sub/b.txt: checksum mismatch"}
+	if strings.Join(problems, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected problems %v, got %v", want, problems)
+	}
+}
+
+func TestChecksumsEscapeNames(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, name := range []string{"line\nbreak.txt", `back\slash.txt`, "plain.txt"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte("alpha"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	if err := writeChecksums(&Config{}, "test", tmpDir); err != nil {
+		t.Fatalf("writeChecksums failed: %v", err)
+	}
+	manifest, err := os.ReadFile(filepath.Join(tmpDir, checksumFile))
+	if err != nil {
+		t.Fatalf("Failed to read checksum file: %v", err)
+	}
+	// As sha256sum writes them.
+	const sum = "8ed3f6ad685b959ead7022518e1af76cd816f8e8ec7ccdda1ed4018e8f2223f8"
+	for _, line := range []string{`\` + sum + `  line\nbreak.txt`, `\` + sum + `  back\\slash.txt`, sum + "  plain.txt"} {
+		if !strings.Contains(string(manifest), line+"\n") {
+			t.Errorf("Expected line %q in checksum file:\n%s", line, manifest)
+		}
+	}
+
+	problems, err := verifyChecksums(&Config{}, "test", tmpDir)
+	if err != nil {
+		t.Fatalf("verifyChecksums failed: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Expected escaped names to verify, got %v", problems)
+	}
+}
+
+func TestChecksumsInLogDir(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	logDir := filepath.Join(tmpDir, "logs")
+	snapshot := filepath.Join(tmpDir, "test_snapshot")
+	for _, dir := range []string{logDir, snapshot} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+	// With log_dir, the logs aren't written into the snapshot, so files with
+	// their names are backed up data.
+	for _, name := range []string{"a.txt", "rsync.log", checksumFile} {
+		if err := os.WriteFile(filepath.Join(snapshot, name), []byte("alpha"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	config := &Config{LogDir: logDir}
+
+	if err := writeChecksums(config, "test_snapshot", snapshot); err != nil {
+		t.Fatalf("writeChecksums failed: %v", err)
+	}
+	manifest, err := os.ReadFile(filepath.Join(logDir, "test_snapshot"+checksumSuffix))
+	if err != nil {
+		t.Fatalf("Failed to read checksum file: %v", err)
+	}
+	for _, name := range []string{"a.txt", "rsync.log", checksumFile} {
+		if !strings.Contains(string(manifest), "  "+name+"\n") {
+			t.Errorf("Expected %s in checksum file:\n%s", name, manifest)
+		}
+	}
+	if content, _ := os.ReadFile(filepath.Join(snapshot, checksumFile)); string(content) != "alpha" {
+		t.Errorf("Expected the snapshot's own %s to be left alone, got %q", checksumFile, content)
+	}
+
+	problems, err := verifyChecksums(config, "test_snapshot", snapshot)
+	if err != nil || len(problems) != 0 {
+		t.Errorf("Expected the snapshot to verify, got %v (%v)", problems, err)
+	}
+}
+
+func TestVerifySnapshotChecksums(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dest := filepath.Join(tmpDir, "dest")
+	name := "test_" + time.Now().Format(snapshotTimeFormat)
+	snapshot := filepath.Join(dest, name)
+	outside := filepath.Join(tmpDir, "outside")
+	for _, dir := range []string{snapshot, outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("alpha"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if err := writeChecksums(&Config{}, name, dir); err != nil {
+			t.Fatalf("writeChecksums failed: %v", err)
+		}
+	}
+	config := &Config{Destination: dest, SnapshotPrefix: "test"}
+
+	problems, err := verifySnapshotChecksums(context.Background(), config, name)
+	if err != nil || len(problems) != 0 {
+		t.Errorf("Expected %s to verify, got %v (%v)", name, problems, err)
+	}
+	// Only snapshots of the configuration can be verified, so a name can't
+	// reach outside the destination.
+	for _, bad := range []string{"../outside", outside, "test_missing"} {
+		if _, err := verifySnapshotChecksums(context.Background(), config, bad); err == nil {
+			t.Errorf("Expected %q to be refused", bad)
+		}
+	}
+}
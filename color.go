@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/mattn/go-isatty"
+)
+
+// ANSI color codes.
+const (
+	colorRed   = "31"
+	colorGreen = "32"
+)
+
+// colorOutput is whether log output goes to a terminal that should get
+// colors. setupLogging sets it.
+var colorOutput bool
+
+// useColor reports whether output to out should be colored: it must be a
+// terminal, and neither -no-color nor the NO_COLOR environment variable may
+// be set (see https://no-color.org).
+func useColor(out io.Writer) bool {
+	if *noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	f, ok := out.(*os.File)
+	return ok && isatty.IsTerminal(f.Fd())
+}
+
+// colorize wraps s in the given color if output is colored.
+func colorize(s, color string) string {
+	if !colorOutput {
+		return s
+	}
+	return "\x1b[" + color + "m" + s + "\x1b[0m"
+}
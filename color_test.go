@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+func TestNoColorWhenRedirected(t *testing.T) {
+	oldLogger := log.Logger
+	defer func() { log.Logger = oldLogger }()
+
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	now := time.Now()
+	for i := 1; i <= 2; i++ {
+		modTime := now.AddDate(0, 0, -i)
+		path := filepath.Join(tmpDir, "test_"+modTime.Format(snapshotTimeFormat))
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	setupLogging(&buf, &Config{})
+	log.Warn().Msg("careful")
+	log.Error().Msg("broken")
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Keep: Keep{Daily: 1}}
+	if err := purgeBackups(context.Background(), config, true); err != nil {
+		t.Fatalf("purgeBackups failed: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "Keeping snapshot") || !strings.Contains(out, "Would purge") {
+		t.Fatalf("Expected keep and purge lines, got %q", out)
+	}
+	if strings.Contains(out, "\x1b[") {
+		t.Errorf("Expected no ANSI codes in redirected output, got %q", out)
+	}
+}
+
+func TestColorize(t *testing.T) {
+	defer func() { colorOutput = false }()
+
+	colorOutput = true
+	if got := colorize("kept", colorGreen); got != "\x1b[32mkept\x1b[0m" {
+		t.Errorf("Expected green text, got %q", got)
+	}
+	colorOutput = false
+	if got := colorize("kept", colorGreen); got != "kept" {
+		t.Errorf("Expected plain text, got %q", got)
+	}
+
+	t.Setenv("NO_COLOR", "1")
+	if useColor(os.Stdout) {
+		t.Errorf("Expected no color with NO_COLOR set")
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rs/zerolog/log"
+)
+
+// compactSnapshots stores every snapshot older than compact_after as a
+// .tar.zst archive at zstd's best compression level. Directory snapshots
+// are archived and removed, and .tar and .tar.gz archives are recompressed.
+// .tar.zst archives are only written here, so they are left as they are.
+// Each archive keeps its snapshot's name and modification time, so
+// retention treats it as before. The latest snapshot, which the next backup
+// links against, snapshots named with -name and snapshots protected by
+// purge_exclude or protect_tagged are left alone; archiving would drop the
+// tags file.
+func compactSnapshots(ctx context.Context, config *Config, dryRun bool) error {
+	if nativeBackend(config) {
+		return fmt.Errorf("%s snapshots are subvolumes, not directories that can be archived", config.SnapshotBackend)
+	}
+	if config.CompactAfter == "" {
+		return fmt.Errorf("compact_after is not set")
+	}
+	// validateConfig has already checked the duration.
+	age, _ := time.ParseDuration(config.CompactAfter)
+	snapshots, err := configSnapshots(ctx, config) // sorted oldest to newest
+	if err != nil {
+		return err
+	}
+	if len(snapshots) > 0 {
+		snapshots = snapshots[:len(snapshots)-1]
+	}
+
+	var compacted int
+	var saved int64
+	for _, s := range snapshots {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if timeNow().Sub(s.ModTime()) < age {
+			continue
+		}
+		name := s.Name()
+		base, isArchive := trimArchiveSuffix(name)
+		dest := filepath.Join(config.Destination, base+".tar.zst")
+		switch {
+		case !isArchive && isNamedSnapshot(config.Destination, fs.FileInfoToDirEntry(s)):
+			continue
+		case protectedBy(config, name) != "":
+			log.Info().Str("snapshot", name).Str("reason", protectedBy(config, name)).Msg("Not compacting protected snapshot")
+			continue
+		case strings.HasSuffix(name, ".tar.zst"):
+			continue
+		}
+		if dryRun {
+			log.Info().Str("snapshot", name).Str("to", dest).Msg("[Dry Run] Would compact snapshot")
+			compacted++
+			continue
+		}
+
+		freed, err := compactSnapshot(ctx, config, s, dest)
+		if err != nil {
+			return fmt.Errorf("failed to compact snapshot %s: %w", name, err)
+		}
+		log.Info().Str("snapshot", name).Str("to", filepath.Base(dest)).Str("saved", humanizeSigned(freed)).Msg("Compacted snapshot")
+		compacted++
+		saved += freed
+	}
+	log.Info().Int("snapshots", compacted).Str("saved", humanizeSigned(saved)).Msg("Compaction finished")
+	return nil
+}
+
+// compactSnapshot writes the snapshot s as the archive dest, removes the
+// original and returns the bytes saved. Files a directory snapshot shares
+// with other snapshots through hard links stay on disk, so the saving can
+// be negative.
+func compactSnapshot(ctx context.Context, config *Config, s os.FileInfo, dest string) (int64, error) {
+	path := filepath.Join(config.Destination, s.Name())
+	var before int64
+	var err error
+	if s.IsDir() {
+		if before, err = unsharedSize(path); err != nil {
+			return 0, err
+		}
+		if err := createArchive(path, dest, zstdBestCompressor); err != nil {
+			return 0, err
+		}
+	} else {
+		before = s.Size()
+		if err := recompressArchive(path, dest); err != nil {
+			return 0, err
+		}
+	}
+	if err := os.Chtimes(dest, s.ModTime(), s.ModTime()); err != nil {
+		return 0, fmt.Errorf("failed to set archive time: %w", err)
+	}
+	info, err := os.Stat(dest)
+	if err != nil {
+		return 0, err
+	}
+	if err := removeSnapshot(ctx, config, s.Name()); err != nil {
+		return 0, err
+	}
+	return before - info.Size(), nil
+}
+
+// zstdBestCompressor compresses at zstd's best level, which is slow but
+// suits archives that are written once and rarely read.
+func zstdBestCompressor(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+}
+
+// recompressArchive writes the tar stream of the archive at src to dest
+// with zstdBestCompressor, under a temporary name renamed into place once
+// complete.
+func recompressArchive(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck
+	defer in.Close()
+	var r io.Reader = bufio.NewReader(in)
+	if strings.HasSuffix(src, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		r = gz
+	}
+
+	tmpFile := filepath.Join(filepath.Dir(dest), ".unfinished.zst")
+	out, err := os.Create(tmpFile)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	//nolint:errcheck
+	defer os.Remove(tmpFile)
+	zw, err := zstdBestCompressor(out)
+	if err != nil {
+		//nolint:errcheck
+		out.Close()
+		return err
+	}
+	_, err = io.Copy(zw, r)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := os.Rename(tmpFile, dest); err != nil {
+		return fmt.Errorf("failed to rename archive: %w", err)
+	}
+	return nil
+}
+
+// unsharedSize returns the bytes of the regular files in dir that have no
+// other hard links, which is what removing dir is sure to free.
+func unsharedSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Nlink > 1 {
+			return nil
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// humanizeSigned is humanizeBytes for a value that may be negative.
+func humanizeSigned(n int64) string {
+	if n < 0 {
+		return "-" + humanizeBytes(-n)
+	}
+	return humanizeBytes(n)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompactSnapshots(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Now()
+	var names []string
+	for _, days := range []int{100, 10, 1} {
+		modTime := now.AddDate(0, 0, -days)
+		name := "test_" + modTime.Format(snapshotTimeFormat)
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Join(path, "sub"), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		data := strings.Repeat("compressible ", 1000)
+		if err := os.WriteFile(filepath.Join(path, "sub", "file.txt"), []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+		names = append(names, name)
+	}
+
+	// An archive snapshot written with gzip.
+	gzTime := now.AddDate(0, 0, -50)
+	gzName := "test_" + gzTime.Format(snapshotTimeFormat)
+	if err := createArchive(filepath.Join(tmpDir, names[1]), filepath.Join(tmpDir, gzName+".tar.gz"), gzipCompressor); err != nil {
+		t.Fatalf("createArchive failed: %v", err)
+	}
+	if err := os.Chtimes(filepath.Join(tmpDir, gzName+".tar.gz"), gzTime, gzTime); err != nil {
+		t.Fatalf("Failed to set mod time: %v", err)
+	}
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", CompactAfter: "720h"}
+	if err := compactSnapshots(context.Background(), config, true); err != nil {
+		t.Fatalf("compactSnapshots dry run failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, names[0])); err != nil {
+		t.Errorf("Expected a dry run to leave the snapshot alone: %v", err)
+	}
+
+	if err := compactSnapshots(context.Background(), config, false); err != nil {
+		t.Fatalf("compactSnapshots failed: %v", err)
+	}
+	archive := filepath.Join(tmpDir, names[0]+".tar.zst")
+	if _, err := os.Stat(filepath.Join(tmpDir, names[0])); !os.IsNotExist(err) {
+		t.Errorf("Expected the old snapshot directory to be replaced, got %v", err)
+	}
+
+	// The archives are still snapshots, with their original times.
+	snapshots, err := getSnapshots(context.Background(), tmpDir)
+	if err != nil {
+		t.Fatalf("getSnapshots failed: %v", err)
+	}
+	if len(snapshots) != 4 || snapshots[0].Name() != names[0]+".tar.zst" || snapshots[1].Name() != gzName+".tar.zst" {
+		t.Fatalf("Expected the archives to be the oldest of 4 snapshots, got %v", snapshots)
+	}
+	if want := now.AddDate(0, 0, -100); !snapshots[0].ModTime().Truncate(time.Second).Equal(want.Truncate(time.Second)) {
+		t.Errorf("Expected the archive to keep its time %v, got %v", want, snapshots[0].ModTime())
+	}
+	for _, name := range names[1:] {
+		if info, err := os.Stat(filepath.Join(tmpDir, name)); err != nil || !info.IsDir() {
+			t.Errorf("Expected the recent snapshot %s to be left alone (%v)", name, err)
+		}
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer f.Close()
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	found := false
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		if hdr.Name == "sub/file.txt" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected sub/file.txt in the archive")
+	}
+}
+
+func TestCompactSnapshotsSkipsProtected(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var names []string
+	for _, days := range []int{100, 1} {
+		modTime := time.Now().AddDate(0, 0, -days)
+		name := "test_" + modTime.Format(snapshotTimeFormat)
+		if err := os.Mkdir(filepath.Join(tmpDir, name), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.Chtimes(filepath.Join(tmpDir, name), modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+		names = append(names, name)
+	}
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", CompactAfter: "720h", ProtectTagged: []string{"keep"}}
+	if _, err := tagSnapshot(context.Background(), config, names[0], []string{"keep"}, false); err != nil {
+		t.Fatalf("tagSnapshot failed: %v", err)
+	}
+
+	if err := compactSnapshots(context.Background(), config, false); err != nil {
+		t.Fatalf("compactSnapshots failed: %v", err)
+	}
+	if protectingTag(config, names[0]) != "keep" {
+		t.Errorf("Expected %s to stay a tagged directory", names[0])
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, names[0]+".tar.zst")); !os.IsNotExist(err) {
+		t.Errorf("Expected no archive of the protected snapshot, got %v", err)
+	}
+}
+
+func TestCompactSnapshotsRejectsNativeBackends(t *testing.T) {
+	for _, backend := range []string{"btrfs", "zfs"} {
+		config := &Config{Destination: t.Name(), SnapshotPrefix: "test", CompactAfter: "720h", SnapshotBackend: backend}
+		if err := validateConfig(config); err == nil {
+			t.Errorf("Expected compact_after to be rejected with %s", backend)
+		}
+		if err := compactSnapshots(context.Background(), config, false); err == nil {
+			t.Errorf("Expected -compact to be rejected with %s", backend)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// deduplicateSnapshot replaces each file in dir that is identical to the
+// file at the same path in prev, with the same contents, size, modification
+// time, permissions and owner, by a hard link to it. rsync's --link-dest
+// already links most of them; this catches the files it copied anyway, such
+// as those transferred before a -resume. It returns how many files were
+// linked and the bytes freed.
+func deduplicateSnapshot(ctx context.Context, config *Config, dir, prev string) (int, int64, error) {
+	var linked int
+	var saved int64
+	err := walkSnapshotFiles(config, dir, func(rel, path string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		prevPath := filepath.Join(prev, filepath.FromSlash(rel))
+		prevInfo, err := os.Lstat(prevPath)
+		if err != nil || os.SameFile(info, prevInfo) || !unchanged(info, prevInfo) {
+			return nil
+		}
+		same, err := sameContents(path, prevPath)
+		if err != nil {
+			return err
+		}
+		if !same {
+			return nil
+		}
+		if err := replaceWithLink(prevPath, path); err != nil {
+			// Too many links to the previous file, say; keep the copy.
+			log.Warn().Err(err).Str("path", path).Msg("Could not hard link to the previous snapshot")
+			return nil
+		}
+		linked++
+		saved += info.Size()
+		return nil
+	})
+	if err != nil {
+		return linked, saved, fmt.Errorf("failed to deduplicate snapshot: %w", err)
+	}
+	return linked, saved, nil
+}
+
+func sameContents(a, b string) (bool, error) {
+	sumA, err := fileChecksum(a)
+	if err != nil {
+		return false, err
+	}
+	sumB, err := fileChecksum(b)
+	if err != nil {
+		return false, err
+	}
+	return sumA == sumB, nil
+}
+
+// replaceWithLink makes path a hard link to target. The link is created
+// beside path and renamed over it, so path is never missing.
+func replaceWithLink(target, path string) error {
+	tmp := path + ".goback-dedupe"
+	if err := os.Link(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		//nolint:errcheck
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
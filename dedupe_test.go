@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeduplicateSnapshot(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	prev := filepath.Join(tmpDir, "prev")
+	next := filepath.Join(tmpDir, "next")
+	fileTime := time.Now().AddDate(0, 0, -1)
+	prevFiles := map[string]string{
+		"same.txt":     "unchanged",
+		"sub/same.txt": "unchanged too",
+		"changed.txt":  "old",
+		"retimed.txt":  "same data",
+	}
+	nextFiles := map[string]string{
+		"same.txt":     "unchanged",
+		"sub/same.txt": "unchanged too",
+		"changed.txt":  "new",
+		"retimed.txt":  "same data",
+	}
+	for dir, contents := range map[string]map[string]string{prev: prevFiles, next: nextFiles} {
+		for rel, data := range contents {
+			path := filepath.Join(dir, rel)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("Failed to create dir: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+			modTime := fileTime
+			if dir == next && rel == "retimed.txt" {
+				modTime = fileTime.Add(time.Hour)
+			}
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatalf("Failed to set mod time: %v", err)
+			}
+		}
+	}
+
+	linked, saved, err := deduplicateSnapshot(context.Background(), &Config{}, next, prev)
+	if err != nil {
+		t.Fatalf("deduplicateSnapshot failed: %v", err)
+	}
+	if linked != 2 || saved != int64(len("unchanged")+len("unchanged too")) {
+		t.Errorf("Expected 2 files and %d bytes deduplicated, got %d and %d", len("unchanged")+len("unchanged too"), linked, saved)
+	}
+	for rel, want := range map[string]bool{"same.txt": true, "sub/same.txt": true, "changed.txt": false, "retimed.txt": false} {
+		a, err := os.Stat(filepath.Join(prev, rel))
+		if err != nil {
+			t.Fatalf("Failed to stat file: %v", err)
+		}
+		b, err := os.Stat(filepath.Join(next, rel))
+		if err != nil {
+			t.Fatalf("Failed to stat file: %v", err)
+		}
+		if os.SameFile(a, b) != want {
+			t.Errorf("Expected %s sharing an inode to be %v", rel, want)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(next, "changed.txt")); err != nil || string(data) != "new" {
+		t.Errorf("Expected changed.txt to keep its contents, got %q (%v)", data, err)
+	}
+
+	// A second pass has nothing left to do.
+	if linked, _, err := deduplicateSnapshot(context.Background(), &Config{}, next, prev); err != nil || linked != 0 {
+		t.Errorf("Expected nothing to deduplicate again, got %d (%v)", linked, err)
+	}
+}
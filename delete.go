@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// deleteSnapshots deletes the named snapshots. Every name is checked before
+// anything is deleted: it must be a snapshot of config's prefix, must not be
+// protected by purge_exclude or protect_tagged, and must not be the latest
+// snapshot unless force is set.
+func deleteSnapshots(ctx context.Context, config *Config, names []string, force, dryRun bool) error {
+	snapshots, err := configSnapshots(ctx, config) // sorted oldest to newest
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool)
+	for _, s := range snapshots {
+		exists[s.Name()] = true
+	}
+	var latest string
+	if len(snapshots) > 0 {
+		latest = snapshots[len(snapshots)-1].Name()
+	}
+
+	for _, name := range names {
+		switch {
+		case !exists[name]:
+			return fmt.Errorf("no snapshot named %s in %s", name, config.Destination)
+		case protectedBy(config, name) != "":
+			return fmt.Errorf("snapshot %s is protected by %s", name, protectedBy(config, name))
+		case name == latest && !force:
+			return fmt.Errorf("snapshot %s is the latest snapshot; use -force to delete it", name)
+		}
+	}
+
+	if !dryRun {
+		if err := checkRemovable(config); err != nil {
+			return fmt.Errorf("not deleting: %w", err)
+		}
+	}
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if dryRun {
+			log.Info().Str("path", filepath.Join(config.Destination, name)).Msg(colorize("[Dry Run] Would delete snapshot", colorRed))
+			continue
+		}
+		if err := removeSnapshot(ctx, config, name); err != nil {
+			return fmt.Errorf("failed to delete snapshot %s: %w", name, err)
+		}
+		log.Info().Str("snapshot", name).Msg(colorize("Deleted snapshot", colorRed))
+		events.SnapshotPurged(SnapshotPurgedEvent{Snapshot: name})
+	}
+	return nil
+}
+
+// splitNames splits a comma separated list of snapshot names.
+func splitNames(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
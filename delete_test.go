@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeleteSnapshots(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Now()
+	var names []string
+	for i := 4; i >= 1; i-- {
+		modTime := now.AddDate(0, 0, -i)
+		name := "test_" + modTime.Format(snapshotTimeFormat)
+		if i == 3 {
+			name += "-pre-upgrade"
+		}
+		path := filepath.Join(tmpDir, name)
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+		names = append(names, name)
+	}
+	oldest, pinned, middle, latest := names[0], names[1], names[2], names[3]
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", PurgeExclude: []string{"*-pre-upgrade"}}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(tmpDir, name))
+		return err == nil
+	}
+
+	if err := deleteSnapshots(context.Background(), config, []string{oldest}, false, true); err != nil {
+		t.Fatalf("deleteSnapshots dry run failed: %v", err)
+	}
+	if !exists(oldest) {
+		t.Errorf("Expected a dry run to leave %s alone", oldest)
+	}
+
+	for _, refused := range [][]string{{"test_missing"}, {pinned}, {latest}, {middle, latest}} {
+		if err := deleteSnapshots(context.Background(), config, refused, false, false); err == nil {
+			t.Errorf("Expected deleting %v to be refused", refused)
+		}
+	}
+	for _, name := range names {
+		if !exists(name) {
+			t.Errorf("Expected %s to survive refused deletions", name)
+		}
+	}
+
+	if err := deleteSnapshots(context.Background(), config, splitNames(oldest+", "+middle), false, false); err != nil {
+		t.Fatalf("deleteSnapshots failed: %v", err)
+	}
+	if exists(oldest) || exists(middle) {
+		t.Errorf("Expected %s and %s to be deleted", oldest, middle)
+	}
+	if !exists(pinned) || !exists(latest) {
+		t.Errorf("Expected %s and %s to be kept", pinned, latest)
+	}
+
+	if err := deleteSnapshots(context.Background(), config, []string{latest}, true, false); err != nil {
+		t.Fatalf("deleteSnapshots with force failed: %v", err)
+	}
+	if exists(latest) {
+		t.Errorf("Expected -force to delete the latest snapshot")
+	}
+}
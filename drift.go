@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// driftReport describes how far the latest snapshot has drifted from the
+// live source.
+type driftReport struct {
+	Snapshot string
+	Files    int64 // regular files in the source
+	Changed  int   // files that differ from, or are missing in, the snapshot
+}
+
+// Percent returns the changed files as a percentage of the source's files.
+func (r driftReport) Percent() float64 {
+	if r.Files == 0 {
+		if r.Changed == 0 {
+			return 0
+		}
+		return 100
+	}
+	return float64(r.Changed) * 100 / float64(r.Files)
+}
+
+// sourceFilesLine matches the file count rsync prints with --stats, e.g.
+// "Number of files: 1,234 (reg: 1,000, dir: 234)".
+var sourceFilesLine = regexp.MustCompile(`^Number of files: \S+ \(reg: ([\d.,]+[KMGT]?)`)
+
+// parseDrift counts the changed files in rsync's itemized dry-run output and
+// the source's regular files from its stats.
+func parseDrift(output string) (files int64, changed int) {
+	for _, line := range strings.Split(output, "\n") {
+		if m := itemizedLine.FindStringSubmatch(line); m != nil {
+			if m[1] == "*deleting" || m[1][1] == 'f' {
+				changed++
+			}
+			continue
+		}
+		if m := sourceFilesLine.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			if n, err := parseRsyncNumber(m[1]); err == nil {
+				files = n
+			}
+		}
+	}
+	return files, changed
+}
+
+// compareToSource runs rsync in dry-run mode from the configured sources to
+// the latest snapshot, with the same filters as a backup, and reports how
+// many files would change.
+func compareToSource(ctx context.Context, config *Config) (driftReport, error) {
+	latest, err := getLatestSnapshot(ctx, config)
+	if err != nil {
+		return driftReport{}, err
+	}
+	if latest == "" {
+		return driftReport{}, fmt.Errorf("no snapshots found in %s", config.Destination)
+	}
+	if _, ok := trimArchiveSuffix(latest); ok {
+		return driftReport{}, fmt.Errorf("latest snapshot %s is an archive and can't be compared", latest)
+	}
+
+	out, err := itemizedDryRun(ctx, config, snapshotContents(config, latest))
+	if err != nil {
+		return driftReport{}, err
+	}
+	files, changed := parseDrift(out)
+	return driftReport{Snapshot: latest, Files: files, Changed: changed}, nil
+}
+
+// itemizedDryRun runs rsync in dry-run mode from the configured sources to
+// dest, with the same filters as a backup and --itemize-changes, and returns
+// its output. extra flags are added after -a.
+func itemizedDryRun(ctx context.Context, config *Config, dest string, extra ...string) (string, error) {
+	// As a dry run, buildRsyncArgs already adds --itemize-changes.
+	args := buildRsyncArgs(config, dest, "", true)
+	args = append(append([]string{args[0]}, extra...), args[1:]...)
+
+	var out bytes.Buffer
+	cmd := execCommand(ctx, "rsync", args...)
+	setRsyncPassword(cmd, config)
+	setRecentFilesExcludes(cmd, config)
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("rsync command failed: %w", err)
+	}
+	return out.String(), nil
+}
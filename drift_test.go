@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompareToSource(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Now()
+	var latest string
+	for i := 2; i >= 1; i-- {
+		modTime := now.AddDate(0, 0, -i)
+		latest = "test_" + modTime.Format(snapshotTimeFormat)
+		path := filepath.Join(tmpDir, latest)
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+	}
+
+	// 3 of 40 files differ: two changed and one deleted from the source. The
+	// directory line doesn't count.
+	output := "sending incremental file list\n" +
+		".d..t...... home/user/\n" +
+		">f.st...... home/user/notes.txt\n" +
+		">f+++++++++ home/user/new.txt\n" +
+		"*deleting   home/user/old.txt\n" +
+		"\n" +
+		"Number of files: 45 (reg: 40, dir: 5)\n" +
+		"Number of regular files transferred: 2\n"
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, output, 0)
+	defer func() { execCommand = exec.CommandContext }()
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Source: []string{"/home/user"}}
+	report, err := compareToSource(context.Background(), config)
+	if err != nil {
+		t.Fatalf("compareToSource failed: %v", err)
+	}
+
+	if !hasArg(gotArgs, "--dry-run") || !hasArg(gotArgs, "--itemize-changes") {
+		t.Errorf("Expected an itemized dry run, got %v", gotArgs)
+	}
+	if gotArgs[len(gotArgs)-1] != filepath.Join(tmpDir, latest) {
+		t.Errorf("Expected comparison against %s, got %v", latest, gotArgs)
+	}
+	if report.Snapshot != latest || report.Files != 40 || report.Changed != 3 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	if got := report.Percent(); got != 7.5 {
+		t.Errorf("Expected 7.5%% drift, got %v", got)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import "time"
+
+// Events receives progress notifications at the milestones of a run. It lets
+// code embedding goback follow a backup without parsing the log output.
+type Events interface {
+	BackupStarted(BackupStartedEvent)
+	RsyncProgress(RsyncProgressEvent)
+	RsyncFinished(RsyncFinishedEvent)
+	SnapshotKept(SnapshotKeptEvent)
+	SnapshotPurged(SnapshotPurgedEvent)
+}
+
+// BackupStartedEvent is sent before rsync is started.
+type BackupStartedEvent struct {
+	Mode        string
+	Destination string
+	Snapshot    string // empty in simple mode
+	RunID       string
+}
+
+// RsyncProgressEvent is sent for every overall progress update rsync
+// prints when the progress option is set.
+type RsyncProgressEvent struct {
+	RunID            string
+	Bytes            int64         // transferred so far
+	Percent          int           // of the files rsync knows about so far
+	Rate             float64       // bytes per second
+	ETA              time.Duration // time left; the elapsed time once done
+	FilesTransferred int64
+	FilesToCheck     int64 // files rsync has yet to check
+	FilesTotal       int64 // files in the file list so far
+}
+
+// RsyncFinishedEvent is sent once rsync has exited successfully.
+type RsyncFinishedEvent struct {
+	Snapshot         string // empty in simple mode
+	RunID            string
+	Duration         time.Duration
+	FilesTransferred int64
+	TotalBytes       int64
+	TransferredBytes int64
+	ChangedFiles     int
+}
+
+// SnapshotKeptEvent is sent for every snapshot retained by purgeBackups.
+type SnapshotKeptEvent struct {
+	Snapshot string
+	Tier     string // "daily", "weekly" or "monthly"
+}
+
+// SnapshotPurgedEvent is sent for every snapshot purgeBackups deletes, or
+// would delete in a dry run.
+type SnapshotPurgedEvent struct {
+	Snapshot string
+	DryRun   bool
+}
+
+// noopEvents is the default Events used by the command line tool.
+type noopEvents struct{}
+
+func (noopEvents) BackupStarted(BackupStartedEvent)   {}
+func (noopEvents) RsyncProgress(RsyncProgressEvent)   {}
+func (noopEvents) RsyncFinished(RsyncFinishedEvent)   {}
+func (noopEvents) SnapshotKept(SnapshotKeptEvent)     {}
+func (noopEvents) SnapshotPurged(SnapshotPurgedEvent) {}
+
+var events Events = noopEvents{}
+
+func newRsyncFinishedEvent(snapshot string, stats *rsyncStats) RsyncFinishedEvent {
+	return RsyncFinishedEvent{
+		Snapshot:         snapshot,
+		RunID:            runID,
+		Duration:         stats.Duration,
+		FilesTransferred: stats.FilesTransferred,
+		TotalBytes:       stats.TotalBytes,
+		TransferredBytes: stats.TransferredBytes,
+		ChangedFiles:     stats.ChangedFiles,
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// recordingEvents records every event it receives as a short string.
+type recordingEvents struct {
+	got      []string
+	rsync    RsyncFinishedEvent
+	started  BackupStartedEvent
+	progress []RsyncProgressEvent
+}
+
+func (r *recordingEvents) BackupStarted(e BackupStartedEvent) {
+	r.started = e
+	r.got = append(r.got, "started")
+}
+
+func (r *recordingEvents) RsyncProgress(e RsyncProgressEvent) {
+	r.progress = append(r.progress, e)
+}
+
+func (r *recordingEvents) RsyncFinished(e RsyncFinishedEvent) {
+	r.rsync = e
+	r.got = append(r.got, "rsync")
+}
+
+func (r *recordingEvents) SnapshotKept(e SnapshotKeptEvent) {
+	r.got = append(r.got, fmt.Sprintf("kept %s %s", e.Snapshot, e.Tier))
+}
+
+func (r *recordingEvents) SnapshotPurged(e SnapshotPurgedEvent) {
+	r.got = append(r.got, fmt.Sprintf("purged %s", e.Snapshot))
+}
+
+func TestEventsSequence(t *testing.T) {
+	assumeSourcesExist(t)
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// An old snapshot that the policy below will purge.
+	oldTime := time.Now().AddDate(0, 0, -3)
+	oldName := "test_" + oldTime.Format(snapshotTimeFormat)
+	old := filepath.Join(tmpDir, oldName)
+	if err := os.Mkdir(old, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.Chtimes(old, oldTime, oldTime); err != nil {
+		t.Fatalf("Failed to set mod time: %v", err)
+	}
+
+	config := &Config{
+		Destination:    tmpDir,
+		SnapshotPrefix: "test",
+		Source:         []string{"/tmp/source1"},
+		Keep:           Keep{Daily: 1},
+	}
+
+	recorder := &recordingEvents{}
+	events = recorder
+	defer func() { events = noopEvents{} }()
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, "Number of regular files transferred: 2\nTotal file size: 2.00K bytes\nTotal transferred file size: 1.50K bytes\n", 0)
+	defer func() { execCommand = exec.CommandContext }()
+
+	if err := runSnapshotBackup(context.Background(), config, runOptions{}, false); err != nil {
+		t.Fatalf("runSnapshotBackup failed: %v", err)
+	}
+	if err := purgeBackups(context.Background(), config, false); err != nil {
+		t.Fatalf("purgeBackups failed: %v", err)
+	}
+
+	snapshot := recorder.started.Snapshot
+	want := []string{"started", "rsync", "kept " + snapshot + " daily", "purged " + oldName}
+	if !reflect.DeepEqual(recorder.got, want) {
+		t.Errorf("Expected events %v, got %v", want, recorder.got)
+	}
+	if recorder.rsync.Snapshot != snapshot {
+		t.Errorf("Expected rsync event for snapshot %s, got %s", snapshot, recorder.rsync.Snapshot)
+	}
+	if recorder.rsync.FilesTransferred != 2 || recorder.rsync.TotalBytes != 2000 || recorder.rsync.TransferredBytes != 1500 {
+		t.Errorf("Unexpected rsync event sizes: %+v", recorder.rsync)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// excludedEntry is a path rsync skipped because of a filter rule.
+type excludedEntry struct {
+	Path    string
+	Pattern string
+	IsDir   bool
+}
+
+// hidingLine matches the lines rsync prints at -vv when a filter rule
+// excludes a path, e.g.
+// "[sender] hiding directory home/cache because of pattern cache/".
+var hidingLine = regexp.MustCompile(`hiding (file|directory) (.+) because of pattern (.+)$`)
+
+// parseExcluded extracts the excluded paths from rsync's -vv output. rsync
+// doesn't descend into an excluded directory, so each excluded subtree
+// appears once.
+func parseExcluded(output string) []excludedEntry {
+	var entries []excludedEntry
+	for _, line := range strings.Split(output, "\n") {
+		m := hidingLine.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		entries = append(entries, excludedEntry{Path: m[2], Pattern: m[3], IsDir: m[1] == "directory"})
+	}
+	return entries
+}
+
+// listExcluded runs rsync in dry-run mode with extra verbosity and writes
+// every path the configured filters exclude to w.
+func listExcluded(ctx context.Context, config *Config, w io.Writer) error {
+	args := buildRsyncArgs(config, config.Destination, "", true)
+	args = append([]string{args[0], "-vv"}, args[1:]...)
+
+	var out bytes.Buffer
+	cmd := execCommand(ctx, "rsync", args...)
+	setRsyncPassword(cmd, config)
+	setRecentFilesExcludes(cmd, config)
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("rsync command failed: %w", err)
+	}
+
+	entries := parseExcluded(out.String())
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No paths are excluded.")
+		return nil
+	}
+	for _, e := range entries {
+		path := e.Path
+		if e.IsDir {
+			path += "/"
+		}
+		fmt.Fprintf(w, "%s (pattern %s)\n", path, e.Pattern)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestListExcluded(t *testing.T) {
+	output := "sending incremental file list\n" +
+		"[sender] hiding directory home/user/.cache because of pattern .cache/\n" +
+		"[sender] hiding file home/user/notes/debug.log because of pattern *.log\n" +
+		"home/user/notes/todo.txt\n" +
+		"[sender] showing file home/user/notes/todo.txt because of pattern *\n"
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, output, 0)
+	defer func() { execCommand = exec.CommandContext }()
+
+	config := &Config{
+		Destination: "/mnt/backups",
+		Source:      []string{"/home/user"},
+		Exclude:     []string{".cache/", "*.log"},
+	}
+	var out strings.Builder
+	if err := listExcluded(context.Background(), config, &out); err != nil {
+		t.Fatalf("listExcluded failed: %v", err)
+	}
+
+	if !hasArg(gotArgs, "-vv") || !hasArg(gotArgs, "--dry-run") {
+		t.Errorf("Expected a verbose dry run, got %v", gotArgs)
+	}
+	want := "home/user/.cache/ (pattern .cache/)\n" +
+		"home/user/notes/debug.log (pattern *.log)\n"
+	if out.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, out.String())
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// excludeURLCacheFile keeps the last exclude list fetched from exclude_url
+// in the stateFile of the destination, to fall back on when the server
+// can't be reached.
+const excludeURLCacheFile = ".goback-exclude-url"
+
+// excludeURLTimeout bounds how long fetching exclude_url may take.
+var excludeURLTimeout = 30 * time.Second
+
+// loadExcludeURL fetches the exclude list at exclude_url and adds its rules
+// to config.Exclude. If the fetch fails, the copy cached by the last
+// successful fetch is used instead.
+func loadExcludeURL(ctx context.Context, config *Config) error {
+	cache, err := stateFile(config, excludeURLCacheFile)
+	if err != nil {
+		return err
+	}
+	data, err := fetchExcludeURL(ctx, config.ExcludeURL)
+	if err != nil {
+		cached, cacheErr := os.ReadFile(cache)
+		if cacheErr != nil {
+			return fmt.Errorf("failed to fetch exclude_url and no cached copy is available: %w", err)
+		}
+		log.Warn().Err(err).Str("cache", cache).Msg("Could not fetch exclude_url, using the cached copy")
+		data = cached
+	} else if err := os.WriteFile(cache, data, 0644); err != nil {
+		log.Warn().Err(err).Msg("Could not cache exclude_url")
+	}
+
+	rules := parseExcludeList(string(data))
+	log.Info().Str("url", redactURL(config.ExcludeURL)).Int("rules", len(rules)).Msg("Loaded exclude rules")
+	config.Exclude = append(config.Exclude, rules...)
+	return nil
+}
+
+func fetchExcludeURL(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, excludeURLTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	//nolint:errcheck
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", redactURL(url), resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseExcludeList returns the rules in a newline separated exclude list,
+// skipping blank lines and # comments.
+func parseExcludeList(data string) []string {
+	var rules []string
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rules = append(rules, line)
+	}
+	return rules
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadExcludeURL(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	t.Setenv("XDG_STATE_HOME", filepath.Join(tmpDir, "state"))
+
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("# fleet excludes\n*.iso\n\n  /var/cache/  \n")) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	config := &Config{Destination: filepath.Join(tmpDir, "dest"), Exclude: []string{"*.tmp"}, ExcludeURL: server.URL}
+	if err := validateConfig(config); err != nil {
+		t.Fatalf("validateConfig failed: %v", err)
+	}
+	if err := loadExcludeURL(context.Background(), config); err != nil {
+		t.Fatalf("loadExcludeURL failed: %v", err)
+	}
+	want := "*.tmp,*.iso,/var/cache/"
+	if got := strings.Join(config.Exclude, ","); got != want {
+		t.Errorf("Expected excludes %s, got %s", want, got)
+	}
+	if args := buildRsyncArgs(config, "/dest", "", false); !hasArg(args, "--exclude=*.iso") {
+		t.Errorf("Expected the fetched rules in the rsync args, got %v", args)
+	}
+	// The cache is kept out of the destination, which rsync --delete
+	// mirrors in simple mode.
+	if _, err := os.Stat(filepath.Join(tmpDir, "dest")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written to the destination, got %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(tmpDir, "state", "goback")); len(entries) != 1 {
+		t.Errorf("Expected the cache in the state directory, got %v", entries)
+	}
+
+	// The server fails; the cached copy is used.
+	failing = true
+	config = &Config{Destination: filepath.Join(tmpDir, "dest"), ExcludeURL: server.URL}
+	if err := loadExcludeURL(context.Background(), config); err != nil {
+		t.Fatalf("loadExcludeURL with a failing server failed: %v", err)
+	}
+	if got := strings.Join(config.Exclude, ","); got != "*.iso,/var/cache/" {
+		t.Errorf("Expected the cached excludes, got %s", got)
+	}
+
+	// Without a cache, a failure is an error.
+	config = &Config{Destination: filepath.Join(tmpDir, "other"), ExcludeURL: server.URL}
+	if err := loadExcludeURL(context.Background(), config); err == nil {
+		t.Errorf("Expected an error with a failing server and no cache")
+	}
+
+	if err := validateConfig(&Config{ExcludeURL: "ftp://example.com/excludes"}); err == nil {
+		t.Errorf("Expected a non-HTTP exclude_url to be rejected")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// snapshotStub is an os.FileInfo for a snapshot that only exists in a
+// retention simulation, such as those future runs would create.
+type snapshotStub struct {
+	name    string
+	modTime time.Time
+	future  bool
+}
+
+func (p snapshotStub) Name() string       { return p.name }
+func (p snapshotStub) Size() int64        { return 0 }
+func (p snapshotStub) Mode() os.FileMode  { return os.ModeDir | 0755 }
+func (p snapshotStub) ModTime() time.Time { return p.modTime }
+func (p snapshotStub) IsDir() bool        { return true }
+func (p snapshotStub) Sys() any           { return nil }
+
+// estimateExpiry simulates daily runs from now on, each adding a snapshot
+// and purging as purgeBackups would, and returns the time of the run that
+// would purge each of snapshots (given oldest first). Snapshots protected
+// by purge_exclude or protect_tagged, or still kept when the simulation
+// ends, are missing from the result.
+func estimateExpiry(snapshots []os.FileInfo, config *Config, now time.Time) map[string]time.Time {
+	expiry := make(map[string]time.Time)
+	// Newest first, as retentionPlan expects.
+	var live []os.FileInfo
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		live = append(live, snapshots[i])
+	}
+
+	// Every snapshot has left all tiers and the grace period once the runs
+	// span the longest of them.
+	keep := config.Keep
+	// validateConfig has already checked the duration.
+	grace, _ := time.ParseDuration(config.PurgeGracePeriod)
+	days := keep.Daily + 7*keep.Weekly + 31*keep.Monthly + 31 + int(grace/(24*time.Hour)) + 1
+	for day := 0; day <= days && len(live) > 0; day++ {
+		run := now.AddDate(0, 0, day)
+		planned := snapshotStub{name: "planned_" + run.Format(snapshotTimeFormat), modTime: run, future: true}
+		live = append([]os.FileInfo{planned}, live...)
+
+		toKeep := retentionPlan(live, config, keep, run)
+		var remaining []os.FileInfo
+		for _, s := range live {
+			if _, ok := toKeep[s.Name()]; ok {
+				remaining = append(remaining, s)
+			} else if stub, ok := s.(snapshotStub); !ok || !stub.future {
+				expiry[s.Name()] = run
+			}
+		}
+		live = remaining
+	}
+	return expiry
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEstimateExpiry(t *testing.T) {
+	// Saturday, in ISO week 26 (June 23-29).
+	now := time.Date(2025, time.June, 28, 12, 0, 0, 0, time.Local)
+	var snapshots []os.FileInfo
+	for day := 20; day <= 27; day++ {
+		modTime := time.Date(2025, time.June, day, 2, 0, 0, 0, time.Local)
+		snapshots = append(snapshots, snapshotStub{name: "test_" + modTime.Format(snapshotTimeFormat), modTime: modTime})
+	}
+	name := func(day int) string { return snapshots[day-20].Name() }
+
+	config := &Config{Keep: Keep{Daily: 1, Weekly: 1}, PurgeExclude: []string{"*_2025-06-21_*"}}
+	expiry := estimateExpiry(snapshots, config, now)
+
+	want := map[string]string{
+		name(20): "2025-06-28", // superseded by the 22nd as week 25's snapshot
+		name(22): "2025-06-30", // week 25's weekly until week 26's takes over
+		name(23): "2025-06-28",
+		name(27): "2025-06-28", // the next run is the new daily
+	}
+	for n, date := range want {
+		if got, ok := expiry[n]; !ok || got.Format("2006-01-02") != date {
+			t.Errorf("Expected %s to expire on %s, got %v (%v)", n, date, got, ok)
+		}
+	}
+	if _, ok := expiry[name(21)]; ok {
+		t.Errorf("Expected protected %s never to expire", name(21))
+	}
+	if len(expiry) != len(snapshots)-1 {
+		t.Errorf("Expected every unprotected snapshot to expire, got %v", expiry)
+	}
+
+	// purge_grace_period holds on to recent snapshots past the run that
+	// would otherwise purge them.
+	config.PurgeGracePeriod = "72h"
+	expiry = estimateExpiry(snapshots, config, now)
+	if got := expiry[name(27)].Format("2006-01-02"); got != "2025-06-30" {
+		t.Errorf("Expected %s to expire once its grace period is over on 2025-06-30, got %s", name(27), got)
+	}
+	if got := expiry[name(23)].Format("2006-01-02"); got != "2025-06-28" {
+		t.Errorf("Expected %s, past its grace period, to expire on 2025-06-28, got %s", name(23), got)
+	}
+}
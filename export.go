@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// exportSnapshot copies a snapshot to to, a local path or anything else
+// rsync accepts as a destination such as host:path. Hard links within the
+// snapshot are kept, so the copy takes no more space than the snapshot's
+// own files.
+func exportSnapshot(ctx context.Context, config *Config, name, to string, dryRun bool) error {
+	if to == "" {
+		return fmt.Errorf("no export destination given; use -to")
+	}
+	snapshots, err := configSnapshots(ctx, config)
+	if err != nil {
+		return err
+	}
+	var found os.FileInfo
+	for _, s := range snapshots {
+		if s.Name() == name {
+			found = s
+		}
+	}
+	if found == nil {
+		return fmt.Errorf("no snapshot named %s in %s", name, snapshotsDir(config))
+	}
+
+	src := filepath.Join(snapshotsDir(config), name)
+	if found.IsDir() {
+		// Copy the contents, so that to holds the snapshot's files.
+		src += "/"
+	}
+	args := []string{"-a", "-H", "-v", "-h", "--stats"}
+	if dryRun {
+		args = append(args, "--dry-run")
+	}
+	args = append(args, src, to)
+
+	cmdName, args := withPriority(config, "rsync", args)
+	cmd := execCommand(ctx, cmdName, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	log.Info().Str("command", fmt.Sprintf("%s %s", cmdName, strings.Join(args, " "))).Msg("Running command")
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("export interrupted: %w", ctxErr)
+		}
+		return fmt.Errorf("rsync command failed: %w", err)
+	}
+	if dryRun {
+		log.Info().Str("snapshot", name).Str("to", to).Msg("[Dry Run] Would export snapshot")
+	} else {
+		log.Info().Str("snapshot", name).Str("to", to).Msg("Exported snapshot")
+	}
+	return nil
+}
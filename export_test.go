@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExportSnapshot(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	modTime := time.Now().AddDate(0, 0, -1)
+	name := "test_" + modTime.Format(snapshotTimeFormat)
+	if err := os.Mkdir(filepath.Join(tmpDir, name), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, "", 0)
+	defer func() { execCommand = exec.CommandContext }()
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test"}
+	to := filepath.Join(tmpDir, "export")
+	if err := exportSnapshot(context.Background(), config, name, to, false); err != nil {
+		t.Fatalf("exportSnapshot failed: %v", err)
+	}
+	n := len(gotArgs)
+	if n < 2 || gotArgs[n-2] != filepath.Join(tmpDir, name)+"/" || gotArgs[n-1] != to {
+		t.Errorf("Expected rsync to copy the snapshot's contents to %s, got %v", to, gotArgs)
+	}
+	if !hasArg(gotArgs, "-a") || !hasArg(gotArgs, "-H") || hasArg(gotArgs, "--dry-run") {
+		t.Errorf("Expected -a and -H without --dry-run, got %v", gotArgs)
+	}
+
+	if err := exportSnapshot(context.Background(), config, name, "backup.example.com:/srv/export", true); err != nil {
+		t.Fatalf("exportSnapshot dry run failed: %v", err)
+	}
+	if !hasArg(gotArgs, "--dry-run") || gotArgs[len(gotArgs)-1] != "backup.example.com:/srv/export" {
+		t.Errorf("Expected a dry run to the remote destination, got %v", gotArgs)
+	}
+
+	gotArgs = nil
+	if err := exportSnapshot(context.Background(), config, "test_missing", to, false); err == nil {
+		t.Errorf("Expected an error for a missing snapshot")
+	}
+	if err := exportSnapshot(context.Background(), config, name, "", false); err == nil {
+		t.Errorf("Expected an error without a destination")
+	}
+	if gotArgs != nil {
+		t.Errorf("Expected rsync not to run for invalid exports, got %v", gotArgs)
+	}
+}
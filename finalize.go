@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// incompleteMarker is created in a snapshot linkTree is still building and
+// removed once it is done, so a tree left half built by a crash isn't taken
+// for a snapshot; see getSnapshots.
+const incompleteMarker = ".goback-incomplete"
+
+// isIncomplete reports whether the directory at path is a snapshot linkTree
+// didn't finish.
+func isIncomplete(path string) bool {
+	_, err := os.Lstat(filepath.Join(path, incompleteMarker))
+	return err == nil
+}
+
+// finalizeSnapshot moves the finished working directory to finalDest. With
+// finalize_mode copy it is rebuilt at finalDest out of hard links and then
+// removed instead of renamed, for network filesystems where renaming a
+// directory is unreliable.
+func finalizeSnapshot(ctx context.Context, config *Config, unfinishedDir, finalDest string, dryRun bool) error {
+	if config.FinalizeMode != "copy" {
+		if dryRun {
+			log.Info().Str("from", unfinishedDir).Str("to", finalDest).Msg("[Dry Run] Would rename")
+			return nil
+		}
+		log.Info().Str("from", unfinishedDir).Str("to", finalDest).Msg("Renaming temporary directory")
+		if err := os.Rename(unfinishedDir, finalDest); err != nil {
+			return fmt.Errorf("failed to rename unfinished directory: %w", err)
+		}
+		return nil
+	}
+
+	if dryRun {
+		log.Info().Str("from", unfinishedDir).Str("to", finalDest).Msg("[Dry Run] Would link temporary directory into place")
+		return nil
+	}
+	log.Info().Str("from", unfinishedDir).Str("to", finalDest).Msg("Linking temporary directory into place")
+	if err := linkTree(ctx, unfinishedDir, finalDest); err != nil {
+		// A partial tree would pass for a snapshot.
+		os.RemoveAll(finalDest) //nolint:errcheck
+		return err
+	}
+	if err := os.RemoveAll(unfinishedDir); err != nil {
+		return fmt.Errorf("failed to remove unfinished directory: %w", err)
+	}
+	return nil
+}
+
+// linkTree recreates the directories of src at dst, which must not exist,
+// and hard links everything else in src into them, so no data is copied and
+// files --link-dest shared with older snapshots stay shared. Directory
+// permissions, ownership and modification times are kept. dst holds an
+// incompleteMarker until everything is linked.
+func linkTree(ctx context.Context, src, dst string) error {
+	type dirTime struct {
+		path    string
+		mode    fs.FileMode
+		modTime time.Time
+	}
+	var dirs []dirTime
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if !d.IsDir() {
+			// Symlinks are linked themselves, not followed.
+			return os.Link(path, target)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		// Writable until its contents are in place.
+		if err := os.Mkdir(target, 0700); err != nil {
+			return err
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			// Only root can give files away; like rsync, carry on without.
+			if err := os.Lchown(target, int(st.Uid), int(st.Gid)); err != nil && !errors.Is(err, fs.ErrPermission) {
+				return err
+			}
+		}
+		if rel == "." {
+			if err := os.WriteFile(filepath.Join(target, incompleteMarker), nil, 0644); err != nil {
+				return err
+			}
+		}
+		dirs = append(dirs, dirTime{target, info.Mode(), info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to link %s: %w", src, err)
+	}
+	if err := os.Remove(filepath.Join(dst, incompleteMarker)); err != nil {
+		return fmt.Errorf("failed to mark %s complete: %w", dst, err)
+	}
+
+	// Directory times change as their contents are linked, so they are set
+	// last, innermost first. The snapshot's own time drives retention.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if err := os.Chmod(d.path, d.mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			return fmt.Errorf("failed to set mode of %s: %w", d.path, err)
+		}
+		if err := os.Chtimes(d.path, d.modTime, d.modTime); err != nil {
+			return fmt.Errorf("failed to set time of %s: %w", d.path, err)
+		}
+	}
+	return nil
+}
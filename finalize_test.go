@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFinalizeSnapshotCopy(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// A working directory as rsync leaves it: one file new, one hard
+	// linked to the previous snapshot by --link-dest.
+	prev := filepath.Join(tmpDir, "prev")
+	unfinished := filepath.Join(tmpDir, ".unfinished")
+	final := filepath.Join(tmpDir, "test_2025-06-01_02:00:00")
+	for _, dir := range []string{prev, filepath.Join(unfinished, "docs")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(prev, "old.txt"), []byte("old"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Link(filepath.Join(prev, "old.txt"), filepath.Join(unfinished, "docs", "old.txt")); err != nil {
+		t.Fatalf("Failed to link file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(unfinished, "docs", "new.txt"), []byte("new"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Symlink("new.txt", filepath.Join(unfinished, "docs", "link")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	when := time.Date(2025, time.June, 1, 2, 0, 0, 0, time.Local)
+	for _, dir := range []string{filepath.Join(unfinished, "docs"), unfinished} {
+		if err := os.Chtimes(dir, when, when); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+	}
+
+	config := &Config{FinalizeMode: "copy"}
+	if err := finalizeSnapshot(context.Background(), config, unfinished, final, true); err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if _, err := os.Stat(final); !os.IsNotExist(err) {
+		t.Errorf("Dry run created the snapshot: %v", err)
+	}
+
+	if err := finalizeSnapshot(context.Background(), config, unfinished, final, false); err != nil {
+		t.Fatalf("finalizeSnapshot failed: %v", err)
+	}
+	if _, err := os.Stat(unfinished); !os.IsNotExist(err) {
+		t.Errorf("Expected the working directory to be removed, got %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(final, "docs", "new.txt"))
+	if err != nil || string(data) != "new" {
+		t.Errorf("Expected new.txt in the snapshot, got %q, %v", data, err)
+	}
+	prevInfo, err := os.Stat(filepath.Join(prev, "old.txt"))
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(final, "docs", "old.txt"))
+	if err != nil || !os.SameFile(prevInfo, info) {
+		t.Errorf("Expected old.txt to stay linked to the previous snapshot: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(final, "docs", "link")); err != nil || target != "new.txt" {
+		t.Errorf("Expected the symlink to be kept, got %q, %v", target, err)
+	}
+	if isIncomplete(final) {
+		t.Error("Expected the finished snapshot not to be marked incomplete")
+	}
+	for _, dir := range []string{final, filepath.Join(final, "docs")} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			t.Fatalf("Failed to stat dir: %v", err)
+		}
+		if !info.ModTime().Equal(when) || info.Mode().Perm() != 0755 {
+			t.Errorf("Expected %s to keep its time and mode, got %v %v", dir, info.ModTime(), info.Mode())
+		}
+	}
+}
+
+func TestIncompleteSnapshotIgnored(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// A snapshot linkTree was building when the run died.
+	name := "test_2025-06-01_02:00:00"
+	if err := os.Mkdir(filepath.Join(tmpDir, name), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, name, incompleteMarker), nil, 0644); err != nil {
+		t.Fatalf("Failed to write marker: %v", err)
+	}
+	snapshots, err := getSnapshots(context.Background(), tmpDir)
+	if err != nil || len(snapshots) != 0 {
+		t.Errorf("Expected the incomplete snapshot to be ignored, got %v, %v", snapshots, err)
+	}
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test"}
+	leftovers, err := findLeftovers(context.Background(), config, 0)
+	if err != nil || len(leftovers) != 1 || leftovers[0].Path != filepath.Join(tmpDir, name) {
+		t.Errorf("Expected the incomplete snapshot to be a leftover, got %v, %v", leftovers, err)
+	}
+}
+
+func TestRunSnapshotBackupFinalizeCopy(t *testing.T) {
+	assumeSourcesExist(t)
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	when := time.Date(2025, time.June, 1, 2, 0, 0, 0, time.Local)
+	timeNow = func() time.Time { return when }
+	defer func() { timeNow = time.Now }()
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, "", 0)
+	defer func() { execCommand = exec.CommandContext }()
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Source: []string{"/tmp/source1"}, FinalizeMode: "copy"}
+	if err := runSnapshotBackup(context.Background(), config, runOptions{}, false); err != nil {
+		t.Fatalf("runSnapshotBackup failed: %v", err)
+	}
+
+	name := "test_" + when.Format(snapshotTimeFormat)
+	if _, err := os.Stat(filepath.Join(tmpDir, name, "rsync.log")); err != nil {
+		t.Errorf("Expected snapshot %s with its rsync.log: %v", name, err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, ".unfinished")); !os.IsNotExist(err) {
+		t.Errorf("Expected .unfinished to be removed, got %v", err)
+	}
+	if latest, err := getLatestSnapshot(context.Background(), config); err != nil || latest != name {
+		t.Errorf("Expected latest snapshot %s, got %q, %v", name, latest, err)
+	}
+}
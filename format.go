@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// humanizeBytes formats a byte count in the binary units parseSize reads,
+// so a configured "50G" is logged as "50.0 GB".
+func humanizeBytes(n int64) string {
+	const unit = 1024
+	if n < unit && n > -unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	suffixes := []string{"KB", "MB", "GB", "TB", "PB", "EB"}
+	i := -1
+	// Move up a unit as soon as the value would round to 1024.0.
+	for math.Abs(value) >= unit-0.05 && i < len(suffixes)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", value, suffixes[i])
+}
+
+// formatDuration formats d for logs, rounded to a precision that suits its
+// length, e.g. "4m12s" or "350ms".
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
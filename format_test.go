@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHumanizeBytes(t *testing.T) {
+	for _, tc := range []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{999_999, "976.6 KB"},
+		{1024*1024 - 52, "1023.9 KB"},
+		{1024*1024 - 1, "1.0 MB"},
+		{1 << 20, "1.0 MB"},
+		{50 << 30, "50.0 GB"},
+		{6_012_954_214, "5.6 GB"},
+		{1<<40 - 1, "1.0 TB"},
+		{5 << 39, "2.5 TB"},
+		{5 << 49, "2.5 PB"},
+		{-1536, "-1.5 KB"},
+	} {
+		if got := humanizeBytes(tc.n); got != tc.want {
+			t.Errorf("humanizeBytes(%d) = %q, want %q", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	for _, tc := range []struct {
+		d    time.Duration
+		want string
+	}{
+		{350*time.Millisecond + 400*time.Microsecond, "350ms"},
+		{4*time.Minute + 12*time.Second + 300*time.Millisecond, "4m12s"},
+		{2 * time.Hour, "2h0m0s"},
+	} {
+		if got := formatDuration(tc.d); got != tc.want {
+			t.Errorf("formatDuration(%v) = %q, want %q", tc.d, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultGCAge is how old leftovers must be for -gc to remove them when
+// gc_age isn't set.
+const defaultGCAge = 24 * time.Hour
+
+// leftover is a file or directory an interrupted or failed run left behind.
+type leftover struct {
+	Path string
+	Size int64 // bytes removing it frees
+}
+
+// gcAge returns the configured gc_age, or defaultGCAge.
+func gcAge(config *Config) time.Duration {
+	if config.GCAge == "" {
+		return defaultGCAge
+	}
+	// validateConfig has already checked the duration.
+	age, _ := time.ParseDuration(config.GCAge)
+	return age
+}
+
+// leftoverNames are the names runs work under in the destination: the
+// .unfinished directory, the sources it was started with, and temporary
+// archives.
+var leftoverNames = map[string]bool{
+	".unfinished":         true,
+	unfinishedSourcesFile: true,
+	".unfinished.tar":     true,
+	".unfinished.gz":      true,
+	".unfinished.zst":     true,
+}
+
+// findLeftovers returns what runs left behind that is older than age: the
+// .unfinished working directory, temporary archives and snapshots
+// finalize_mode copy didn't finish linking in the destination, and in
+// log_dir the logs and checksum files of this config's runs that left no
+// snapshot.
+func findLeftovers(ctx context.Context, config *Config, age time.Duration) ([]leftover, error) {
+	var leftovers []leftover
+	old := func(info os.FileInfo) bool { return timeNow().Sub(info.ModTime()) >= age }
+
+	entries, err := os.ReadDir(config.Destination)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read destination: %w", err)
+	}
+	for _, entry := range entries {
+		if !leftoverNames[entry.Name()] && !(entry.IsDir() && isIncomplete(filepath.Join(config.Destination, entry.Name()))) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !old(info) {
+			continue
+		}
+		path := filepath.Join(config.Destination, entry.Name())
+		size := info.Size()
+		if info.IsDir() {
+			// Files linked with --link-dest stay in the snapshots.
+			if size, err = unsharedSize(path); err != nil {
+				return nil, err
+			}
+		}
+		leftovers = append(leftovers, leftover{Path: path, Size: size})
+	}
+
+	if config.LogDir == "" {
+		return leftovers, nil
+	}
+	snapshots, err := configSnapshots(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	runs := make(map[string]bool)
+	for _, s := range snapshots {
+		base, _ := trimArchiveSuffix(s.Name())
+		runs[base] = true
+	}
+	pattern, err := configNamePattern(config)
+	if err != nil {
+		return nil, err
+	}
+	entries, err = os.ReadDir(config.LogDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return leftovers, nil
+		}
+		return nil, fmt.Errorf("failed to read log directory: %w", err)
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), rsyncLogSuffix)
+		if !ok {
+			if name, ok = strings.CutSuffix(entry.Name(), changesLogSuffix); !ok {
+				if name, ok = strings.CutSuffix(entry.Name(), checksumSuffix); !ok {
+					continue
+				}
+			}
+		}
+		if !entry.Type().IsRegular() || runs[name] || !belongsToConfig(config, pattern, name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !old(info) {
+			continue
+		}
+		leftovers = append(leftovers, leftover{Path: filepath.Join(config.LogDir, entry.Name()), Size: info.Size()})
+	}
+	return leftovers, nil
+}
+
+// collectGarbage removes the leftovers of interrupted and failed runs that
+// are older than gc_age and reports the space reclaimed.
+func collectGarbage(ctx context.Context, config *Config, dryRun bool) error {
+	// A simple mode destination is a mirror of the sources, where any name
+	// may be the user's own file.
+	if config.Mode == "simple" {
+		return fmt.Errorf("-gc only applies to snapshot mode")
+	}
+	// Leftovers are told from a running backup's work by their age alone,
+	// and the directory a long rsync writes into can look old. Only the pid
+	// file rules out a running backup.
+	if !config.PidFile && !dryRun {
+		return fmt.Errorf("-gc needs pid_file, so that it can't remove the work of a running backup")
+	}
+	leftovers, err := findLeftovers(ctx, config, gcAge(config))
+	if err != nil {
+		return err
+	}
+	var removed int
+	var reclaimed int64
+	for _, l := range leftovers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if dryRun {
+			log.Info().Str("path", l.Path).Str("size", humanizeBytes(l.Size)).Msg("[Dry Run] Would remove leftover")
+			removed++
+			reclaimed += l.Size
+			continue
+		}
+		log.Info().Str("path", l.Path).Str("size", humanizeBytes(l.Size)).Msg("Removing leftover")
+		if err := os.RemoveAll(l.Path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", l.Path, err)
+		}
+		removed++
+		reclaimed += l.Size
+	}
+	log.Info().Int("removed", removed).Str("reclaimed", humanizeBytes(reclaimed)).Msg("Garbage collection finished")
+	return nil
+}
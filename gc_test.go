@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dest := filepath.Join(tmpDir, "backup")
+	logDir := filepath.Join(tmpDir, "logs")
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	snapshot := "test_" + old.Format(snapshotTimeFormat)
+	failed := "test_" + old.Add(time.Hour).Format(snapshotTimeFormat)
+	recent := "test_" + now.Format(snapshotTimeFormat)
+
+	mkdir := func(path string, modTime time.Time) {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+	}
+	write := func(path string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+	}
+	mkdir(logDir, now)
+	mkdir(filepath.Join(dest, ".unfinished"), old)
+	write(filepath.Join(dest, ".unfinished", "file.txt"), old)
+	mkdir(filepath.Join(dest, ".unfinished"), old) // writing the file touched it
+	write(filepath.Join(dest, ".unfinished.sources"), old)
+	write(filepath.Join(dest, ".unfinished.gz"), old)
+	write(filepath.Join(dest, ".unfinished-notes.txt"), old) // not goback's
+	mkdir(filepath.Join(dest, snapshot), old)
+	write(filepath.Join(logDir, snapshot+rsyncLogSuffix), old)
+	write(filepath.Join(logDir, failed+rsyncLogSuffix), old)
+	write(filepath.Join(logDir, failed+changesLogSuffix), old)
+	write(filepath.Join(logDir, failed+checksumSuffix), old)
+	write(filepath.Join(logDir, recent+rsyncLogSuffix), now)
+	write(filepath.Join(logDir, "other_"+old.Format(snapshotTimeFormat)+rsyncLogSuffix), old)
+
+	config := &Config{Destination: dest, SnapshotPrefix: "test", LogDir: logDir, PidFile: true}
+	removed := []string{
+		filepath.Join(dest, ".unfinished"),
+		filepath.Join(dest, ".unfinished.sources"),
+		filepath.Join(dest, ".unfinished.gz"),
+		filepath.Join(logDir, failed+rsyncLogSuffix),
+		filepath.Join(logDir, failed+changesLogSuffix),
+		filepath.Join(logDir, failed+checksumSuffix),
+	}
+	kept := []string{
+		filepath.Join(dest, snapshot),
+		filepath.Join(dest, ".unfinished-notes.txt"),
+		filepath.Join(logDir, snapshot+rsyncLogSuffix),
+		filepath.Join(logDir, recent+rsyncLogSuffix),
+		filepath.Join(logDir, "other_"+old.Format(snapshotTimeFormat)+rsyncLogSuffix),
+	}
+
+	if err := collectGarbage(context.Background(), config, true); err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	for _, path := range append(removed, kept...) {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Dry run removed %s", path)
+		}
+	}
+
+	if err := collectGarbage(context.Background(), config, false); err != nil {
+		t.Fatalf("collectGarbage failed: %v", err)
+	}
+	for _, path := range removed {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", path)
+		}
+	}
+	for _, path := range kept {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be kept: %v", path, err)
+		}
+	}
+}
+
+func TestCollectGarbageKeepsRecentUnfinished(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	unfinished := filepath.Join(tmpDir, ".unfinished")
+	if err := os.Mkdir(unfinished, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", GCAge: "1h", PidFile: true}
+	if err := collectGarbage(context.Background(), config, false); err != nil {
+		t.Fatalf("collectGarbage failed: %v", err)
+	}
+	if _, err := os.Stat(unfinished); err != nil {
+		t.Errorf("Expected a recent .unfinished to be kept: %v", err)
+	}
+}
+
+func TestCollectGarbageRejectsUnsafeRuns(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// In a mirror, .unfinished may be a file from the sources.
+	unfinished := filepath.Join(tmpDir, ".unfinished")
+	if err := os.Mkdir(unfinished, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(unfinished, old, old); err != nil {
+		t.Fatalf("Failed to set mod time: %v", err)
+	}
+
+	config := &Config{Mode: "simple", Destination: tmpDir, PidFile: true}
+	if err := collectGarbage(context.Background(), config, false); err == nil {
+		t.Error("Expected -gc to be rejected in simple mode")
+	}
+	if _, err := os.Stat(unfinished); err != nil {
+		t.Errorf("Expected nothing to be removed in simple mode: %v", err)
+	}
+
+	// Without the pid file a running backup's .unfinished can't be told
+	// from an abandoned one.
+	config = &Config{Destination: tmpDir, SnapshotPrefix: "test"}
+	if err := collectGarbage(context.Background(), config, false); err == nil {
+		t.Error("Expected -gc to be rejected without pid_file")
+	}
+	if _, err := os.Stat(unfinished); err != nil {
+		t.Errorf("Expected nothing to be removed without pid_file: %v", err)
+	}
+	if err := collectGarbage(context.Background(), config, true); err != nil {
+		t.Errorf("Expected a dry run to work without pid_file, got %v", err)
+	}
+}
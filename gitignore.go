@@ -0,0 +1,22 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// gitignoreFilter has rsync read the .gitignore in every directory it
+// transfers and exclude what it lists from that directory down, as git
+// scopes it. rsync reads the lines as plain exclude patterns, so "!"
+// negations aren't supported.
+const gitignoreFilter = "--filter=:- .gitignore"
+
+// sourceAnchor returns the rsync pattern prefix for paths relative to
+// src. rsync anchors patterns at the transfer root, which is src itself
+// when it ends in a slash and its parent otherwise.
+func sourceAnchor(src string) string {
+	if strings.HasSuffix(src, "/") {
+		return "/"
+	}
+	return "/" + filepath.Base(src) + "/"
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBuildRsyncArgsGitignore(t *testing.T) {
+	config := &Config{Source: []string{"/tmp/source1", "/tmp/source2"}, Exclude: []string{"*.tmp"}}
+	if args := buildRsyncArgs(config, "/dest", "", false); hasArg(args, gitignoreFilter) {
+		t.Errorf("Expected no .gitignore filter without gitignore_exclude, got %v", args)
+	}
+
+	// One dir-merge rule covers every source, each .gitignore applying
+	// only below its own directory.
+	config.GitignoreExclude = true
+	args := buildRsyncArgs(config, "/dest", "", false)
+	count := 0
+	for _, arg := range args {
+		if arg == gitignoreFilter {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("Expected %s once, got %v", gitignoreFilter, args)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// isGitRepo reports whether dir is the root of a git working tree with its
+// history in a .git directory. A .git file, as in a linked worktree or a
+// submodule, points at history kept elsewhere and doesn't count.
+func isGitRepo(dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil && info.IsDir()
+}
+
+// gitRepoArgs returns rsync filter rules that keep the .git directory of
+// every git repository in the sources and exclude the rest of its working
+// tree. Like cacheDirArgs, it walks the sources to find the repositories,
+// since rsync filter rules can't test for the presence of a directory.
+// Repositories nested inside a working tree are excluded with it.
+func gitRepoArgs(sources []string) []string {
+	var args []string
+	for _, src := range sources {
+		anchor := sourceAnchor(src)
+		err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// Unreadable directories are rsync's to report.
+				return nil
+			}
+			if !d.IsDir() || !isGitRepo(path) {
+				return nil
+			}
+			rel, err := filepath.Rel(src, path)
+			if err != nil {
+				return err
+			}
+			root := anchor
+			if rel != "." {
+				root += escapeRsyncPattern(filepath.ToSlash(rel)) + "/"
+			}
+			args = append(args, "--include="+root+".git/***", "--exclude="+root+"*")
+			return filepath.SkipDir
+		})
+		if err != nil {
+			log.Warn().Err(err).Str("source", src).Msg("Could not look for git repositories")
+		}
+	}
+	return args
+}
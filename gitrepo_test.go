@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestGitRepoArgs(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	src := filepath.Join(tmpDir, "home")
+	for _, dir := range []string{
+		"code/project/.git/objects",
+		"code/project/src",
+		"code/project/vendor/lib/.git", // nested in the working tree
+		"code/worktree",
+		"notes",
+	} {
+		if err := os.MkdirAll(filepath.Join(src, dir), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+	// A linked worktree has a .git file rather than a directory.
+	if err := os.WriteFile(filepath.Join(src, "code/worktree/.git"), []byte("gitdir: ../project/.git/worktrees/wt\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	args := gitRepoArgs([]string{src})
+	want := []string{"--include=/home/code/project/.git/***", "--exclude=/home/code/project/*"}
+	if !slices.Equal(args, want) {
+		t.Errorf("Expected %v, got %v", want, args)
+	}
+
+	// A source that is itself a repository, with a trailing slash.
+	args = gitRepoArgs([]string{filepath.Join(src, "code/project") + "/"})
+	want = []string{"--include=/.git/***", "--exclude=/*"}
+	if !slices.Equal(args, want) {
+		t.Errorf("Expected %v, got %v", want, args)
+	}
+
+	config := &Config{Source: []string{src}, GitHistoryOnly: true}
+	built := buildRsyncArgs(config, "/dest", "", false)
+	if !hasArg(built, "--include=/home/code/project/.git/***") || !hasArg(built, "--exclude=/home/code/project/*") {
+		t.Errorf("Expected the repository filters in %v", built)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/rs/zerolog/log"
+)
+
+// snapshotStats are the rsync statistics of the run that made a snapshot.
+type snapshotStats struct {
+	Snapshot string
+	RunID    string // empty for runs from before run IDs were logged
+	Stats    rsyncStats
+}
+
+// snapshotRsyncLog returns the path of the rsync log of the run that made
+// snapshot: in log_dir if one is configured, otherwise inside the snapshot.
+func snapshotRsyncLog(config *Config, snapshot string) string {
+	if config.LogDir != "" {
+		return filepath.Join(config.LogDir, snapshot+rsyncLogSuffix)
+	}
+	dir := filepath.Join(snapshotsDir(config), snapshot)
+	if config.SnapshotBackend == "zfs" {
+		dir = filepath.Join(dir, liveDirName)
+	}
+	return filepath.Join(dir, "rsync.log")
+}
+
+// parseRsyncLog extracts the --stats figures from an rsync log.
+func parseRsyncLog(path string) (rsyncStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return rsyncStats{}, err
+	}
+	//nolint:errcheck
+	defer f.Close()
+
+	w := &rsyncOutputWriter{out: io.Discard}
+	if _, err := io.Copy(w, f); err != nil {
+		return rsyncStats{}, err
+	}
+	if err := w.Flush(); err != nil {
+		return rsyncStats{}, err
+	}
+	return w.stats, nil
+}
+
+// statsHistory returns the statistics of every snapshot whose rsync log can
+// still be found, oldest first.
+func statsHistory(ctx context.Context, config *Config) ([]snapshotStats, error) {
+	snapshots, err := configSnapshots(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	var history []snapshotStats
+	for _, s := range snapshots {
+		path := snapshotRsyncLog(config, s.Name())
+		stats, err := parseRsyncLog(path)
+		if err != nil {
+			log.Debug().Err(err).Str("snapshot", s.Name()).Msg("No rsync log for snapshot")
+			continue
+		}
+		history = append(history, snapshotStats{Snapshot: s.Name(), RunID: readRunID(path), Stats: stats})
+	}
+	return history, nil
+}
+
+// growth formats the change from prev to cur as a percentage.
+func growth(prev, cur int64) string {
+	if prev == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", float64(cur-prev)*100/float64(prev))
+}
+
+// writeStatsHistory writes history as a table to w. The growth column is the
+// change in the total size of the source since the previous run.
+func writeStatsHistory(history []snapshotStats, w io.Writer) error {
+	if len(history) == 0 {
+		fmt.Fprintln(w, "No snapshots with rsync logs found.")
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SNAPSHOT\tFILES\tTRANSFERRED\tTOTAL SIZE\tGROWTH\tRUN")
+	for i, h := range history {
+		change := "-"
+		if i > 0 {
+			change = growth(history[i-1].Stats.TotalBytes, h.Stats.TotalBytes)
+		}
+		run := h.RunID
+		if run == "" {
+			run = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", h.Snapshot, h.Stats.FilesTransferred,
+			humanizeBytes(h.Stats.TransferredBytes), humanizeBytes(h.Stats.TotalBytes), change, run)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsHistory(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Date(2025, time.June, 28, 2, 0, 0, 0, time.Local)
+	runs := []struct {
+		files, total, transferred string
+	}{
+		{"1,200", "1.00G", "1.00G"},
+		{"10", "1.10G", "20.00M"},
+		{"", "", ""}, // no rsync log, e.g. it was deleted
+		{"4,000", "2.20G", "1.10G"},
+	}
+	var names []string
+	for i, run := range runs {
+		modTime := now.AddDate(0, 0, i-len(runs))
+		name := "test_" + modTime.Format(snapshotTimeFormat)
+		names = append(names, name)
+		path := filepath.Join(tmpDir, name)
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if run.files != "" {
+			log := fmt.Sprintf("sending incremental file list\n\nNumber of files: 9,999 (reg: 9,000, dir: 999)\n"+
+				"Number of regular files transferred: %s\nTotal file size: %s bytes\nTotal transferred file size: %s bytes\n",
+				run.files, run.total, run.transferred)
+			if err := os.WriteFile(filepath.Join(path, "rsync.log"), []byte(log), 0644); err != nil {
+				t.Fatalf("Failed to write rsync log: %v", err)
+			}
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+	}
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test"}
+	history, err := statsHistory(context.Background(), config)
+	if err != nil {
+		t.Fatalf("statsHistory failed: %v", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 runs with logs, got %+v", history)
+	}
+	if h := history[2]; h.Snapshot != names[3] || h.Stats.FilesTransferred != 4000 || h.Stats.TotalBytes != 2200000000 {
+		t.Errorf("Unexpected stats for the last run: %+v", h)
+	}
+
+	var out strings.Builder
+	if err := writeStatsHistory(history, &out); err != nil {
+		t.Fatalf("writeStatsHistory failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected a header and 3 rows, got:\n%s", out.String())
+	}
+	wants := [][]string{
+		{names[0], "1200", "953.7 MB", "-"},
+		{names[1], "10", "19.1 MB", "1.0 GB", "+10.0%"},
+		{names[3], "4000", "1.0 GB", "2.0 GB", "+100.0%"},
+	}
+	for i, want := range wants {
+		for _, field := range want {
+			if !strings.Contains(lines[i+1], field) {
+				t.Errorf("Expected %q in row %q", field, lines[i+1])
+			}
+		}
+	}
+}
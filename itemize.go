@@ -0,0 +1,54 @@
+package main
+
+import "strings"
+
+// itemChange is what an --itemize-changes line says happened to a file.
+type itemChange int
+
+const (
+	itemNone itemChange = iota // a directory, or not an itemized line
+	itemAdded
+	itemModified
+	itemDeleted
+)
+
+// classifyItemizedLine classifies one line of --itemize-changes output.
+// Only files, symlinks, devices and specials are counted; directories are
+// created and updated as a side effect of their contents changing.
+func classifyItemizedLine(line string) itemChange {
+	line = strings.TrimRight(line, "\r\n")
+	m := itemizedLine.FindStringSubmatch(line)
+	if m == nil {
+		return itemNone
+	}
+	if m[1] == "*deleting" {
+		if strings.HasSuffix(line, "/") {
+			return itemNone
+		}
+		return itemDeleted
+	}
+	if m[1][1] == 'd' {
+		return itemNone
+	}
+	// A new item has all its attribute flags set to '+'.
+	if m[1][2:] == "+++++++++" {
+		return itemAdded
+	}
+	return itemModified
+}
+
+// classifyItemized counts the files rsync's --itemize-changes output says
+// are added, modified and deleted.
+func classifyItemized(output string) (added, modified, deleted int) {
+	for _, line := range strings.Split(output, "\n") {
+		switch classifyItemizedLine(line) {
+		case itemAdded:
+			added++
+		case itemModified:
+			modified++
+		case itemDeleted:
+			deleted++
+		}
+	}
+	return added, modified, deleted
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+func TestClassifyItemized(t *testing.T) {
+	output := "sending incremental file list\n" +
+		"cd+++++++++ photos/\n" +
+		">f+++++++++ photos/new.jpg\n" +
+		"cL+++++++++ photos/latest -> new.jpg\n" +
+		">f.st...... notes.txt\n" +
+		".f...p..... script.sh\n" +
+		"cL.....t... link-changed -> elsewhere\n" +
+		".d..t...... docs/\n" +
+		"*deleting   old.txt\n" +
+		"*deleting   olddir/\n" +
+		"hf+++++++++ hardlinked.bin => photos/new.jpg\n" +
+		"\n" +
+		"Number of files: 10 (reg: 6, dir: 4)\n"
+
+	added, modified, deleted := classifyItemized(output)
+	if added != 3 || modified != 3 || deleted != 1 {
+		t.Errorf("Expected 3 added, 3 modified and 1 deleted, got %d, %d and %d", added, modified, deleted)
+	}
+}
+
+func TestRsyncOutputWriterClassifies(t *testing.T) {
+	w := &rsyncOutputWriter{out: io.Discard}
+	if _, err := w.Write([]byte(">f+++++++++ a\n>f.st...... b\n*deleting   c\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if w.stats.Added != 1 || w.stats.Modified != 1 || w.stats.Deleted != 1 {
+		t.Errorf("Unexpected stats: %+v", w.stats)
+	}
+}
+
+func TestClassifyItemizedLineCRLF(t *testing.T) {
+	// A directory is told from a file by its trailing slash, which a CR
+	// left on the line would hide.
+	for line, want := range map[string]itemChange{
+		"*deleting   olddir/\r\n": itemNone,
+		"*deleting   old.txt\r\n": itemDeleted,
+		">f+++++++++ new.txt\r\n": itemAdded,
+	} {
+		if got := classifyItemizedLine(line); got != want {
+			t.Errorf("classifyItemizedLine(%q) = %d, want %d", line, got, want)
+		}
+	}
+}
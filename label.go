@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/rs/zerolog/log"
+)
+
+// labelChars is the pattern a snapshot label must match. Labels can't
+// contain path separators or start with a dot, which would hide the
+// snapshot from getSnapshots.
+const labelChars = `[A-Za-z0-9][A-Za-z0-9._-]*`
+
+var validLabel = regexp.MustCompile(`^` + labelChars + `$`)
+
+// labelSnapshot renames snapshot so its name ends in "-<label>", replacing
+// any label it already has. The timestamp and sequence number are kept, and
+// a rename doesn't change the modification time, so the snapshot is
+// retained exactly as before. It returns the new name. ZFS snapshots can't
+// be renamed through .zfs/snapshot, so zfs rename is used for them.
+func labelSnapshot(ctx context.Context, config *Config, snapshot, label string, dryRun bool) (string, error) {
+	if !validLabel.MatchString(label) {
+		return "", fmt.Errorf("invalid label %q: labels must start with a letter or digit and contain only letters, digits, '.', '_' and '-'", label)
+	}
+	pattern, err := configNamePattern(config)
+	if err != nil {
+		return "", err
+	}
+	base, _ := trimArchiveSuffix(snapshot)
+	suffix := snapshot[len(base):]
+	m := pattern.FindStringSubmatchIndex(base)
+	if m == nil || filepath.Base(snapshot) != snapshot {
+		return "", fmt.Errorf("%q is not a snapshot name of this configuration", snapshot)
+	}
+	// Cut off the existing label, if there is one.
+	end := len(base)
+	if m[6] != -1 {
+		end = m[6] - 1
+	}
+	newName := base[:end] + "-" + label + suffix
+
+	oldPath := filepath.Join(snapshotsDir(config), snapshot)
+	newPath := filepath.Join(snapshotsDir(config), newName)
+	if _, err := os.Lstat(oldPath); err != nil {
+		return "", fmt.Errorf("failed to find snapshot: %w", err)
+	}
+	if _, err := os.Lstat(newPath); err == nil {
+		return "", fmt.Errorf("a snapshot named %s already exists", newName)
+	}
+
+	if dryRun {
+		log.Info().Str("from", oldPath).Str("to", newPath).Msg("[Dry Run] Would rename snapshot")
+		return newName, nil
+	}
+	if config.SnapshotBackend == "zfs" {
+		return newName, runCommand(ctx, "zfs", "rename", config.ZFSDataset+"@"+snapshot, config.ZFSDataset+"@"+newName)
+	}
+	log.Info().Str("from", oldPath).Str("to", newPath).Msg("Renaming snapshot")
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return "", fmt.Errorf("failed to rename snapshot: %w", err)
+	}
+	return newName, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLabelSnapshot(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	modTime := time.Date(2025, time.June, 28, 10, 0, 0, 0, time.Local)
+	name := "test_" + modTime.Format(snapshotTimeFormat) + "_2"
+	path := filepath.Join(tmpDir, name)
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Failed to set mod time: %v", err)
+	}
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test"}
+	labelled, err := labelSnapshot(context.Background(), config, name, "pre-upgrade", false)
+	if err != nil {
+		t.Fatalf("labelSnapshot failed: %v", err)
+	}
+	if want := name + "-pre-upgrade"; labelled != want {
+		t.Errorf("Expected %s, got %s", want, labelled)
+	}
+
+	// Relabelling replaces the label rather than stacking another one.
+	relabelled, err := labelSnapshot(context.Background(), config, labelled, "v2.0", false)
+	if err != nil {
+		t.Fatalf("labelSnapshot failed: %v", err)
+	}
+	if want := name + "-v2.0"; relabelled != want {
+		t.Errorf("Expected %s, got %s", want, relabelled)
+	}
+
+	if got, ok := parseSnapshotTime(relabelled); !ok || !got.Equal(modTime) {
+		t.Errorf("Expected labelled snapshot to parse as %v, got %v, %v", modTime, got, ok)
+	}
+	if seq := snapshotSeq(relabelled); seq != 2 {
+		t.Errorf("Expected sequence number 2, got %d", seq)
+	}
+	snapshots, err := getSnapshots(context.Background(), tmpDir)
+	if err != nil {
+		t.Fatalf("getSnapshots failed: %v", err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Name() != relabelled {
+		t.Fatalf("Expected only %s, got %v", relabelled, snapshots)
+	}
+	if _, ok := snapshotsToKeep(snapshots, Keep{Daily: 1})[relabelled]; !ok {
+		t.Errorf("Expected %s to be retained", relabelled)
+	}
+}
+
+func TestLabelSnapshotNameTemplate(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	hostname = func() (string, error) { return "box", nil }
+	defer func() { hostname = os.Hostname }()
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "daily", SnapshotName: "%prefix%_%time%_%host%"}
+	name := "daily_2025-06-28_10:00:00_box_2"
+	if err := os.Mkdir(filepath.Join(tmpDir, name), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	labelled, err := labelSnapshot(context.Background(), config, name, "pre-upgrade", false)
+	if err != nil {
+		t.Fatalf("labelSnapshot failed: %v", err)
+	}
+	if want := name + "-pre-upgrade"; labelled != want {
+		t.Errorf("Expected %s, got %s", want, labelled)
+	}
+
+	for name, want := range map[string]int{
+		"daily_2025-06-28_10:00:00_box":               1,
+		"daily_2025-06-28_10:00:00_box_2":             2,
+		"daily_2025-06-28_10:00:00_box_3-pre-upgrade": 3,
+		"daily_2025-06-28_10-00-00":                   1,
+		"daily_2025-06-28_10-00-00-v_4":               1,
+		"daily_2025-06-28_10-00-00_5-v_4":             5,
+	} {
+		if got := snapshotSeq(name); got != want {
+			t.Errorf("Expected sequence number %d for %s, got %d", want, name, got)
+		}
+	}
+}
+
+func TestLabelSnapshotInvalidLabel(t *testing.T) {
+	config := &Config{Destination: "/mnt/backups", SnapshotPrefix: "test"}
+	for _, label := range []string{"", ".hidden", "a/b", "../up", "with space"} {
+		if _, err := labelSnapshot(context.Background(), config, "test_2025-06-28_10:00:00", label, false); err == nil {
+			t.Errorf("Expected label %q to be refused", label)
+		}
+	}
+	if _, err := labelSnapshot(context.Background(), config, "not-a-snapshot", "ok", false); err == nil {
+		t.Error("Expected a name that isn't a snapshot to be refused")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// latestLink starts the name of the symlink in the destination that points
+// at the newest snapshot of a configuration, e.g. "latest-server".
+// getSnapshots skips it, as it does anything that isn't a directory or an
+// archive.
+const latestLink = "latest"
+
+// latestLinkPath returns the latest symlink of config. Like the snapshot
+// state it is kept per snapshotKey, so configurations sharing a destination
+// don't move each other's link.
+func latestLinkPath(config *Config) (string, error) {
+	key, err := snapshotKey(config)
+	if err != nil {
+		return "", err
+	}
+	name := latestLink
+	if key != "" {
+		name += "-" + key
+	}
+	return filepath.Join(config.Destination, name), nil
+}
+
+// updateLatestLink points the latest symlink at snapshot. The new link is
+// created under a temporary name and renamed over the old one, so latest
+// always exists once it has been created.
+func updateLatestLink(config *Config, snapshot string, dryRun bool) error {
+	link, err := latestLinkPath(config)
+	if err != nil {
+		return err
+	}
+	target := snapshot
+	if config.SnapshotBackend == "zfs" {
+		target = filepath.Join(".zfs", "snapshot", snapshot)
+	}
+	if info, err := os.Lstat(link); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("%s exists and is not a symlink", link)
+	}
+	if dryRun {
+		log.Info().Str("link", link).Str("target", target).Msg("[Dry Run] Would update latest symlink")
+		return nil
+	}
+
+	tmp := filepath.Join(config.Destination, "."+filepath.Base(link)+".tmp")
+	os.Remove(tmp) //nolint:errcheck
+	if err := os.Symlink(target, tmp); err != nil {
+		return fmt.Errorf("failed to create latest symlink: %w", err)
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp) //nolint:errcheck
+		return fmt.Errorf("failed to replace latest symlink: %w", err)
+	}
+	log.Info().Str("link", link).Str("target", target).Msg("Updated latest symlink")
+	return nil
+}
+
+// refreshLatestLink points the latest symlink at the newest snapshot after
+// snapshots were renamed or deleted, or removes it if none are left. The
+// change itself succeeded, so failing to follow it up is only logged.
+func refreshLatestLink(ctx context.Context, config *Config) {
+	latest, err := getLatestSnapshot(ctx, config)
+	if err == nil {
+		err = repairLatestLink(config, latest, false, func(string, ...any) {})
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("Could not update latest symlink")
+	}
+}
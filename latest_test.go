@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpdateLatestLink(t *testing.T) {
+	assumeSourcesExist(t)
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, "", 0)
+	defer func() { execCommand = exec.CommandContext }()
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Source: []string{"/tmp/source1"}}
+	link := filepath.Join(tmpDir, "latest-test")
+
+	if err := runSnapshotBackup(context.Background(), config, runOptions{}, true); err != nil {
+		t.Fatalf("runSnapshotBackup dry run failed: %v", err)
+	}
+	if _, err := os.Lstat(link); !os.IsNotExist(err) {
+		t.Errorf("Expected a dry run not to create the latest symlink")
+	}
+
+	for run := 1; run <= 2; run++ {
+		if err := runSnapshotBackup(context.Background(), config, runOptions{}, false); err != nil {
+			t.Fatalf("runSnapshotBackup failed: %v", err)
+		}
+		latest, err := getLatestSnapshot(context.Background(), config)
+		if err != nil || latest == "" {
+			t.Fatalf("Failed to find snapshot: %v", err)
+		}
+		if target, err := os.Readlink(link); err != nil || target != latest {
+			t.Errorf("Run %d: expected latest to point at %s, got %q (%v)", run, latest, target, err)
+		}
+		now = now.Add(time.Hour)
+	}
+
+	snapshots, err := getSnapshots(context.Background(), tmpDir)
+	if err != nil {
+		t.Fatalf("getSnapshots failed: %v", err)
+	}
+	if len(snapshots) != 2 {
+		t.Errorf("Expected the latest symlink not to be listed as a snapshot, got %d snapshots", len(snapshots))
+	}
+
+	// A real directory called latest is left alone.
+	if err := os.Remove(link); err != nil {
+		t.Fatalf("Failed to remove link: %v", err)
+	}
+	if err := os.Mkdir(link, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := updateLatestLink(config, "test_other", false); err == nil {
+		t.Errorf("Expected an error when latest is not a symlink")
+	}
+	if info, err := os.Lstat(link); err != nil || !info.IsDir() {
+		t.Errorf("Expected the latest directory to be kept")
+	}
+}
+
+func TestRefreshLatestLink(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	daily, weekly := makePrefixedSnapshots(t, tmpDir)
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "daily"}
+	other := &Config{Destination: tmpDir, SnapshotPrefix: "weekly"}
+	for _, c := range []*Config{config, other} {
+		latest, err := getLatestSnapshot(context.Background(), c)
+		if err != nil {
+			t.Fatalf("getLatestSnapshot failed: %v", err)
+		}
+		if err := updateLatestLink(c, latest, false); err != nil {
+			t.Fatalf("updateLatestLink failed: %v", err)
+		}
+	}
+	link := filepath.Join(tmpDir, "latest-daily")
+	otherLink := filepath.Join(tmpDir, "latest-weekly")
+	readLink := func(path string) string {
+		t.Helper()
+		target, err := os.Readlink(path)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatalf("Failed to read link: %v", err)
+		}
+		return target
+	}
+	if got, want := readLink(otherLink), weekly[len(weekly)-1]; got != want {
+		t.Errorf("Expected each prefix to have its own latest link, got %q, want %q", got, want)
+	}
+
+	// Labelling the latest snapshot renames what the link points at.
+	labelled, err := labelSnapshot(context.Background(), config, daily[len(daily)-1], "kept", false)
+	if err != nil {
+		t.Fatalf("labelSnapshot failed: %v", err)
+	}
+	refreshLatestLink(context.Background(), config)
+	if got := readLink(link); got != labelled {
+		t.Errorf("Expected latest to follow the label to %s, got %q", labelled, got)
+	}
+
+	// Deleting it moves the link back to the one before.
+	if err := deleteSnapshots(context.Background(), config, []string{labelled}, true, false); err != nil {
+		t.Fatalf("deleteSnapshots failed: %v", err)
+	}
+	refreshLatestLink(context.Background(), config)
+	if got, want := readLink(link), daily[len(daily)-2]; got != want {
+		t.Errorf("Expected latest to point at %s after the delete, got %q", want, got)
+	}
+
+	// Without snapshots there is no latest link, and other prefixes keep
+	// theirs.
+	if err := deleteSnapshots(context.Background(), config, daily[:len(daily)-1], true, false); err != nil {
+		t.Fatalf("deleteSnapshots failed: %v", err)
+	}
+	refreshLatestLink(context.Background(), config)
+	if _, err := os.Lstat(link); !os.IsNotExist(err) {
+		t.Errorf("Expected the latest link to be removed with the last snapshot, got %v", err)
+	}
+	if got, want := readLink(otherLink), weekly[len(weekly)-1]; got != want {
+		t.Errorf("Expected the weekly latest link to be left alone, got %q, want %q", got, want)
+	}
+}
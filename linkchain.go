@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// verifyLinkChain checks that unchanged files are shared between
+// consecutive snapshots. rsync's --link-dest hard links a file to the
+// previous snapshot when its size, modification time, permissions and owner
+// are unchanged, so such a pair with different inodes means the chain was
+// broken, for example by a tool that copied the snapshots without
+// preserving hard links. Solidified snapshots are unlinked on purpose and
+// are left out. It returns a description of every broken link.
+func verifyLinkChain(ctx context.Context, config *Config) ([]string, error) {
+	if nativeBackend(config) {
+		return nil, fmt.Errorf("%s snapshots share data through the filesystem, not hard links", config.SnapshotBackend)
+	}
+	snapshots, err := configSnapshots(ctx, config) // sorted oldest to newest
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for i := 1; i < len(snapshots); i++ {
+		prev, cur := snapshots[i-1].Name(), snapshots[i].Name()
+		if !snapshots[i-1].IsDir() || !snapshots[i].IsDir() {
+			continue // archives are never hard linked
+		}
+		prevDir := filepath.Join(config.Destination, prev)
+		if isSolidified(prevDir) || isSolidified(filepath.Join(config.Destination, cur)) {
+			continue // solidified snapshots are unlinked on purpose
+		}
+		err := walkSnapshotFiles(config, filepath.Join(config.Destination, cur), func(rel string, path string) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			info, err := os.Lstat(path)
+			if err != nil {
+				return err
+			}
+			prevInfo, err := os.Lstat(filepath.Join(prevDir, filepath.FromSlash(rel)))
+			if errors.Is(err, os.ErrNotExist) {
+				return nil // new file
+			} else if err != nil {
+				return err
+			}
+			if unchanged(prevInfo, info) && !os.SameFile(prevInfo, info) {
+				problems = append(problems, fmt.Sprintf("%s/%s: unchanged since %s but not hard linked to it", cur, rel, prev))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to check snapshot %s: %w", cur, err)
+		}
+	}
+	return problems, nil
+}
+
+// unchanged reports whether rsync would consider b an unchanged copy of a
+// and hard link it rather than transfer it.
+func unchanged(a, b os.FileInfo) bool {
+	if !a.Mode().IsRegular() || a.Mode() != b.Mode() || a.Size() != b.Size() || !a.ModTime().Equal(b.ModTime()) {
+		return false
+	}
+	sa, okA := a.Sys().(*syscall.Stat_t)
+	sb, okB := b.Sys().(*syscall.Stat_t)
+	return !okA || !okB || (sa.Uid == sb.Uid && sa.Gid == sb.Gid)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// makeLinkChain creates three snapshots in which unchanged.txt is hard
+// linked from the first to the last and changed.txt differs in each.
+func makeLinkChain(t *testing.T, dir string) []string {
+	t.Helper()
+	now := time.Now()
+	fileTime := now.AddDate(0, 0, -10)
+	var names []string
+	for i := 3; i >= 1; i-- {
+		modTime := now.AddDate(0, 0, -i)
+		name := "test_" + modTime.Format(snapshotTimeFormat)
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Join(path, "sub"), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		unchanged := filepath.Join(path, "sub", "unchanged.txt")
+		if len(names) == 0 {
+			if err := os.WriteFile(unchanged, []byte("same"), 0644); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+			if err := os.Chtimes(unchanged, fileTime, fileTime); err != nil {
+				t.Fatalf("Failed to set mod time: %v", err)
+			}
+		} else if err := os.Link(filepath.Join(dir, names[len(names)-1], "sub", "unchanged.txt"), unchanged); err != nil {
+			t.Fatalf("Failed to link file: %v", err)
+		}
+		changed := filepath.Join(path, "changed.txt")
+		if err := os.WriteFile(changed, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if err := os.Chtimes(changed, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestVerifyLinkChain(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	names := makeLinkChain(t, tmpDir)
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test"}
+
+	problems, err := verifyLinkChain(context.Background(), config)
+	if err != nil {
+		t.Fatalf("verifyLinkChain failed: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Expected an intact chain, got %v", problems)
+	}
+
+	// Replace the link in the middle snapshot with an identical copy, as a
+	// copy that doesn't preserve hard links would.
+	middle := filepath.Join(tmpDir, names[1], "sub", "unchanged.txt")
+	info, err := os.Stat(middle)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if err := os.Remove(middle); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if err := os.WriteFile(middle, []byte("same"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Chtimes(middle, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("Failed to set mod time: %v", err)
+	}
+
+	problems, err = verifyLinkChain(context.Background(), config)
+	if err != nil {
+		t.Fatalf("verifyLinkChain failed: %v", err)
+	}
+	// The copy is neither linked to the first snapshot nor from the last.
+	if len(problems) != 2 {
+		t.Fatalf("Expected 2 broken links, got %v", problems)
+	}
+	if !strings.HasPrefix(problems[0], names[1]+"/sub/unchanged.txt:") || !strings.HasPrefix(problems[1], names[2]+"/sub/unchanged.txt:") {
+		t.Errorf("Expected broken links in %s and %s, got %v", names[1], names[2], problems)
+	}
+}
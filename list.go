@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// listSnapshots writes the snapshots made with config's prefix, or those of
+// every prefix if allPrefixes is set, to w from oldest to newest, with their
+// tags. With expiry, the date each snapshot of config's prefix is expected
+// to be purged is added. With tagFilter, only snapshots carrying that tag
+// are listed.
+func listSnapshots(ctx context.Context, config *Config, allPrefixes, expiry bool, tagFilter string, w io.Writer) error {
+	var snapshots []os.FileInfo
+	var err error
+	if allPrefixes {
+		snapshots, err = getSnapshots(ctx, snapshotsDir(config))
+	} else {
+		snapshots, err = configSnapshots(ctx, config)
+	}
+	if err != nil {
+		return err
+	}
+	tags := make(map[string][]string)
+	var listed []os.FileInfo
+	for _, s := range snapshots {
+		tags[s.Name()] = snapshotTags(config, s.Name())
+		if tagFilter == "" || slices.Contains(tags[s.Name()], tagFilter) {
+			listed = append(listed, s)
+		}
+	}
+	if len(listed) == 0 {
+		fmt.Fprintln(w, "No snapshots found.")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if !expiry {
+		fmt.Fprintln(tw, "SNAPSHOT\tTIME\tTAGS")
+		for _, s := range listed {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Name(), s.ModTime().Format("2006-01-02 15:04:05"), strings.Join(tags[s.Name()], ","))
+		}
+		return tw.Flush()
+	}
+
+	own, err := configSnapshots(ctx, config)
+	if err != nil {
+		return err
+	}
+	expires := estimateExpiry(own, config, timeNow())
+	ownNames := make(map[string]bool)
+	for _, s := range own {
+		ownNames[s.Name()] = true
+	}
+	fmt.Fprintln(tw, "SNAPSHOT\tTIME\tEXPIRES\tTAGS")
+	for _, s := range listed {
+		when := "never"
+		if !ownNames[s.Name()] {
+			// Other prefixes follow their own configuration's policy.
+			when = "-"
+		} else if t, ok := expires[s.Name()]; ok {
+			when = t.Format("2006-01-02")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Name(), s.ModTime().Format("2006-01-02 15:04:05"), when, strings.Join(tags[s.Name()], ","))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// makePrefixedSnapshots creates a daily and a weekly snapshot for each of the
+// last three days and returns their names.
+func makePrefixedSnapshots(t *testing.T, dir string) (daily, weekly []string) {
+	t.Helper()
+	now := time.Now()
+	for i := 3; i >= 1; i-- {
+		modTime := now.AddDate(0, 0, -i)
+		for _, prefix := range []string{"daily", "weekly"} {
+			name := prefix + "_" + modTime.Format(snapshotTimeFormat)
+			path := filepath.Join(dir, name)
+			if err := os.Mkdir(path, 0755); err != nil {
+				t.Fatalf("Failed to create dir: %v", err)
+			}
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatalf("Failed to set mod time: %v", err)
+			}
+			if prefix == "daily" {
+				daily = append(daily, name)
+			} else {
+				weekly = append(weekly, name)
+			}
+		}
+	}
+	return daily, weekly
+}
+
+func TestPurgeBackupsOnlyMatchingPrefix(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	daily, weekly := makePrefixedSnapshots(t, tmpDir)
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "daily", Keep: Keep{Daily: 1}, MaxPurgePercent: 100}
+	if err := purgeBackups(context.Background(), config, false); err != nil {
+		t.Fatalf("purgeBackups failed: %v", err)
+	}
+
+	for i, name := range daily {
+		_, err := os.Stat(filepath.Join(tmpDir, name))
+		if i == len(daily)-1 {
+			if err != nil {
+				t.Errorf("Expected %s to be kept, got %v", name, err)
+			}
+		} else if !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be purged", name)
+		}
+	}
+	for _, name := range weekly {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); err != nil {
+			t.Errorf("Expected %s of another prefix to be left alone, got %v", name, err)
+		}
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	daily, weekly := makePrefixedSnapshots(t, tmpDir)
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "weekly"}
+
+	var out strings.Builder
+	if err := listSnapshots(context.Background(), config, false, false, "", &out); err != nil {
+		t.Fatalf("listSnapshots failed: %v", err)
+	}
+	for _, name := range weekly {
+		if !strings.Contains(out.String(), name) {
+			t.Errorf("Expected %s in listing:\n%s", name, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "daily_") {
+		t.Errorf("Expected only weekly snapshots, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := listSnapshots(context.Background(), config, true, false, "", &out); err != nil {
+		t.Fatalf("listSnapshots failed: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 1+len(daily)+len(weekly) {
+		t.Errorf("Expected a header and %d snapshots, got:\n%s", len(daily)+len(weekly), out.String())
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	rsyncLogSuffix   = ".rsync.log"
+	changesLogSuffix = ".changes.log"
+)
+
+// runLogs are the files a non-dry-run rsync invocation logs to.
+type runLogs struct {
+	rsync   io.Writer
+	changes io.Writer // nil when changes go to rsync
+	files   []*os.File
+	tails   []*tailWriter
+	stop    chan struct{} // stops flushTails
+	stopped chan struct{}
+
+	maxSize   int64
+	tailLines int
+	tailSize  int64
+}
+
+// tailFlushInterval is how often the tails kept by log_tail_lines or
+// log_tail_size are written out while rsync runs, so that the log shows
+// recent output during the run and a run that is killed leaves its last
+// output behind.
+var tailFlushInterval = 5 * time.Second
+
+// flushTails writes out the tails every tailFlushInterval until stop is
+// closed.
+func (l *runLogs) flushTails() {
+	defer close(l.stopped)
+	ticker := time.NewTicker(tailFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			for _, t := range l.tails {
+				if err := t.Flush(); err != nil {
+					log.Warn().Err(err).Msg("Failed to write the tail of the log")
+				}
+			}
+		}
+	}
+}
+
+// Close writes out the tails kept by log_tail_lines or log_tail_size and
+// closes the files.
+func (l *runLogs) Close() {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.stopped
+	}
+	for _, t := range l.tails {
+		if err := t.Flush(); err != nil {
+			log.Error().Err(err).Msg("Failed to write the tail of the log")
+		}
+	}
+	for _, f := range l.files {
+		//nolint:errcheck
+		f.Close()
+	}
+}
+
+// create creates the log file at path, starting with header, and returns
+// the writer for the rest of the log. With tail set, only the tail kept by
+// log_tail_lines or log_tail_size is written, and rewritten each time it is
+// flushed.
+func (l *runLogs) create(path, header string, tail bool) (io.Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	l.files = append(l.files, f)
+	if _, err := io.WriteString(f, header); err != nil {
+		return nil, err
+	}
+	limit := func() io.Writer {
+		if l.maxSize > 0 {
+			return &limitWriter{w: f, remaining: l.maxSize}
+		}
+		return f
+	}
+	if !tail || (l.tailLines == 0 && l.tailSize == 0) {
+		return limit(), nil
+	}
+	start := int64(len(header))
+	t := &tailWriter{lines: l.tailLines, size: l.tailSize, rewind: func() (io.Writer, error) {
+		if err := f.Truncate(start); err != nil {
+			return nil, err
+		}
+		if _, err := f.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return limit(), nil
+	}}
+	l.tails = append(l.tails, t)
+	return t, nil
+}
+
+// openRunLogs opens the logs for a run named runName. With log_dir set they
+// are <log_dir>/<runName>.rsync.log and .changes.log, and older runs' logs
+// beyond log_retain are removed. Otherwise snapshot mode logs to rsync.log
+// and changes.log inside destDir and simple mode logs to stdout.
+func openRunLogs(config *Config, destDir string, runName string) (*runLogs, error) {
+	logs := &runLogs{tailLines: config.LogTailLines}
+	var err error
+	if config.MaxLogSize != "" {
+		if logs.maxSize, err = parseSize(config.MaxLogSize); err != nil {
+			return nil, err
+		}
+	}
+	if config.LogTailSize != "" {
+		if logs.tailSize, err = parseSize(config.LogTailSize); err != nil {
+			return nil, err
+		}
+	}
+
+	rsyncPath, changesPath := filepath.Join(destDir, "rsync.log"), filepath.Join(destDir, "changes.log")
+	if config.LogDir != "" {
+		if err := os.MkdirAll(config.LogDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create log directory: %w", err)
+		}
+		rsyncPath = filepath.Join(config.LogDir, runName+rsyncLogSuffix)
+		changesPath = filepath.Join(config.LogDir, runName+changesLogSuffix)
+	} else if config.Mode == "simple" {
+		// In simple mode the destination is a mirror of the source, so logs
+		// there would be deleted on the next run.
+		logs.rsync = os.Stdout
+		return logs, nil
+	}
+
+	// The run ID heads the log even when only its tail is kept.
+	var header string
+	if runID != "" {
+		header = runIDLinePrefix + runID + "\n"
+	}
+	if logs.rsync, err = logs.create(rsyncPath, header, true); err != nil {
+		logs.Close()
+		return nil, fmt.Errorf("failed to create rsync log file: %w", err)
+	}
+	if config.ItemizeChanges {
+		// The list of changes is kept whole; it is what the log is for.
+		if logs.changes, err = logs.create(changesPath, "", false); err != nil {
+			logs.Close()
+			return nil, fmt.Errorf("failed to create changes log file: %w", err)
+		}
+	}
+
+	if len(logs.tails) > 0 {
+		logs.stop, logs.stopped = make(chan struct{}), make(chan struct{})
+		go logs.flushTails()
+	}
+
+	if config.LogDir != "" && config.LogRetain > 0 {
+		if err := rotateLogs(config.LogDir, config.LogRetain); err != nil {
+			log.Error().Err(err).Str("path", config.LogDir).Msg("Failed to rotate logs")
+		}
+	}
+	return logs, nil
+}
+
+// rotateLogs removes the logs of all but the newest retain runs in dir. Files
+// that aren't goback run logs are left alone.
+func rotateLogs(dir string, retain int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	type run struct {
+		name  string
+		files []string
+		mtime int64
+	}
+	runs := make(map[string]*run)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		name, ok := strings.CutSuffix(entry.Name(), rsyncLogSuffix)
+		if !ok {
+			if name, ok = strings.CutSuffix(entry.Name(), changesLogSuffix); !ok {
+				continue
+			}
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		r := runs[name]
+		if r == nil {
+			r = &run{name: name}
+			runs[name] = r
+		}
+		r.files = append(r.files, entry.Name())
+		if t := info.ModTime().UnixNano(); t > r.mtime {
+			r.mtime = t
+		}
+	}
+
+	sorted := make([]*run, 0, len(runs))
+	for _, r := range runs {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].mtime != sorted[j].mtime {
+			return sorted[i].mtime > sorted[j].mtime
+		}
+		return sorted[i].name > sorted[j].name
+	})
+
+	for i := retain; i < len(sorted); i++ {
+		for _, name := range sorted[i].files {
+			log.Info().Str("path", filepath.Join(dir, name)).Msg("Removing old log")
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// limitWriter writes at most remaining bytes to w, then a truncation notice,
+// and silently discards the rest.
+type limitWriter struct {
+	w         io.Writer
+	remaining int64
+	truncated bool
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.truncated {
+		return len(p), nil
+	}
+	if int64(len(p)) <= l.remaining {
+		n, err := l.w.Write(p)
+		l.remaining -= int64(n)
+		return n, err
+	}
+	if _, err := l.w.Write(p[:l.remaining]); err != nil {
+		return 0, err
+	}
+	l.truncated = true
+	if _, err := io.WriteString(l.w, "\n[log truncated: max_log_size reached]\n"); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// tailWriter keeps only the last lines lines, or if lines is 0 the last
+// size bytes, of what is written to it and writes them to w on Flush,
+// after a note of how much earlier output was dropped. If rewind is set,
+// each Flush writes to the writer it returns instead, so that a tail
+// flushed again replaces the one before.
+type tailWriter struct {
+	w      io.Writer
+	rewind func() (io.Writer, error)
+	lines  int
+	size   int64
+
+	mu sync.Mutex // Flush runs alongside Write
+
+	ring    [][]byte // the last lines complete lines, oldest at next
+	next    int
+	partial []byte // an unterminated line, or the kept bytes
+	dropped int64
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(p)
+	if t.lines == 0 {
+		t.partial = append(t.partial, p...)
+		// Trimming only once twice the size is buffered keeps the copying
+		// linear in the output.
+		if excess := int64(len(t.partial)) - t.size; excess > t.size {
+			t.partial = append(t.partial[:0], t.partial[excess:]...)
+			t.dropped += excess
+		}
+		return n, nil
+	}
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			t.partial = append(t.partial, p...)
+			return n, nil
+		}
+		line := append(t.partial, p[:i+1]...)
+		t.partial = nil
+		p = p[i+1:]
+		if len(t.ring) < t.lines {
+			t.ring = append(t.ring, line)
+			continue
+		}
+		t.dropped += int64(len(t.ring[t.next]))
+		t.ring[t.next] = line
+		t.next = (t.next + 1) % t.lines
+	}
+}
+
+// Flush writes the tail kept so far.
+func (t *tailWriter) Flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w := t.w
+	if t.rewind != nil {
+		var err error
+		if w, err = t.rewind(); err != nil {
+			return err
+		}
+	}
+	var tail [][]byte
+	if t.lines == 0 {
+		if excess := int64(len(t.partial)) - t.size; excess > 0 {
+			t.partial = t.partial[excess:]
+			t.dropped += excess
+		}
+		// Start on a whole line if there is one.
+		if i := bytes.IndexByte(t.partial, '\n'); t.dropped > 0 && i >= 0 && i+1 < len(t.partial) {
+			t.partial = t.partial[i+1:]
+			t.dropped += int64(i + 1)
+		}
+		tail = [][]byte{t.partial}
+	} else {
+		tail = append(append(tail, t.ring[t.next:]...), t.ring[:t.next]...)
+		tail = append(tail, t.partial)
+	}
+	if t.dropped > 0 {
+		if _, err := fmt.Fprintf(w, "[log truncated: %s of earlier output dropped, only the tail is kept]\n", humanizeBytes(t.dropped)); err != nil {
+			return err
+		}
+	}
+	for _, b := range tail {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
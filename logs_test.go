@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunSnapshotBackupLogDirRotation(t *testing.T) {
+	assumeSourcesExist(t)
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	logDir := filepath.Join(tmpDir, "logs")
+	if err := os.Mkdir(logDir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+
+	// Logs from three earlier runs, plus an unrelated file.
+	now := time.Now()
+	for i, name := range []string{"test_old1", "test_old2", "test_old3"} {
+		modTime := now.Add(time.Duration(i-3) * time.Hour)
+		for _, suffix := range []string{rsyncLogSuffix, changesLogSuffix} {
+			path := filepath.Join(logDir, name+suffix)
+			if err := os.WriteFile(path, []byte("log"), 0644); err != nil {
+				t.Fatalf("Failed to write log: %v", err)
+			}
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatalf("Failed to set mod time: %v", err)
+			}
+		}
+	}
+	if err := os.WriteFile(filepath.Join(logDir, "README"), []byte("keep"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	config := &Config{
+		Destination:    filepath.Join(tmpDir, "dest"),
+		SnapshotPrefix: "test",
+		Source:         []string{"/tmp/source1"},
+		ItemizeChanges: true,
+		LogDir:         logDir,
+		LogRetain:      2,
+	}
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, ">f+++++++++ a.txt\nsent 10 bytes\n", 0)
+	defer func() { execCommand = exec.CommandContext }()
+
+	if err := runSnapshotBackup(context.Background(), config, runOptions{}, false); err != nil {
+		t.Fatalf("runSnapshotBackup failed: %v", err)
+	}
+
+	snapshot, err := getLatestSnapshot(context.Background(), config)
+	if err != nil || snapshot == "" {
+		t.Fatalf("Failed to find snapshot: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(config.Destination, snapshot, "rsync.log")); !os.IsNotExist(err) {
+		t.Errorf("Expected no rsync.log inside the snapshot with log_dir set")
+	}
+
+	entries, err := os.ReadDir(logDir)
+	if err != nil {
+		t.Fatalf("Failed to read log dir: %v", err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	want := []string{
+		"README",
+		snapshot + changesLogSuffix,
+		snapshot + rsyncLogSuffix,
+		"test_old3" + changesLogSuffix,
+		"test_old3" + rsyncLogSuffix,
+	}
+	sort.Strings(want)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected log dir to contain %v, got %v", want, got)
+	}
+
+	changes, err := os.ReadFile(filepath.Join(logDir, snapshot+changesLogSuffix))
+	if err != nil || !strings.Contains(string(changes), "a.txt") {
+		t.Errorf("Expected the changes log in log_dir to list a.txt, got %q (%v)", changes, err)
+	}
+}
+
+func TestLimitWriter(t *testing.T) {
+	var buf strings.Builder
+	w := &limitWriter{w: &buf, remaining: 10}
+	for _, chunk := range []string{"12345", "67890abc", "more"} {
+		if n, err := w.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+	want := "1234567890\n[log truncated: max_log_size reached]\n"
+	if buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+}
+
+func TestTailWriter(t *testing.T) {
+	var buf strings.Builder
+	w := &tailWriter{w: &buf, lines: 2}
+	for _, chunk := range []string{"one\ntw", "o\nthree\n", "four\nfi", "ve"} {
+		if n, err := w.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	want := "[log truncated: 8 B of earlier output dropped, only the tail is kept]\nthree\nfour\nfive"
+	if buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+
+	buf.Reset()
+	w = &tailWriter{w: &buf, size: 12}
+	for i := 0; i < 100; i++ {
+		if _, err := w.Write([]byte("line\n")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if _, err := w.Write([]byte("last\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	// The tail starts on a whole line.
+	if !strings.HasSuffix(buf.String(), "]\nline\nlast\n") || !strings.HasPrefix(buf.String(), "[log truncated: 495 B") {
+		t.Errorf("Expected the last two lines after a truncation note, got %q", buf.String())
+	}
+
+	buf.Reset()
+	w = &tailWriter{w: &buf, lines: 5}
+	if _, err := w.Write([]byte("short\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if buf.String() != "short\n" {
+		t.Errorf("Expected short output to be kept whole, got %q", buf.String())
+	}
+}
+
+func TestRsyncLogTail(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	name := "test_" + time.Now().AddDate(0, 0, -1).Format(snapshotTimeFormat)
+	snapshot := filepath.Join(tmpDir, name)
+	if err := os.Mkdir(snapshot, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", LogTailLines: 20}
+	if err := validateConfig(config); err != nil {
+		t.Fatalf("validateConfig failed: %v", err)
+	}
+	logs, err := openRunLogs(config, snapshot, name)
+	if err != nil {
+		t.Fatalf("openRunLogs failed: %v", err)
+	}
+
+	// A first full backup lists every file before the statistics.
+	w := &rsyncOutputWriter{out: logs.rsync}
+	var output strings.Builder
+	output.WriteString("sending incremental file list\n")
+	for i := 0; i < 100000; i++ {
+		output.WriteString("home/user/file" + strings.Repeat("x", i%20) + ".txt\n")
+	}
+	output.WriteString("Number of regular files transferred: 100,000\n" +
+		"Total file size: 5.60G bytes\n" +
+		"Total transferred file size: 5.60G bytes\n")
+	if _, err := w.Write([]byte(output.String())); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	logs.Close()
+
+	if w.stats.FilesTransferred != 100000 || w.stats.TotalBytes != 5600000000 {
+		t.Errorf("Expected the statistics to be parsed from the full output, got %+v", w.stats)
+	}
+	data, err := os.ReadFile(filepath.Join(snapshot, "rsync.log"))
+	if err != nil {
+		t.Fatalf("Failed to read rsync log: %v", err)
+	}
+	if len(data) > 2048 || strings.Contains(string(data), "sending incremental file list") {
+		t.Errorf("Expected only the tail of the output in the log, got %d bytes", len(data))
+	}
+	if !strings.Contains(string(data), "[log truncated:") {
+		t.Errorf("Expected a truncation note in the log, got %q", data)
+	}
+	// The statistics at the end stay readable for -stats-history.
+	history, err := statsHistory(context.Background(), config)
+	if err != nil {
+		t.Fatalf("statsHistory failed: %v", err)
+	}
+	if len(history) != 1 || history[0].Stats.FilesTransferred != 100000 {
+		t.Errorf("Expected the statistics in the log tail, got %+v", history)
+	}
+
+	if err := validateConfig(&Config{LogTailLines: 10, LogTailSize: "1M"}); err == nil {
+		t.Error("Expected an error when log_tail_lines and log_tail_size are both set")
+	}
+	if err := validateConfig(&Config{LogTailSize: "lots"}); err == nil {
+		t.Error("Expected an error for an invalid log_tail_size")
+	}
+}
+
+func TestRsyncLogTailFlushedDuringRun(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	tailFlushInterval = 10 * time.Millisecond
+	defer func() { tailFlushInterval = 5 * time.Second }()
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", LogTailLines: 2, ItemizeChanges: true}
+	logs, err := openRunLogs(config, tmpDir, "test")
+	if err != nil {
+		t.Fatalf("openRunLogs failed: %v", err)
+	}
+	rsyncLog := filepath.Join(tmpDir, "rsync.log")
+	for _, line := range []string{"one\n", "two\n", "rsync error: some files could not be transferred\n"} {
+		if _, err := io.WriteString(logs.rsync, line); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	for _, line := range []string{">f+++++++++ a\n", ">f+++++++++ b\n", ">f+++++++++ c\n"} {
+		if _, err := io.WriteString(logs.changes, line); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	// The tail shows up while the run goes on, as a killed run would
+	// leave it.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(rsyncLog)
+		if strings.Contains(string(data), "rsync error") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the tail in the log before the run ends, got %q", data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Flushing again replaces the tail instead of adding to it.
+	if _, err := io.WriteString(logs.rsync, "done\n"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	logs.Close()
+	data, err := os.ReadFile(rsyncLog)
+	if err != nil {
+		t.Fatalf("Failed to read rsync log: %v", err)
+	}
+	want := "[log truncated: 8 B of earlier output dropped, only the tail is kept]\nrsync error: some files could not be transferred\ndone\n"
+	if !strings.HasSuffix(string(data), want) || strings.Count(string(data), "[log truncated") != 1 {
+		t.Errorf("Expected the log to end in %q, got %q", want, data)
+	}
+
+	// Only rsync.log is cut down to its tail.
+	changes, err := os.ReadFile(filepath.Join(tmpDir, "changes.log"))
+	if err != nil {
+		t.Fatalf("Failed to read changes log: %v", err)
+	}
+	if strings.Count(string(changes), ">f+++++++++") != 3 {
+		t.Errorf("Expected every change in changes.log, got %q", changes)
+	}
+}
@@ -1,16 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
@@ -18,51 +28,586 @@ import (
 
 var dryRun = flag.Bool("dry-run", false, "print actions without executing them")
 var configFile = flag.String("config", "config.yaml", "path to the configuration file")
+var reclaimTo = flag.String("reclaim-to", "", "delete the oldest snapshots until free space reaches this percentage (e.g. 20%) or size (e.g. 50G), then exit")
+var explain = flag.Bool("explain", false, "log why each snapshot is kept or purged")
+var verifySnapshot = flag.String("verify-checksums", "", "verify the named snapshot against its checksum file, then exit")
+var listExcludedFlag = flag.Bool("list-excluded", false, "list the source paths the exclude rules skip, then exit")
+var compareToSourceFlag = flag.Bool("compare-to-source", false, "report how many source files differ from the latest snapshot, then exit")
+var resume = flag.Bool("resume", false, "continue an interrupted snapshot backup in .unfinished instead of starting over")
+var tagFlag = flag.String("tag", "", "add the tags given as the following arguments to the named snapshot, then exit")
+var labelFlag = flag.String("label", "", "rename the named snapshot to carry the label given as the next argument, then exit")
+var printCommand = flag.Bool("print-command", false, "print the rsync command a backup would run, then exit")
+var listFlag = flag.Bool("list", false, "list the snapshots in the destination, then exit")
+var allPrefixes = flag.Bool("all-prefixes", false, "with -list, list snapshots of every prefix, not just snapshot_prefix")
+var statsHistoryFlag = flag.Bool("stats-history", false, "print the rsync statistics of every snapshot, then exit")
+var nameFlag = flag.String("name", "", "name the new snapshot instead of using the prefix and a timestamp")
+var tagFilter = flag.String("tag-filter", "", "with -list, only list snapshots carrying this tag")
+var expiryFlag = flag.Bool("expiry", false, "with -list, estimate when each snapshot will be purged")
+var forcePurge = flag.Bool("force-purge", false, "purge even if more than max_purge_percent of the snapshots would be deleted")
+var compactFlag = flag.Bool("compact", false, "store snapshots older than compact_after as highly compressed archives, then exit")
+var repairFlag = flag.Bool("repair", false, "fix the latest symlink and snapshot times after manual changes to the destination and report anomalies, then exit")
+var gcFlag = flag.Bool("gc", false, "remove what interrupted and failed runs left behind that is older than gc_age, then exit")
+var healthcheckFlag = flag.Bool("healthcheck", false, "check that the snapshots recorded by the last run are still in the destination, then exit")
+var checkSourcesFlag = flag.Bool("check-sources", false, "check that a sample of every source can be read by the current user, then exit")
+var verifyLinkChainFlag = flag.Bool("verify-link-dest-chain", false, "check that unchanged files are hard linked between consecutive snapshots, then exit")
+var solidifyFlag = flag.String("solidify", "", "replace the named snapshot with a copy that shares no files with other snapshots, then exit")
+var solidifyTo = flag.String("solidify-to", "", "with -solidify, write the copy to this directory and leave the snapshot alone")
+var simulateFlag = flag.Bool("simulate", false, "show what the keep policy given by -daily, -weekly and -monthly would keep, then exit")
+var simulateDaily = flag.Int("daily", -1, "with -simulate, the number of daily snapshots to keep instead of keep.daily")
+var simulateWeekly = flag.Int("weekly", -1, "with -simulate, the number of weekly snapshots to keep instead of keep.weekly")
+var simulateMonthly = flag.Int("monthly", -1, "with -simulate, the number of monthly snapshots to keep instead of keep.monthly")
+var deleteFlag = flag.String("delete", "", "delete the comma separated snapshots, then exit")
+var force = flag.Bool("force", false, "with -delete, allow deleting the latest snapshot")
+var printSchemaFlag = flag.Bool("print-schema", false, "print the JSON Schema of the config file, then exit")
+var validateSchemaFlag = flag.Bool("validate-schema", false, "check the configuration file against the config schema, then exit")
+var watchFlag = flag.Bool("watch", false, "watch the sources and back up each time changes settle for watch_debounce, until interrupted")
+var dumpConfigFlag = flag.Bool("dump-config", false, "print the effective configuration, with defaults applied and secrets redacted, then exit")
+var exportFlag = flag.String("export", "", "copy the named snapshot to the destination given by -to, then exit")
+var exportTo = flag.String("to", "", "with -export, where to copy the snapshot: a local path or an rsync destination such as host:path")
+var yes = flag.Bool("yes", false, "don't ask before backing up files larger than warn_file_size")
+var noColor = flag.Bool("no-color", false, "don't color the output, even on a terminal")
+var dryRunLog = flag.String("dry-run-log", "", "during a dry run, also write rsync's output to this file")
 
 type Config struct {
-	Mode                     string   `yaml:"mode"`
-	Destination              string   `yaml:"destination"`
-	SnapshotPrefix           string   `yaml:"snapshot_prefix"`
-	Source                   []string `yaml:"source"`
-	Exclude                  []string `yaml:"exclude"`
-	Keep                     Keep     `yaml:"keep"`
-	RsyncExtraFlags          string   `yaml:"rsync_extra_flags"`
-	IgnoreVanishedFilesError bool     `yaml:"ignore_vanished_files_error"`
+	Mode                      string   `yaml:"mode" json:"mode" toml:"mode"`
+	Destination               string   `yaml:"destination" json:"destination" toml:"destination"`
+	SnapshotPrefix            string   `yaml:"snapshot_prefix" json:"snapshot_prefix" toml:"snapshot_prefix"`
+	SnapshotName              string   `yaml:"snapshot_name" json:"snapshot_name" toml:"snapshot_name"`
+	Sources                   []source `yaml:"source" json:"source" toml:"source"`
+	Exclude                   []string `yaml:"exclude" json:"exclude" toml:"exclude"`
+	ExcludeURL                string   `yaml:"exclude_url" json:"exclude_url" toml:"exclude_url"`
+	Keep                      Keep     `yaml:"keep" json:"keep" toml:"keep"`
+	RsyncExtraFlags           string   `yaml:"rsync_extra_flags" json:"rsync_extra_flags" toml:"rsync_extra_flags"`
+	IgnoreVanishedFilesError  bool     `yaml:"ignore_vanished_files_error" json:"ignore_vanished_files_error" toml:"ignore_vanished_files_error"`
+	ItemizeChanges            bool     `yaml:"itemize_changes" json:"itemize_changes" toml:"itemize_changes"`
+	Progress                  bool     `yaml:"progress" json:"progress" toml:"progress"`
+	CopyLinks                 bool     `yaml:"copy_links" json:"copy_links" toml:"copy_links"`
+	CopyUnsafeLinks           bool     `yaml:"copy_unsafe_links" json:"copy_unsafe_links" toml:"copy_unsafe_links"`
+	DirMerge                  string   `yaml:"dir_merge" json:"dir_merge" toml:"dir_merge"`
+	LogTimestamps             *bool    `yaml:"log_timestamps" json:"log_timestamps" toml:"log_timestamps"`
+	LogPrefix                 string   `yaml:"log_prefix" json:"log_prefix" toml:"log_prefix"`
+	PidFile                   bool     `yaml:"pid_file" json:"pid_file" toml:"pid_file"`
+	LockWait                  string   `yaml:"lock_wait" json:"lock_wait" toml:"lock_wait"`
+	MaintenanceWindow         Window   `yaml:"maintenance_window" json:"maintenance_window" toml:"maintenance_window"`
+	WatchDebounce             string   `yaml:"watch_debounce" json:"watch_debounce" toml:"watch_debounce"`
+	Verbosity                 *int     `yaml:"verbosity" json:"verbosity" toml:"verbosity"`
+	IncludeExtensions         []string `yaml:"include_extensions" json:"include_extensions" toml:"include_extensions"`
+	Archive                   bool     `yaml:"archive" json:"archive" toml:"archive"`
+	ArchiveCompress           bool     `yaml:"archive_compress" json:"archive_compress" toml:"archive_compress"`
+	CompactAfter              string   `yaml:"compact_after" json:"compact_after" toml:"compact_after"`
+	GCAge                     string   `yaml:"gc_age" json:"gc_age" toml:"gc_age"`
+	Deduplicate               bool     `yaml:"deduplicate" json:"deduplicate" toml:"deduplicate"`
+	GenerateChecksums         bool     `yaml:"generate_checksums" json:"generate_checksums" toml:"generate_checksums"`
+	VerifyAfterRename         bool     `yaml:"verify_after_rename" json:"verify_after_rename" toml:"verify_after_rename"`
+	SkipIfUnchanged           bool     `yaml:"skip_if_unchanged" json:"skip_if_unchanged" toml:"skip_if_unchanged"`
+	PurgeOnlyAfterNewSnapshot bool     `yaml:"purge_only_after_new_snapshot" json:"purge_only_after_new_snapshot" toml:"purge_only_after_new_snapshot"`
+	Chmod                     string   `yaml:"chmod" json:"chmod" toml:"chmod"`
+	LogDir                    string   `yaml:"log_dir" json:"log_dir" toml:"log_dir"`
+	LogRetain                 int      `yaml:"log_retain" json:"log_retain" toml:"log_retain"`
+	MaxLogSize                string   `yaml:"max_log_size" json:"max_log_size" toml:"max_log_size"`
+	LogTailLines              int      `yaml:"log_tail_lines" json:"log_tail_lines" toml:"log_tail_lines"`
+	LogTailSize               string   `yaml:"log_tail_size" json:"log_tail_size" toml:"log_tail_size"`
+	WarnFileSize              string   `yaml:"warn_file_size" json:"warn_file_size" toml:"warn_file_size"`
+	MinFreeInodes             uint64   `yaml:"min_free_inodes" json:"min_free_inodes" toml:"min_free_inodes"`
+	PurgeExclude              []string `yaml:"purge_exclude" json:"purge_exclude" toml:"purge_exclude"`
+	ProtectTagged             []string `yaml:"protect_tagged" json:"protect_tagged" toml:"protect_tagged"`
+	AllowMissingSource        bool     `yaml:"allow_missing_source" json:"allow_missing_source" toml:"allow_missing_source"`
+	DriftThreshold            float64  `yaml:"drift_threshold" json:"drift_threshold" toml:"drift_threshold"`
+	RsyncPassword             string   `yaml:"rsync_password" json:"rsync_password" toml:"rsync_password"`
+	RsyncPasswordFile         string   `yaml:"rsync_password_file" json:"rsync_password_file" toml:"rsync_password_file"`
+	AppendVerify              bool     `yaml:"append_verify" json:"append_verify" toml:"append_verify"`
+	SlowRunFactor             float64  `yaml:"slow_run_factor" json:"slow_run_factor" toml:"slow_run_factor"`
+	GitignoreExclude          bool     `yaml:"gitignore_exclude" json:"gitignore_exclude" toml:"gitignore_exclude"`
+	ExcludeCacheDirs          bool     `yaml:"exclude_cache_dirs" json:"exclude_cache_dirs" toml:"exclude_cache_dirs"`
+	ExcludeIfPresent          []string `yaml:"exclude_if_present" json:"exclude_if_present" toml:"exclude_if_present"`
+	GitHistoryOnly            bool     `yaml:"git_history_only" json:"git_history_only" toml:"git_history_only"`
+	Nice                      int      `yaml:"nice" json:"nice" toml:"nice"`
+	Ionice                    string   `yaml:"ionice" json:"ionice" toml:"ionice"`
+	MaxDelete                 int      `yaml:"max_delete" json:"max_delete" toml:"max_delete"`
+	MinFileAge                string   `yaml:"min_file_age" json:"min_file_age" toml:"min_file_age"`
+	Umask                     string   `yaml:"umask" json:"umask" toml:"umask"`
+	SkipSpecialFiles          bool     `yaml:"skip_special_files" json:"skip_special_files" toml:"skip_special_files"`
+	Fuzzy                     int      `yaml:"fuzzy" json:"fuzzy" toml:"fuzzy"`
+	ModifyWindow              int      `yaml:"modify_window" json:"modify_window" toml:"modify_window"`
+	BlockSize                 int      `yaml:"block_size" json:"block_size" toml:"block_size"`
+	ChecksumSeed              int      `yaml:"checksum_seed" json:"checksum_seed" toml:"checksum_seed"`
+	Sparse                    bool     `yaml:"sparse" json:"sparse" toml:"sparse"`
+	PurgeGracePeriod          string   `yaml:"purge_grace_period" json:"purge_grace_period" toml:"purge_grace_period"`
+	MaxPurgePercent           float64  `yaml:"max_purge_percent" json:"max_purge_percent" toml:"max_purge_percent"`
+	FinalizeMode              string   `yaml:"finalize_mode" json:"finalize_mode" toml:"finalize_mode"`
+	SnapshotBackend           string   `yaml:"snapshot_backend" json:"snapshot_backend" toml:"snapshot_backend"`
+	ZFSDataset                string   `yaml:"zfs_dataset" json:"zfs_dataset" toml:"zfs_dataset"`
+
+	// Sources split by splitSources: the paths rsync copies and the
+	// commands whose output is saved.
+	Source   []string        `yaml:"-" json:"-" toml:"-"`
+	Commands []commandSource `yaml:"-" json:"-" toml:"-"`
 }
 
 type Keep struct {
-	Daily   int `yaml:"daily"`
-	Weekly  int `yaml:"weekly"`
-	Monthly int `yaml:"monthly"`
+	Daily   int `yaml:"daily" json:"daily" toml:"daily"`
+	Weekly  int `yaml:"weekly" json:"weekly" toml:"weekly"`
+	Monthly int `yaml:"monthly" json:"monthly" toml:"monthly"`
+	// MonthlyAnchor picks which snapshot of each week or month is kept:
+	// "last" (the default) or "first".
+	MonthlyAnchor string `yaml:"monthly_anchor" json:"monthly_anchor" toml:"monthly_anchor"`
+	// DailyAnchor, a time of day such as "02:00", makes the daily tier keep
+	// the snapshot of each day taken closest to it rather than the most
+	// recent snapshots.
+	DailyAnchor string `yaml:"daily_anchor" json:"daily_anchor" toml:"daily_anchor"`
 }
 
 func main() {
 	flag.Parse()
+	runID = newRunID()
+
+	setupLogging(os.Stdout, &Config{})
 
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC1123Z})
+	if !*simulateFlag && (*simulateDaily >= 0 || *simulateWeekly >= 0 || *simulateMonthly >= 0) {
+		log.Fatal().Msg("-daily, -weekly and -monthly only apply with -simulate")
+	}
+
+	if *printSchemaFlag {
+		if err := printSchema(os.Stdout); err != nil {
+			log.Fatal().Err(err).Msg("printing schema failed")
+		}
+		return
+	}
+
+	// Checked before reading the config, which stops at the first error.
+	if *validateSchemaFlag {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("error reading config")
+		}
+		problems, err := validateSchema(*configFile, data)
+		if err != nil {
+			log.Fatal().Err(err).Msg("error parsing config")
+		}
+		for _, p := range problems {
+			log.Error().Str("config", *configFile).Msg(p)
+		}
+		if len(problems) > 0 {
+			log.Fatal().Int("problems", len(problems)).Msg("config doesn't match the schema")
+		}
+		log.Info().Str("config", *configFile).Msg("Config matches the schema")
+		return
+	}
 
 	config, err := readConfig(*configFile)
 	if err != nil {
 		log.Fatal().Err(err).Msg("error reading config")
 	}
+	setupLogging(os.Stdout, config)
 
-	if config.Mode == "" || config.Mode == "snapshot" {
-		if err := runSnapshotBackup(config, *dryRun); err != nil {
-			log.Fatal().Err(err).Msg("snapshot backup failed")
+	if *dumpConfigFlag {
+		if err := dumpConfig(config, os.Stdout); err != nil {
+			log.Fatal().Err(err).Msg("dumping config failed")
 		}
+		return
+	}
+
+	applyUmask(config)
+
+	// Cancel on SIGINT or SIGTERM so rsync is stopped and purge halts
+	// between deletions instead of being killed part way through one.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		if err := purgeBackups(config, *dryRun); err != nil {
-			log.Fatal().Err(err).Msg("purging old backups failed")
+	if err := probeDestination(ctx, config); err != nil {
+		log.Fatal().Err(err).Msg("could not start")
+	}
+
+	// Only what filters the sources needs exclude_url, so that the other
+	// commands don't depend on its server.
+	loadExcludes := func() {
+		if config.ExcludeURL == "" {
+			return
 		}
-	} else if config.Mode == "simple" {
-		if err := runSimpleBackup(config, *dryRun); err != nil {
-			log.Fatal().Err(err).Msg("simple backup failed")
+		if err := loadExcludeURL(ctx, config); err != nil {
+			log.Fatal().Err(err).Msg("loading exclude_url failed")
 		}
-	} else {
-		log.Fatal().Str("mode", config.Mode).Msg("invalid backup mode")
+	}
+
+	if *statsHistoryFlag {
+		history, err := statsHistory(ctx, config)
+		if err != nil {
+			log.Fatal().Err(err).Msg("reading stats history failed")
+		}
+		if err := writeStatsHistory(history, os.Stdout); err != nil {
+			log.Fatal().Err(err).Msg("writing stats history failed")
+		}
+		return
+	}
+
+	if *printCommand {
+		loadExcludes()
+		command, err := rsyncCommandLine(ctx, config, *dryRun)
+		if err != nil {
+			log.Fatal().Err(err).Msg("building rsync command failed")
+		}
+		fmt.Println(command)
+		return
+	}
+
+	if *listFlag {
+		if err := listSnapshots(ctx, config, *allPrefixes, *expiryFlag, *tagFilter, os.Stdout); err != nil {
+			log.Fatal().Err(err).Msg("listing snapshots failed")
+		}
+		return
+	}
+
+	if *simulateFlag {
+		keep := config.Keep
+		if *simulateDaily >= 0 {
+			keep.Daily = *simulateDaily
+		}
+		if *simulateWeekly >= 0 {
+			keep.Weekly = *simulateWeekly
+		}
+		if *simulateMonthly >= 0 {
+			keep.Monthly = *simulateMonthly
+		}
+		if err := simulateRetention(ctx, config, keep, os.Stdout); err != nil {
+			log.Fatal().Err(err).Msg("simulating retention failed")
+		}
+		return
+	}
+
+	if *checkSourcesFlag {
+		loadExcludes()
+		problems, err := checkSources(ctx, config)
+		if err != nil {
+			log.Fatal().Err(err).Msg("checking sources failed")
+		}
+		for _, p := range problems {
+			log.Error().Str("source", p.Source).Str("path", p.Path).Err(p.Err).Msg("Cannot read source")
+		}
+		if len(problems) > 0 {
+			log.Fatal().Int("problems", len(problems)).Msg("sources are not readable")
+		}
+		log.Info().Int("sources", len(config.Source)).Msg("All sources are readable")
+		return
+	}
+
+	if *healthcheckFlag {
+		missing, known, err := missingSnapshots(ctx, config)
+		if err != nil {
+			log.Fatal().Err(err).Msg("health check failed")
+		}
+		if !known {
+			log.Info().Msg("No snapshot state recorded yet, nothing to check")
+			return
+		}
+		for _, name := range missing {
+			log.Error().Str("snapshot", name).Msg("Snapshot disappeared since the last run")
+		}
+		if len(missing) > 0 {
+			log.Fatal().Int("missing", len(missing)).Msg("snapshots are missing")
+		}
+		log.Info().Msg("All snapshots recorded by the last run are present")
+		return
+	}
+
+	if *watchFlag {
+		loadExcludes()
+		err := watchSources(ctx, config, watchDebounce(config), func(ctx context.Context) error {
+			return runWatchedBackup(ctx, config, *dryRun)
+		})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Fatal().Err(err).Msg("watching sources failed")
+		}
+		return
+	}
+
+	release, err := lockDestination(ctx, config, *dryRun)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not start")
+	}
+	defer release()
+	log.Logger = log.Logger.Hook(releaseOnFatal(release))
+
+	if *listExcludedFlag {
+		loadExcludes()
+		if err := listExcluded(ctx, config, os.Stdout); err != nil {
+			log.Fatal().Err(err).Msg("listing excluded paths failed")
+		}
+		return
+	}
+
+	if *compareToSourceFlag {
+		loadExcludes()
+		report, err := compareToSource(ctx, config)
+		if err != nil {
+			log.Fatal().Err(err).Msg("comparing to source failed")
+		}
+		log.Info().Str("snapshot", report.Snapshot).Int64("files", report.Files).Int("changed", report.Changed).
+			Str("drift", fmt.Sprintf("%.1f%%", report.Percent())).Msg("Compared latest snapshot to source")
+		if config.DriftThreshold > 0 && report.Percent() > config.DriftThreshold {
+			log.Fatal().Float64("drift_threshold", config.DriftThreshold).Msg("drift exceeds drift_threshold; check that the sources are the right ones")
+		}
+		return
+	}
+
+	if *labelFlag != "" {
+		if flag.NArg() != 1 {
+			log.Fatal().Msg("usage: -label <snapshot> <label>")
+		}
+		if _, err := labelSnapshot(ctx, config, *labelFlag, flag.Arg(0), *dryRun); err != nil {
+			log.Fatal().Err(err).Msg("labelling snapshot failed")
+		}
+		if !*dryRun {
+			updateSnapshotState(ctx, config)
+			refreshLatestLink(ctx, config)
+		}
+		return
+	}
+
+	if *tagFlag != "" {
+		if flag.NArg() == 0 {
+			log.Fatal().Msg("usage: -tag <snapshot> <tag>...")
+		}
+		if _, err := tagSnapshot(ctx, config, *tagFlag, flag.Args(), *dryRun); err != nil {
+			log.Fatal().Err(err).Msg("tagging snapshot failed")
+		}
+		return
+	}
+
+	if *verifySnapshot != "" {
+		problems, err := verifySnapshotChecksums(ctx, config, *verifySnapshot)
+		if err != nil {
+			log.Fatal().Err(err).Msg("verifying checksums failed")
+		}
+		for _, p := range problems {
+			log.Error().Str("snapshot", *verifySnapshot).Msg(p)
+		}
+		if len(problems) > 0 {
+			log.Fatal().Int("problems", len(problems)).Msg("snapshot failed checksum verification")
+		}
+		log.Info().Str("snapshot", *verifySnapshot).Msg("All checksums match")
+		return
+	}
+
+	if *verifyLinkChainFlag {
+		problems, err := verifyLinkChain(ctx, config)
+		if err != nil {
+			log.Fatal().Err(err).Msg("verifying link chain failed")
+		}
+		for _, p := range problems {
+			log.Error().Msg(p)
+		}
+		if len(problems) > 0 {
+			log.Fatal().Int("problems", len(problems)).Msg("snapshots are not hard linked as expected")
+		}
+		log.Info().Msg("All unchanged files are hard linked to the previous snapshot")
+		return
+	}
+
+	if *exportFlag != "" {
+		if err := exportSnapshot(ctx, config, *exportFlag, *exportTo, *dryRun); err != nil {
+			log.Fatal().Err(err).Msg("exporting snapshot failed")
+		}
+		return
+	}
+
+	if *deleteFlag != "" {
+		err := deleteSnapshots(ctx, config, splitNames(*deleteFlag), *force, *dryRun)
+		if !*dryRun {
+			updateSnapshotState(ctx, config)
+			refreshLatestLink(ctx, config)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("deleting snapshots failed")
+		}
+		return
+	}
+
+	if *solidifyFlag != "" {
+		if err := solidifySnapshot(ctx, config, *solidifyFlag, *solidifyTo, *dryRun); err != nil {
+			log.Fatal().Err(err).Msg("solidifying snapshot failed")
+		}
+		return
+	}
+
+	if *compactFlag {
+		err := compactSnapshots(ctx, config, *dryRun)
+		if !*dryRun {
+			updateSnapshotState(ctx, config)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("compacting snapshots failed")
+		}
+		return
+	}
+
+	if *repairFlag {
+		problems, err := repairDestination(ctx, config, *dryRun)
+		if err != nil {
+			log.Fatal().Err(err).Msg("repairing destination failed")
+		}
+		if !*dryRun {
+			updateSnapshotState(ctx, config)
+		}
+		log.Info().Int("problems", len(problems)).Msg("Repair finished")
+		return
+	}
+
+	if *gcFlag {
+		if err := collectGarbage(ctx, config, *dryRun); err != nil {
+			log.Fatal().Err(err).Msg("garbage collection failed")
+		}
+		return
+	}
+
+	if *reclaimTo != "" {
+		target, err := parseReclaimTarget(*reclaimTo)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid -reclaim-to value")
+		}
+		err = reclaimSpace(ctx, config, target, *dryRun)
+		if !*dryRun {
+			updateSnapshotState(ctx, config)
+			refreshLatestLink(ctx, config)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("reclaiming space failed")
+		}
+		return
+	}
+
+	inWindow, windowEnd, windowOpens := checkMaintenanceWindow(config)
+	if !inWindow {
+		if !*dryRun {
+			log.Info().Time("opens", windowOpens).Msg("Outside the maintenance window, not starting")
+			return
+		}
+		log.Info().Time("opens", windowOpens).Msg("[Dry Run] Outside the maintenance window, running anyway")
+	}
+	stopWindowWarning := warnAtWindowEnd(windowEnd)
+	defer stopWindowWarning()
+
+	loadExcludes()
+	if err := preflightLargeFiles(config, *dryRun); err != nil {
+		log.Fatal().Err(err).Msg("preflight failed")
+	}
+
+	if err := runBackup(ctx, config, runOptions{Name: *nameFlag, Resume: *resume}, *dryRun); err != nil {
+		log.Fatal().Err(err).Msg("backup failed")
 	}
 }
 
+// lockDestination takes the pid file if pid_file is set, waiting up to
+// lock_wait for a running backup to finish, and returns the function that
+// releases it.
+func lockDestination(ctx context.Context, config *Config, dryRun bool) (func(), error) {
+	if !config.PidFile || dryRun {
+		return func() {}, nil
+	}
+	// validateConfig has already checked the duration.
+	lockWait, _ := time.ParseDuration(config.LockWait)
+	return waitForPidFile(ctx, pidFilePath(config), lockWait)
+}
+
+// runBackup runs a backup in the configured mode, purges old snapshots after
+// a snapshot backup and records how long the run took.
+func runBackup(ctx context.Context, config *Config, opts runOptions, dryRun bool) error {
+	start := timeNow()
+	switch config.Mode {
+	case "", "snapshot":
+		warnMissingSnapshots(ctx, config)
+		err := runSnapshotBackup(ctx, config, opts, dryRun)
+		skipped := errors.Is(err, errSourcesUnchanged)
+		if err != nil && !skipped {
+			return fmt.Errorf("snapshot backup failed: %w", err)
+		}
+		if skipped && config.PurgeOnlyAfterNewSnapshot {
+			// Purging by today's date without a new snapshot would erode
+			// the history of sources that rarely change.
+			log.Info().Msg("No new snapshot, skipping purge")
+			return nil
+		}
+		err = purgeBackups(ctx, config, dryRun)
+		if !dryRun {
+			updateSnapshotState(ctx, config)
+		}
+		if err != nil {
+			return fmt.Errorf("purging old backups failed: %w", err)
+		}
+	case "simple":
+		if err := runSimpleBackup(ctx, config, dryRun); err != nil {
+			return fmt.Errorf("simple backup failed: %w", err)
+		}
+	default:
+		return fmt.Errorf("invalid backup mode %q", config.Mode)
+	}
+
+	if config.SlowRunFactor > 0 && !dryRun {
+		if err := recordRunDuration(config, timeNow().Sub(start)); err != nil {
+			log.Warn().Err(err).Msg("Could not check run duration")
+		}
+	}
+	return nil
+}
+
+// runWatchedBackup runs a backup for -watch. Like a run from cron it waits
+// for the pid file. Outside the maintenance window it waits for the window
+// to open, so the changes are backed up then.
+func runWatchedBackup(ctx context.Context, config *Config, dryRun bool) error {
+	if inWindow, _, opens := checkMaintenanceWindow(config); !inWindow && !dryRun {
+		log.Info().Time("opens", opens).Msg("Outside the maintenance window, backing up once it opens")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(opens.Sub(timeNow())):
+		}
+	}
+	release, err := lockDestination(ctx, config, dryRun)
+	if err != nil {
+		return err
+	}
+	defer release()
+	// allow_missing_source drops missing sources from the config for one run.
+	runConfig := *config
+	runConfig.Source = append([]string(nil), config.Source...)
+	return runBackup(ctx, &runConfig, runOptions{}, dryRun)
+}
+
+// setupLogging points the global logger at out, applying the config's
+// timestamp and prefix settings. Timestamps can be turned off for running
+// under systemd, where journald adds its own.
+func setupLogging(out io.Writer, config *Config) {
+	colorOutput = useColor(out)
+	prefix := config.LogPrefix
+	if runID != "" {
+		prefix += "[" + runID + "] "
+	}
+	if prefix != "" {
+		out = &prefixWriter{prefix: []byte(prefix), out: out}
+	}
+	w := zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC1123Z, NoColor: !colorOutput}
+	if config.LogTimestamps != nil && !*config.LogTimestamps {
+		w.PartsExclude = []string{zerolog.TimestampFieldName}
+	}
+	log.Logger = log.Output(w)
+}
+
+// prefixWriter prepends prefix to every write. The console writer emits one
+// write per log line.
+type prefixWriter struct {
+	prefix []byte
+	out    io.Writer
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	line := make([]byte, 0, len(w.prefix)+len(p))
+	line = append(line, w.prefix...)
+	line = append(line, p...)
+	if _, err := w.out.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
 func readConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -70,24 +615,334 @@ func readConfig(path string) (*Config, error) {
 	}
 
 	var config Config
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
+	if err := unmarshalConfig(path, data, &config); err != nil {
+		return nil, err
+	}
+	splitSources(&config)
+
+	if err := renderConfigTemplates(&config); err != nil {
+		return nil, err
+	}
+	if err := resolveSecrets(&config); err != nil {
+		return nil, err
+	}
+	if err := validateConfig(&config); err != nil {
 		return nil, err
 	}
 
 	return &config, nil
 }
 
-var execCommand = exec.Command
+// unmarshalConfig decodes data into config in the format given by the
+// extension of path: JSON for .json, TOML for .toml and YAML otherwise.
+func unmarshalConfig(path string, data []byte, config *Config) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return json.Unmarshal(data, config)
+	case ".toml":
+		return toml.Unmarshal(data, config)
+	default:
+		return yaml.Unmarshal(data, config)
+	}
+}
+
+// chmodItem loosely matches one comma separated item of an rsync --chmod
+// spec, e.g. "D755", "F644" or "Fgo-w".
+var chmodItem = regexp.MustCompile(`^[DF]?([0-7]{3,4}|[ugoa]*[-+=][rwxXst]*)$`)
+
+// isRsyncDaemon reports whether dest is on an rsync daemon, given either as
+// rsync://host/module/path or host::module/path.
+func isRsyncDaemon(dest string) bool {
+	return strings.HasPrefix(dest, "rsync://") || strings.Contains(dest, "::")
+}
+
+// validateConfig checks for option combinations that cannot work together.
+func validateConfig(config *Config) error {
+	if err := validateCommandSources(config); err != nil {
+		return err
+	}
+	if isRsyncDaemon(config.Destination) {
+		// Snapshots need the destination to be listed, renamed and purged,
+		// which can't be done through the rsync protocol.
+		if config.Mode != "simple" {
+			return fmt.Errorf("rsync daemon destination %s is only supported in simple mode", config.Destination)
+		}
+		if config.PidFile {
+			return fmt.Errorf("pid_file can't be used with rsync daemon destination %s", config.Destination)
+		}
+	}
+	switch config.SnapshotBackend {
+	case "", "hardlink":
+	case "btrfs", "zfs":
+		if config.Mode == "simple" {
+			return fmt.Errorf("snapshot_backend %s requires snapshot mode", config.SnapshotBackend)
+		}
+		if config.Archive {
+			return fmt.Errorf("snapshot_backend %s can't be combined with archive", config.SnapshotBackend)
+		}
+		if config.SnapshotBackend == "zfs" && config.ZFSDataset == "" {
+			return fmt.Errorf("snapshot_backend zfs requires zfs_dataset")
+		}
+	default:
+		return fmt.Errorf("invalid snapshot_backend %q: must be \"hardlink\", \"btrfs\" or \"zfs\"", config.SnapshotBackend)
+	}
+	if config.AppendVerify {
+		for _, flag := range strings.Fields(config.RsyncExtraFlags) {
+			if flag == "--inplace" || flag == "--checksum" || flag == "-c" {
+				return fmt.Errorf("append_verify can't be combined with %s in rsync_extra_flags", flag)
+			}
+		}
+	}
+	// rsync before 3.1.3 refuses --sparse with --inplace, which
+	// --append-verify implies.
+	if config.Sparse {
+		if config.AppendVerify {
+			return fmt.Errorf("sparse can't be combined with append_verify")
+		}
+		for _, flag := range strings.Fields(config.RsyncExtraFlags) {
+			if flag == "--inplace" {
+				return fmt.Errorf("sparse can't be combined with %s in rsync_extra_flags", flag)
+			}
+		}
+	}
+	if config.CopyLinks && config.CopyUnsafeLinks {
+		return fmt.Errorf("copy_links and copy_unsafe_links are mutually exclusive")
+	}
+	switch config.Keep.MonthlyAnchor {
+	case "", "first", "last":
+	default:
+		return fmt.Errorf("invalid keep.monthly_anchor %q: must be \"first\" or \"last\"", config.Keep.MonthlyAnchor)
+	}
+	if config.Keep.DailyAnchor != "" {
+		if _, err := time.Parse(windowTimeFormat, config.Keep.DailyAnchor); err != nil {
+			return fmt.Errorf("invalid keep.daily_anchor %q: must be a time such as 02:00", config.Keep.DailyAnchor)
+		}
+	}
+	if config.Verbosity != nil && (*config.Verbosity < 0 || *config.Verbosity > 3) {
+		return fmt.Errorf("verbosity must be between 0 and 3, got %d", *config.Verbosity)
+	}
+	if config.Chmod != "" {
+		for _, item := range strings.Split(config.Chmod, ",") {
+			if !chmodItem.MatchString(item) {
+				return fmt.Errorf("invalid chmod spec %q: bad item %q", config.Chmod, item)
+			}
+		}
+	}
+	if config.Nice < -20 || config.Nice > 19 {
+		return fmt.Errorf("nice must be between -20 and 19, got %d", config.Nice)
+	}
+	if config.Ionice != "" {
+		if _, _, err := parseIonice(config.Ionice); err != nil {
+			return err
+		}
+	}
+	if config.Umask != "" {
+		if _, err := parseUmask(config.Umask); err != nil {
+			return err
+		}
+	}
+	if config.ExcludeURL != "" && !strings.HasPrefix(config.ExcludeURL, "http://") && !strings.HasPrefix(config.ExcludeURL, "https://") {
+		return fmt.Errorf("invalid exclude_url %q: must be an http or https URL", config.ExcludeURL)
+	}
+	if config.SnapshotName != "" {
+		if err := checkSnapshotNameTemplate(config.SnapshotName); err != nil {
+			return err
+		}
+	}
+	if config.LockWait != "" {
+		if d, err := time.ParseDuration(config.LockWait); err != nil || d < 0 {
+			return fmt.Errorf("invalid lock_wait %q: must be a duration such as 5m", config.LockWait)
+		}
+	}
+	for _, marker := range config.ExcludeIfPresent {
+		if marker == "" || marker == "." || marker == ".." || strings.Contains(marker, "/") {
+			return fmt.Errorf("invalid exclude_if_present %q: must be a file name", marker)
+		}
+	}
+	if err := config.MaintenanceWindow.validate(); err != nil {
+		return err
+	}
+	switch config.FinalizeMode {
+	case "", "rename":
+	case "copy":
+		if nativeBackend(config) {
+			return fmt.Errorf("finalize_mode copy can't be used with snapshot_backend %s", config.SnapshotBackend)
+		}
+	default:
+		return fmt.Errorf("invalid finalize_mode %q: must be \"rename\" or \"copy\"", config.FinalizeMode)
+	}
+	if config.VerifyAfterRename && (config.Mode == "simple" || config.Archive || nativeBackend(config)) {
+		return fmt.Errorf("verify_after_rename requires snapshot mode with the hardlink backend and no archive")
+	}
+	if config.SkipIfUnchanged {
+		if config.Mode == "simple" || config.Archive || nativeBackend(config) {
+			return fmt.Errorf("skip_if_unchanged requires snapshot mode with the hardlink backend and no archive")
+		}
+		// The output of a command can't be checked without running it.
+		if len(config.Commands) > 0 {
+			return fmt.Errorf("skip_if_unchanged can't be combined with command sources")
+		}
+	}
+	if config.MaxPurgePercent < 0 || config.MaxPurgePercent > 100 {
+		return fmt.Errorf("max_purge_percent must be between 0 and 100, got %g", config.MaxPurgePercent)
+	}
+	if config.CompactAfter != "" {
+		if d, err := time.ParseDuration(config.CompactAfter); err != nil || d <= 0 {
+			return fmt.Errorf("invalid compact_after %q: must be a positive duration such as 2160h", config.CompactAfter)
+		}
+		if nativeBackend(config) {
+			return fmt.Errorf("compact_after can't be used with snapshot_backend %s", config.SnapshotBackend)
+		}
+	}
+	if config.GCAge != "" {
+		if d, err := time.ParseDuration(config.GCAge); err != nil || d <= 0 {
+			return fmt.Errorf("invalid gc_age %q: must be a positive duration such as 24h", config.GCAge)
+		}
+	}
+	if config.WatchDebounce != "" {
+		if d, err := time.ParseDuration(config.WatchDebounce); err != nil || d <= 0 {
+			return fmt.Errorf("invalid watch_debounce %q: must be a positive duration such as 30s", config.WatchDebounce)
+		}
+	}
+	if config.PurgeGracePeriod != "" {
+		if d, err := time.ParseDuration(config.PurgeGracePeriod); err != nil || d <= 0 {
+			return fmt.Errorf("invalid purge_grace_period %q: must be a positive duration such as 48h", config.PurgeGracePeriod)
+		}
+	}
+	if config.MinFileAge != "" {
+		if d, err := time.ParseDuration(config.MinFileAge); err != nil || d <= 0 {
+			return fmt.Errorf("invalid min_file_age %q: must be a positive duration such as 10m", config.MinFileAge)
+		}
+	}
+	if config.ModifyWindow < 0 {
+		return fmt.Errorf("modify_window must not be negative, got %d", config.ModifyWindow)
+	}
+	if config.BlockSize < 0 || config.BlockSize > maxRsyncBlockSize {
+		return fmt.Errorf("block_size must be at most %d bytes, or 0 to leave it unset, got %d", maxRsyncBlockSize, config.BlockSize)
+	}
+	if config.ChecksumSeed < 0 || config.ChecksumSeed > math.MaxInt32 {
+		return fmt.Errorf("checksum_seed must be at most %d, or 0 to leave it unset, got %d", math.MaxInt32, config.ChecksumSeed)
+	}
+	if config.Fuzzy < 0 || config.Fuzzy > 2 {
+		return fmt.Errorf("fuzzy must be 0, 1 or 2, got %d", config.Fuzzy)
+	}
+	if config.MaxDelete < 0 {
+		return fmt.Errorf("max_delete must not be negative, got %d", config.MaxDelete)
+	}
+	// The mirror holds every source's files side by side, so leaving a
+	// missing source out would have rsync --delete remove its files.
+	if config.AllowMissingSource && config.Mode == "simple" {
+		return fmt.Errorf("allow_missing_source can't be used in simple mode")
+	}
+	// A hardlink snapshot is copied into a new directory, so rsync has
+	// nothing to delete and --max-delete could never stop it.
+	if config.MaxDelete > 0 && config.Mode != "simple" && (config.SnapshotBackend == "" || config.SnapshotBackend == "hardlink") {
+		return fmt.Errorf("max_delete requires simple mode or the btrfs or zfs snapshot_backend")
+	}
+	if config.SlowRunFactor < 0 {
+		return fmt.Errorf("slow_run_factor must not be negative, got %v", config.SlowRunFactor)
+	}
+	if config.DriftThreshold < 0 || config.DriftThreshold > 100 {
+		return fmt.Errorf("drift_threshold must be between 0 and 100, got %v", config.DriftThreshold)
+	}
+	for _, pattern := range config.PurgeExclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid purge_exclude pattern %q: %w", pattern, err)
+		}
+	}
+	for _, tag := range config.ProtectTagged {
+		if !validLabel.MatchString(tag) {
+			return fmt.Errorf("invalid protect_tagged tag %q", tag)
+		}
+	}
+	if config.WarnFileSize != "" {
+		if _, err := parseSize(config.WarnFileSize); err != nil {
+			return fmt.Errorf("invalid warn_file_size: %w", err)
+		}
+	}
+	if config.MaxLogSize != "" {
+		if _, err := parseSize(config.MaxLogSize); err != nil {
+			return fmt.Errorf("invalid max_log_size: %w", err)
+		}
+	}
+	if config.LogTailLines < 0 {
+		return fmt.Errorf("log_tail_lines must not be negative, got %d", config.LogTailLines)
+	}
+	if config.LogTailSize != "" {
+		if size, err := parseSize(config.LogTailSize); err != nil || size <= 0 {
+			return fmt.Errorf("invalid log_tail_size %q: must be a size such as 1M", config.LogTailSize)
+		}
+		if config.LogTailLines > 0 {
+			return fmt.Errorf("log_tail_lines and log_tail_size are mutually exclusive")
+		}
+	}
+	if strings.ContainsAny(config.DirMerge, " \t") {
+		return fmt.Errorf("dir_merge filename %q must not contain spaces", config.DirMerge)
+	}
+	return nil
+}
+
+var execCommand = exec.CommandContext
+
+var timeNow = time.Now
+
+// removeAll deletes snapshots during purge; tests swap it to simulate
+// snapshots that can't be removed.
+var removeAll = os.RemoveAll
+
+// createProbe creates the file checkWritable tries the destination with;
+// tests swap it to simulate a read-only destination, which permissions
+// can't do for root.
+var createProbe = os.CreateTemp
+
+// runOptions are the settings of a single run given on the command line.
+// Backups run by -watch don't get them.
+type runOptions struct {
+	Name   string // -name
+	Resume bool   // -resume
+}
+
+func runSnapshotBackup(ctx context.Context, config *Config, opts runOptions, dryRun bool) error {
+	if err := selectSources(config); err != nil {
+		return err
+	}
+	if nativeBackend(config) {
+		return runNativeSnapshotBackup(ctx, config, dryRun)
+	}
 
-func runSnapshotBackup(config *Config, dryRun bool) error {
 	log.Info().Strs("source", config.Source).Str("destination", config.Destination).Msg("Snapshot Backup")
 
+	var signature string
+	if config.SkipIfUnchanged && opts.Name == "" {
+		var err error
+		if signature, err = sourceSignature(ctx, config); err != nil {
+			// Without a signature the backup can't be skipped, but it can run.
+			log.Warn().Err(err).Msg("Could not check the sources for changes")
+		} else if prev, err := latestSignature(ctx, config); err != nil {
+			return err
+		} else if prev == signature {
+			log.Info().Msg("Sources unchanged since the latest snapshot, skipping backup")
+			return errSourcesUnchanged
+		}
+	}
+
 	unfinishedDir := filepath.Join(config.Destination, ".unfinished")
-	snapshotName := fmt.Sprintf("%s_%s", config.SnapshotPrefix, time.Now().Format("2006-01-02_15:04:05"))
-	finalDest := filepath.Join(config.Destination, snapshotName)
 
-	if !dryRun {
+	if err := checkFreeInodes(config); err != nil {
+		return err
+	}
+
+	resuming := false
+	if opts.Resume && !dryRun {
+		var err error
+		if resuming, err = canResume(config, unfinishedDir); err != nil {
+			return err
+		}
+	}
+
+	if resuming {
+		log.Info().Str("path", unfinishedDir).Msg("Resuming unfinished backup")
+	} else if !dryRun {
 		log.Info().Str("path", unfinishedDir).Msg("Removing temporary directory if it exists")
 		if err := os.RemoveAll(unfinishedDir); err != nil {
 			return fmt.Errorf("failed to remove unfinished directory: %w", err)
@@ -96,48 +951,173 @@ func runSnapshotBackup(config *Config, dryRun bool) error {
 		if err := os.MkdirAll(unfinishedDir, 0755); err != nil {
 			return fmt.Errorf("failed to create unfinished directory: %w", err)
 		}
-	} else {
-		log.Info().Str("path", unfinishedDir).Msg("[Dry Run] Would remove temporary directory if it exists")
-		log.Info().Str("path", unfinishedDir).Msg("[Dry Run] Would create temporary directory")
+		if err := recordUnfinishedSources(config); err != nil {
+			return err
+		}
+	} else {
+		log.Info().Str("path", unfinishedDir).Msg("[Dry Run] Would remove temporary directory if it exists")
+		log.Info().Str("path", unfinishedDir).Msg("[Dry Run] Would create temporary directory")
+	}
+
+	layout := snapshotTimeFormat
+	if !colonsSupported(config.Destination) {
+		log.Info().Str("destination", config.Destination).Msg("Destination can't store colons in names, using dashes in the snapshot timestamp")
+		layout = snapshotTimeFormatNoColons
+	}
+	name, err := expandSnapshotName(config, timeNow(), layout)
+	if err != nil {
+		return err
+	}
+	snapshotName := uniqueSnapshotName(config, name)
+	if opts.Name != "" {
+		if err := checkSnapshotName(config, opts.Name); err != nil {
+			return err
+		}
+		snapshotName = opts.Name
+	}
+	finalDest := filepath.Join(config.Destination, snapshotName)
+
+	linkDest, err := resolveLinkDest(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	events.BackupStarted(BackupStartedEvent{Mode: "snapshot", Destination: config.Destination, Snapshot: snapshotName, RunID: runID})
+	stats, err := runRsync(ctx, config, unfinishedDir, linkDest, snapshotName, dryRun)
+	if err != nil {
+		return err
+	}
+	if config.ItemizeChanges {
+		log.Info().Int("changed_files", stats.ChangedFiles).Msg("Itemized changes recorded")
+	}
+	events.RsyncFinished(newRsyncFinishedEvent(snapshotName, stats))
+
+	if err := runCommandSources(ctx, config, unfinishedDir, dryRun); err != nil {
+		return err
+	}
+
+	if config.Deduplicate && linkDest != "" {
+		if !dryRun {
+			log.Info().Str("path", unfinishedDir).Str("previous", linkDest).Msg("Deduplicating against the previous snapshot")
+			linked, saved, err := deduplicateSnapshot(ctx, config, unfinishedDir, linkDest)
+			if err != nil {
+				return err
+			}
+			log.Info().Int("files", linked).Str("saved", humanizeBytes(saved)).Msg("Deduplicated snapshot")
+		} else {
+			log.Info().Str("path", unfinishedDir).Str("previous", linkDest).Msg("[Dry Run] Would deduplicate against the previous snapshot")
+		}
+	}
+
+	if config.GenerateChecksums {
+		if !dryRun {
+			log.Info().Str("path", unfinishedDir).Msg("Generating checksums")
+			if err := writeChecksums(config, snapshotName, unfinishedDir); err != nil {
+				return err
+			}
+		} else {
+			log.Info().Str("path", unfinishedDir).Msg("[Dry Run] Would generate checksums")
+		}
 	}
 
-	latestSnapshot, err := getLatestSnapshot(config.Destination)
-	if err != nil {
-		return fmt.Errorf("failed to get latest snapshot: %w", err)
+	if signature != "" && !dryRun {
+		if err := writeSignature(unfinishedDir, signature); err != nil {
+			return err
+		}
 	}
 
-	linkDest := ""
-	if latestSnapshot != "" {
-		linkDest = filepath.Join(config.Destination, latestSnapshot)
+	if opts.Name != "" && !dryRun {
+		if err := writeNamedSnapshotMarker(unfinishedDir, config.SnapshotPrefix); err != nil {
+			return err
+		}
 	}
 
-	if err := runRsync(config, unfinishedDir, linkDest, dryRun); err != nil {
+	if config.Archive {
+		finalDest += archiveSuffix(config)
+		if !dryRun {
+			log.Info().Str("from", unfinishedDir).Str("to", finalDest).Msg("Archiving temporary directory")
+			if err := createArchive(unfinishedDir, finalDest, archiveCompressor(config)); err != nil {
+				return err
+			}
+			if err := os.RemoveAll(unfinishedDir); err != nil {
+				return fmt.Errorf("failed to remove unfinished directory: %w", err)
+			}
+		} else {
+			log.Info().Str("from", unfinishedDir).Str("to", finalDest).Msg("[Dry Run] Would archive")
+		}
+	} else if err := finalizeSnapshot(ctx, config, unfinishedDir, finalDest, dryRun); err != nil {
 		return err
 	}
-
 	if !dryRun {
-		log.Info().Str("from", unfinishedDir).Str("to", finalDest).Msg("Renaming temporary directory")
-		if err := os.Rename(unfinishedDir, finalDest); err != nil {
-			return fmt.Errorf("failed to rename unfinished directory: %w", err)
+		os.Remove(unfinishedSourcesPath(config)) //nolint:errcheck
+	}
+	if config.VerifyAfterRename {
+		if !dryRun {
+			if err := verifyAgainstSource(ctx, config, finalDest); err != nil {
+				return err
+			}
+		} else {
+			log.Info().Str("path", finalDest).Msg("[Dry Run] Would verify snapshot against the source")
 		}
-	} else {
-		log.Info().Str("from", unfinishedDir).Str("to", finalDest).Msg("[Dry Run] Would rename")
+	}
+	// The snapshot is complete; a stale link is no reason to fail the run.
+	if err := updateLatestLink(config, filepath.Base(finalDest), dryRun); err != nil {
+		log.Warn().Err(err).Msg("Could not update latest symlink")
 	}
 
 	log.Info().Msg("Snapshot backup finished successfully")
 	return nil
 }
 
-func runSimpleBackup(config *Config, dryRun bool) error {
+// resolveLinkDest returns the snapshot a new snapshot should hard link
+// unchanged files against, or "" if there is none.
+func resolveLinkDest(ctx context.Context, config *Config) (string, error) {
+	latestSnapshot, err := getLatestSnapshot(ctx, config)
+	if err != nil {
+		return "", fmt.Errorf("failed to get latest snapshot: %w", err)
+	}
+	// There is nothing to hard link against when snapshots are archives.
+	if _, isArchive := trimArchiveSuffix(latestSnapshot); latestSnapshot == "" || isArchive || config.Archive {
+		return "", nil
+	}
+	return filepath.Join(snapshotsDir(config), latestSnapshot), nil
+}
+
+func runSimpleBackup(ctx context.Context, config *Config, dryRun bool) error {
 	log.Info().Strs("source", config.Source).Str("destination", config.Destination).Msg("Simple Backup")
 
-	if !dryRun {
+	if err := selectSources(config); err != nil {
+		return err
+	}
+
+	if !isRsyncDaemon(config.Destination) {
+		if err := checkFreeInodes(config); err != nil {
+			return err
+		}
+	}
+
+	// rsync creates the directory itself on an rsync daemon.
+	if !dryRun && !isRsyncDaemon(config.Destination) {
 		if err := os.MkdirAll(config.Destination, 0755); err != nil {
 			return fmt.Errorf("failed to create destination directory: %w", err)
 		}
 	}
 
-	if err := runRsync(config, config.Destination, "", dryRun); err != nil {
+	runName, err := expandSnapshotName(config, timeNow(), snapshotTimeFormat)
+	if err != nil {
+		return err
+	}
+	events.BackupStarted(BackupStartedEvent{Mode: "simple", Destination: config.Destination, RunID: runID})
+	stats, err := runRsync(ctx, config, config.Destination, "", runName, dryRun)
+	if err != nil {
+		return err
+	}
+	if config.ItemizeChanges {
+		log.Info().Int("changed_files", stats.ChangedFiles).Msg("Itemized changes recorded")
+	}
+	events.RsyncFinished(newRsyncFinishedEvent("", stats))
+
+	if err := runCommandSources(ctx, config, config.Destination, dryRun); err != nil {
 		return err
 	}
 
@@ -145,14 +1125,103 @@ func runSimpleBackup(config *Config, dryRun bool) error {
 	return nil
 }
 
-func runRsync(config *Config, destDir string, linkDest string, dryRun bool) error {
-	args := []string{"-a", "-v", "-h", "--delete", "--stats", "--inplace", "--copy-links"}
+// rsyncStats holds the figures gathered from a single rsync run.
+type rsyncStats struct {
+	Duration         time.Duration
+	FilesTransferred int64
+	TotalBytes       int64
+	TransferredBytes int64
+	ChangedFiles     int
+	Added            int // files, not directories, from --itemize-changes
+	Modified         int
+	Deleted          int
+}
+
+func buildRsyncArgs(config *Config, destDir string, linkDest string, dryRun bool) []string {
+	args := []string{"-a"}
+	// --stats output, which we parse, is printed at every verbosity level.
+	verbosity := 1
+	if config.Verbosity != nil {
+		verbosity = *config.Verbosity
+	}
+	for i := 0; i < verbosity; i++ {
+		args = append(args, "-v")
+	}
+	args = append(args, "-h", "--delete", "--stats")
+	if config.Progress && !dryRun {
+		args = append(args, "--info=progress2")
+	}
+	// --append-verify implies --inplace; the two are kept apart so that
+	// validateConfig can reject an explicit --inplace in the extra flags.
+	if config.AppendVerify {
+		args = append(args, "--append-verify")
+	} else if config.Sparse {
+		// --sparse takes the place of --inplace; see validateConfig.
+		args = append(args, "--sparse")
+	} else {
+		args = append(args, "--inplace")
+	}
+	if config.CopyLinks {
+		args = append(args, "--copy-links")
+	}
+	if config.CopyUnsafeLinks {
+		args = append(args, "--copy-unsafe-links")
+	}
+	// -a includes -D; --no-D must come after it to turn it back off.
+	if config.SkipSpecialFiles {
+		args = append(args, "--no-D")
+	}
+	// A dry run is itemized so the changes it would make can be summarised.
+	if config.ItemizeChanges || dryRun {
+		args = append(args, "--itemize-changes")
+	}
 	if linkDest != "" {
 		args = append(args, "--link-dest="+linkDest)
 	}
+	for i := 0; i < config.Fuzzy; i++ {
+		args = append(args, "--fuzzy")
+	}
+	if config.ModifyWindow > 0 {
+		args = append(args, fmt.Sprintf("--modify-window=%d", config.ModifyWindow))
+	}
+	if config.BlockSize > 0 {
+		args = append(args, fmt.Sprintf("--block-size=%d", config.BlockSize))
+	}
+	if config.ChecksumSeed > 0 {
+		args = append(args, fmt.Sprintf("--checksum-seed=%d", config.ChecksumSeed))
+	}
+	args = append(args, protectArgs(config)...)
+	// Per-directory filter files must come before the global excludes so
+	// that their rules take precedence.
+	if config.DirMerge != "" {
+		args = append(args, "--filter=dir-merge /"+strings.TrimPrefix(config.DirMerge, "/"))
+	}
 	for _, ex := range config.Exclude {
 		args = append(args, "--exclude="+ex)
 	}
+	if config.GitignoreExclude {
+		args = append(args, gitignoreFilter)
+	}
+	if config.ExcludeCacheDirs {
+		args = append(args, cacheDirArgs(config.Source)...)
+	}
+	if len(config.ExcludeIfPresent) > 0 {
+		args = append(args, excludeIfPresentArgs(config.Source, config.ExcludeIfPresent)...)
+	}
+	if config.GitHistoryOnly {
+		args = append(args, gitRepoArgs(config.Source)...)
+	}
+	// The list itself is written to rsync's stdin by setRecentFilesExcludes.
+	if config.MinFileAge != "" {
+		args = append(args, "--exclude-from=-")
+	}
+	args = append(args, extensionFilterArgs(config.IncludeExtensions)...)
+	if config.Chmod != "" {
+		args = append(args, "--chmod="+config.Chmod)
+	}
+	if config.MaxDelete > 0 {
+		args = append(args, fmt.Sprintf("--max-delete=%d", config.MaxDelete))
+	}
 	if config.RsyncExtraFlags != "" {
 		args = append(args, strings.Split(config.RsyncExtraFlags, " ")...)
 	}
@@ -172,48 +1241,346 @@ func runRsync(config *Config, destDir string, linkDest string, dryRun bool) erro
 
 	args = append(args, config.Source...)
 	args = append(args, destDir)
+	return args
+}
+
+// protectArgs returns the rules that keep rsync --delete from removing the
+// files goback itself keeps in a simple mode mirror: the pid file, which the
+// run holds, and the output of command sources, which isn't in the sources.
+func protectArgs(config *Config) []string {
+	if config.Mode != "simple" {
+		return nil
+	}
+	var args []string
+	if config.PidFile {
+		args = append(args, "--filter=P /"+pidFileName)
+	}
+	for _, c := range config.Commands {
+		args = append(args, "--filter=P /"+filepath.ToSlash(c.Output))
+	}
+	return args
+}
+
+// extensionFilterArgs returns the rsync filter rules that limit a transfer to
+// files with the given extensions: every directory is traversed, matching
+// files are included and everything else is excluded. Directories left empty
+// are pruned.
+func extensionFilterArgs(extensions []string) []string {
+	if len(extensions) == 0 {
+		return nil
+	}
+	args := []string{"--prune-empty-dirs", "--include=*/"}
+	for _, ext := range extensions {
+		ext = strings.TrimPrefix(strings.TrimPrefix(ext, "*"), ".")
+		args = append(args, "--include=*."+ext)
+	}
+	return append(args, "--exclude=*")
+}
+
+// errMaxDeleteExceeded is returned when rsync stops because a run would
+// delete more than max_delete files.
+var errMaxDeleteExceeded = errors.New("rsync stopped: max_delete exceeded")
 
-	cmd := execCommand("rsync", args...)
-	log.Info().Str("command", fmt.Sprintf("rsync %s", strings.Join(args, " "))).Msg("Running command")
+// runRsync copies the sources to destDir. runName names the run's log files
+// when log_dir is set.
+func runRsync(ctx context.Context, config *Config, destDir string, linkDest string, runName string, dryRun bool) (*rsyncStats, error) {
+	if err := checkRsyncFeatures(ctx, config); err != nil {
+		return nil, err
+	}
+	name, args := withPriority(config, "rsync", buildRsyncArgs(config, destDir, linkDest, dryRun))
 
+	cmd := execCommand(ctx, name, args...)
+	setRsyncPassword(cmd, config)
+	setRecentFilesExcludes(cmd, config)
+	log.Info().Str("command", fmt.Sprintf("%s %s", name, strings.Join(args, " "))).Msg("Running command")
+
+	output := &rsyncOutputWriter{out: os.Stdout}
 	if dryRun {
-		cmd.Stdout = os.Stdout
+		output.created = make(map[string]bool)
 		cmd.Stderr = os.Stderr
-	} else {
-		var logWriter io.Writer
-		if config.Mode == "simple" {
-			logWriter = os.Stdout
-		} else {
-			logFile, err := os.Create(filepath.Join(destDir, "rsync.log"))
+		if *dryRunLog != "" {
+			logFile, err := os.Create(*dryRunLog)
 			if err != nil {
-				return fmt.Errorf("failed to create rsync log file: %w", err)
+				return nil, fmt.Errorf("failed to create dry run log file: %w", err)
 			}
 			//nolint:errcheck
 			defer logFile.Close()
-			logWriter = logFile
+			output.out = io.MultiWriter(os.Stdout, logFile)
+			cmd.Stderr = io.MultiWriter(os.Stderr, logFile)
 		}
-
-		errorTee := io.MultiWriter(os.Stderr, logWriter)
-		cmd.Stdout = logWriter
-		cmd.Stderr = errorTee
+	} else {
+		logs, err := openRunLogs(config, destDir, runName)
+		if err != nil {
+			return nil, err
+		}
+		defer logs.Close()
+		output.out = logs.rsync
+		output.changes = logs.changes
+		cmd.Stderr = io.MultiWriter(os.Stderr, logs.rsync)
 	}
+	cmd.Stdout = output
 
-	if err := cmd.Run(); err != nil {
+	start := time.Now()
+	err := cmd.Run()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, fmt.Errorf("rsync interrupted: %w", ctxErr)
+	}
+	if flushErr := output.Flush(); flushErr != nil {
+		return nil, fmt.Errorf("failed to write rsync output: %w", flushErr)
+	}
+	if err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
 			if exitError.ExitCode() == 24 && config.IgnoreVanishedFilesError {
 				log.Warn().Msg("rsync completed with exit code 24, but ignoring due to configuration.")
+			} else if exitError.ExitCode() == 25 && config.MaxDelete > 0 {
+				outcome := "No snapshot was created."
+				if config.Mode == "simple" {
+					outcome = "The mirror may have been partly updated."
+				}
+				log.Error().Int("max_delete", config.MaxDelete).
+					Msg("SAFETY STOP: rsync wanted to delete more files than max_delete allows. Files may have gone missing from the source; check it before the next run. " + outcome)
+				return nil, errMaxDeleteExceeded
 			} else {
-				return fmt.Errorf("rsync command failed: %w", err)
+				return nil, fmt.Errorf("rsync command failed: %w", err)
 			}
 		} else {
-			return fmt.Errorf("rsync command failed: %w", err)
+			return nil, fmt.Errorf("rsync command failed: %w", err)
 		}
 	}
 
-	return nil
+	output.stats.Duration = time.Since(start)
+	if dryRun {
+		log.Info().Int("added", output.stats.Added).Int("modified", output.stats.Modified).Int("deleted", output.stats.Deleted).
+			Msg("[Dry Run] Would change files")
+		if linkDest != "" {
+			if err := printTopLevelDiff(output.out, config, linkDest, output.created); err != nil {
+				log.Warn().Err(err).Msg("Could not compare the top level with the latest snapshot")
+			}
+		}
+	}
+	log.Info().
+		Str("duration", formatDuration(output.stats.Duration)).
+		Int64("files_transferred", output.stats.FilesTransferred).
+		Str("transferred", humanizeBytes(output.stats.TransferredBytes)).
+		Str("total_size", humanizeBytes(output.stats.TotalBytes)).
+		Msg("rsync finished")
+	return &output.stats, nil
+}
+
+// itemizedLine matches the lines rsync prints for --itemize-changes, e.g.
+// ">f+++++++++ some/file" or "*deleting   old/file".
+var itemizedLine = regexp.MustCompile(`^(\*deleting|[<>ch.][fdLDS][.+ ?a-zA-Z]{9}) `)
+
+// rsyncOutputWriter processes rsync's stdout line by line. Itemized change
+// lines are counted and sent to changes when it is set, the --stats block is
+// parsed into stats, and everything is otherwise passed through to out.
+// When created is set, the top level names rsync creates are added to it.
+type rsyncOutputWriter struct {
+	out     io.Writer
+	changes io.Writer
+	created map[string]bool
+	buf     []byte
+	stats   rsyncStats
+}
+
+func (w *rsyncOutputWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		// --info=progress2 ends its updates with a carriage return.
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		if err := w.writeLine(w.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes out any trailing output that was not newline terminated.
+func (w *rsyncOutputWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.writeLine(w.buf)
+	w.buf = nil
+	return err
+}
+
+func (w *rsyncOutputWriter) writeLine(line []byte) error {
+	if ev, ok := parseProgress2(string(line)); ok {
+		events.RsyncProgress(ev)
+	} else if m := itemizedLine.FindSubmatch(line); m != nil {
+		if string(m[1]) == "*deleting" || m[1][1] == 'f' {
+			w.stats.ChangedFiles++
+		}
+		if w.created != nil && string(m[1]) != "*deleting" {
+			path := strings.TrimRight(string(line[len(m[0]):]), "\r\n")
+			if name, ok := topLevelName(path); ok {
+				w.created[name] = true
+			}
+		}
+		switch classifyItemizedLine(string(line)) {
+		case itemAdded:
+			w.stats.Added++
+		case itemModified:
+			w.stats.Modified++
+		case itemDeleted:
+			w.stats.Deleted++
+		}
+		if w.changes != nil {
+			_, err := w.changes.Write(line)
+			return err
+		}
+	} else {
+		w.parseStatsLine(string(line))
+	}
+	_, err := w.out.Write(line)
+	return err
+}
+
+func (w *rsyncOutputWriter) parseStatsLine(line string) {
+	key, value, ok := strings.Cut(strings.TrimSpace(line), ": ")
+	if !ok {
+		return
+	}
+	// Values look like "1,234", "5.67M bytes" or "12 (reg: 10, dir: 2)".
+	value, _, _ = strings.Cut(value, " ")
+	var target *int64
+	switch key {
+	case "Number of regular files transferred":
+		target = &w.stats.FilesTransferred
+	case "Total file size":
+		target = &w.stats.TotalBytes
+	case "Total transferred file size":
+		target = &w.stats.TransferredBytes
+	default:
+		return
+	}
+	if n, err := parseRsyncNumber(value); err == nil {
+		*target = n
+	}
+}
+
+// parseRsyncNumber parses a number as printed by rsync, which depending on
+// the -h level may contain thousands separators or a K/M/G/T suffix.
+func parseRsyncNumber(s string) (int64, error) {
+	s = strings.ReplaceAll(s, ",", "")
+	multiplier := 1.0
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			multiplier = 1e3
+		case 'M':
+			multiplier = 1e6
+		case 'G':
+			multiplier = 1e9
+		case 'T':
+			multiplier = 1e12
+		}
+		if multiplier != 1 {
+			s = s[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(f * multiplier), nil
+}
+
+// snapshotTimeFormat is the timestamp layout used in snapshot names.
+const snapshotTimeFormat = "2006-01-02_15:04:05"
+
+// snapshotTimeFormatNoColons is used instead of snapshotTimeFormat on
+// filesystems, such as FAT or SMB shares, that can't store colons in names.
+const snapshotTimeFormatNoColons = "2006-01-02_15-04-05"
+
+// uniqueSnapshotName returns name, or name with a "_2", "_3", ... suffix if
+// a snapshot by that name already exists in the destination.
+func uniqueSnapshotName(config *Config, name string) string {
+	suffix := ""
+	if config.Archive {
+		suffix = archiveSuffix(config)
+	}
+	candidate := name
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(filepath.Join(snapshotsDir(config), candidate+suffix)); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s_%d", name, n)
+	}
+}
+
+// snapshotSeq returns the sequence number of a snapshot name: 1 for the
+// first snapshot taken in a second, then 2, 3 and so on. The "_<n>" is
+// added at the end of the name snapshot_name produces, before any label.
+func snapshotSeq(name string) int {
+	base, _ := trimArchiveSuffix(name)
+	loc := snapshotTimePattern.FindStringSubmatchIndex(base)
+	if loc == nil {
+		return 1
+	}
+	m := snapshotSeqPattern.FindStringSubmatch(base[loc[3]:])
+	if m == nil {
+		return 1
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+// parseSnapshotTime extracts the timestamp from a snapshot directory name,
+// wherever snapshot_name placed it. It returns false for names that were
+// not created by goback.
+func parseSnapshotTime(name string) (time.Time, bool) {
+	m := snapshotTimePattern.FindStringSubmatch(name)
+	if m == nil {
+		return time.Time{}, false
+	}
+	layout := snapshotTimeFormat
+	if m[2] == "-" {
+		layout = snapshotTimeFormatNoColons
+	}
+	t, err := time.ParseInLocation(layout, m[1], time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// colonsSupported reports whether names containing a colon can be created in
+// dir. If dir doesn't exist yet it is assumed they can.
+func colonsSupported(dir string) bool {
+	probe := filepath.Join(dir, ".goback:probe")
+	f, err := os.Create(probe)
+	if err != nil {
+		if _, statErr := os.Stat(dir); statErr != nil {
+			return true
+		}
+		return false
+	}
+	//nolint:errcheck
+	f.Close()
+	//nolint:errcheck
+	os.Remove(probe)
+	return true
 }
 
-func getSnapshots(dest string) ([]os.FileInfo, error) {
+// entryInfo returns the FileInfo of a directory entry; tests swap it to
+// simulate entries that can't be read.
+var entryInfo = os.DirEntry.Info
+
+// getSnapshots returns the snapshots in dest sorted from oldest to newest.
+// Snapshots are directories, or .tar/.tar.gz files in archive mode and
+// .tar.zst files written by -compact. Entries whose names don't look like a
+// snapshot, other than directories marked as named snapshots, are ignored so
+// that unrelated data in the destination is never purged.
+func getSnapshots(ctx context.Context, dest string) ([]os.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	entries, err := os.ReadDir(dest)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -224,32 +1591,88 @@ func getSnapshots(dest string) ([]os.FileInfo, error) {
 
 	var snapshots []os.FileInfo
 	for _, entry := range entries {
-		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
-			info, err := entry.Info()
-			if err != nil {
-				return nil, err
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		name := entry.Name()
+		if entry.Type().IsRegular() {
+			var isArchive bool
+			if name, isArchive = trimArchiveSuffix(name); !isArchive {
+				continue
 			}
-			snapshots = append(snapshots, info)
+		} else if !entry.IsDir() {
+			continue
+		}
+		if _, ok := parseSnapshotTime(name); !ok && !isNamedSnapshot(dest, entry) {
+			log.Debug().Str("path", filepath.Join(dest, entry.Name())).Msg("Ignoring entry that is not a snapshot")
+			continue
 		}
+		if entry.IsDir() && isIncomplete(filepath.Join(dest, entry.Name())) {
+			log.Warn().Str("path", filepath.Join(dest, entry.Name())).Msg("Ignoring snapshot an interrupted run didn't finish linking; -gc removes it")
+			continue
+		}
+		info, err := entryInfo(entry)
+		if err != nil {
+			// One unreadable or vanished entry shouldn't stop backups and
+			// purges from working with the rest.
+			log.Warn().Err(err).Str("path", filepath.Join(dest, entry.Name())).Msg("Skipping snapshot that can't be read")
+			continue
+		}
+		snapshots = append(snapshots, info)
 	}
 
-	sort.Slice(snapshots, func(i, j int) bool {
-		return snapshots[i].ModTime().Before(snapshots[j].ModTime())
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		if !snapshots[i].ModTime().Equal(snapshots[j].ModTime()) {
+			return snapshots[i].ModTime().Before(snapshots[j].ModTime())
+		}
+		return snapshotSeq(snapshots[i].Name()) < snapshotSeq(snapshots[j].Name())
 	})
 
 	return snapshots, nil
 }
 
-func getLatestSnapshot(dest string) (string, error) {
-	snapshots, err := getSnapshots(dest)
+// configSnapshots returns the snapshots named by config's snapshot_name and
+// snapshot prefix, sorted from oldest to newest. Snapshots with other
+// prefixes or hosts in the same destination belong to other configurations
+// and are left alone.
+func configSnapshots(ctx context.Context, config *Config) ([]os.FileInfo, error) {
+	snapshots, err := getSnapshots(ctx, snapshotsDir(config))
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := configNamePattern(config)
+	if err != nil {
+		return nil, err
+	}
+	var matching []os.FileInfo
+	for _, s := range snapshots {
+		if belongsToConfig(config, pattern, s.Name()) {
+			matching = append(matching, s)
+		}
+	}
+	return matching, nil
+}
+
+func getLatestSnapshot(ctx context.Context, config *Config) (string, error) {
+	snapshots, err := configSnapshots(ctx, config)
 	if err != nil || len(snapshots) == 0 {
 		return "", err
 	}
 	return snapshots[len(snapshots)-1].Name(), nil
 }
 
-func purgeBackups(config *Config, dryRun bool) error {
-	snapshots, err := getSnapshots(config.Destination) // getSnapshots sorts oldest to newest
+// purgeBackups deletes the snapshots the keep policy doesn't claim. A failed
+// deletion doesn't stop the purge; all failures are returned together.
+func purgeBackups(ctx context.Context, config *Config, dryRun bool) error {
+	// Deleting snapshots from a read-only destination would fail one by
+	// one, so find out up front.
+	if !dryRun {
+		if err := checkRemovable(config); err != nil {
+			return fmt.Errorf("not purging: %w", err)
+		}
+	}
+
+	snapshots, err := configSnapshots(ctx, config) // sorted oldest to newest
 	if err != nil {
 		return err
 	}
@@ -264,72 +1687,262 @@ func purgeBackups(config *Config, dryRun bool) error {
 		return nil
 	}
 
-	to_keep := make(map[string]bool)
-
-	// Daily backups
-	daily_kept_count := 0
-	for i := 0; i < len(snapshots) && daily_kept_count < config.Keep.Daily; i++ {
-		s := snapshots[i]
-		if !to_keep[s.Name()] {
-			log.Info().Str("snapshot", s.Name()).Msg("Keeping snapshot as a daily backup.")
-			to_keep[s.Name()] = true
-			daily_kept_count++
+	to_keep := retentionPlan(snapshots, config, config.Keep, timeNow())
+	for _, s := range snapshots {
+		if reason, ok := to_keep[s.Name()]; ok {
+			log.Info().Str("snapshot", s.Name()).Msg(colorize(fmt.Sprintf("Keeping snapshot as a %s backup.", reason.Tier), colorGreen))
+			events.SnapshotKept(SnapshotKeptEvent{Snapshot: s.Name(), Tier: reason.Tier})
 		}
 	}
 
-	// Weekly backups
-	weekly_kept_count := 0
-	weeks_seen := make(map[int]bool)
-	for _, s := range snapshots {
-		if weekly_kept_count >= config.Keep.Weekly {
-			break
-		}
-		year, week := s.ModTime().ISOWeek()
-		week_key := year*100 + week
-		if !weeks_seen[week_key] {
-			weeks_seen[week_key] = true
-			if !to_keep[s.Name()] {
-				log.Info().Str("snapshot", s.Name()).Msg("Keeping snapshot as a weekly backup.")
-				to_keep[s.Name()] = true
-				weekly_kept_count++
+	if *explain {
+		for _, s := range snapshots {
+			reason := "deleted: no tier claimed it"
+			if r, ok := to_keep[s.Name()]; ok {
+				reason = r.String()
 			}
+			log.Info().Str("snapshot", s.Name()).Msg(reason)
 		}
 	}
 
-	// Monthly backups
-	monthly_kept_count := 0
-	months_seen := make(map[int]bool)
-	for _, s := range snapshots {
-		if monthly_kept_count >= config.Keep.Monthly {
-			break
-		}
-		year, month, _ := s.ModTime().Date()
-		month_key := year*100 + int(month)
-		if !months_seen[month_key] {
-			months_seen[month_key] = true
-			if !to_keep[s.Name()] {
-				log.Info().Str("snapshot", s.Name()).Msg("Keeping snapshot as a monthly backup.")
-				to_keep[s.Name()] = true
-				monthly_kept_count++
-			}
-		}
+	if err := checkPurgeRatio(config, len(snapshots), len(snapshots)-len(to_keep), dryRun); err != nil {
+		return err
 	}
 
 	log.Info().Msg("--- Purge Summary ---")
+	var purgeErrs []error
 	for _, s := range snapshots {
-		if !to_keep[s.Name()] {
+		if err := ctx.Err(); err != nil {
+			log.Warn().Msg("Purge interrupted")
+			return errors.Join(append(purgeErrs, err)...)
+		}
+		if _, ok := to_keep[s.Name()]; !ok {
 			if dryRun {
-				log.Info().Str("path", filepath.Join(config.Destination, s.Name())).Msg("[Dry Run] Would purge snapshot directory")
+				log.Info().Str("path", filepath.Join(config.Destination, s.Name())).Msg(colorize("[Dry Run] Would purge snapshot directory", colorRed))
+				events.SnapshotPurged(SnapshotPurgedEvent{Snapshot: s.Name(), DryRun: true})
 			} else {
-				log.Info().Str("snapshot", s.Name()).Msg("Purging snapshot")
-				err := os.RemoveAll(filepath.Join(config.Destination, s.Name()))
+				log.Info().Str("snapshot", s.Name()).Msg(colorize("Purging snapshot", colorRed))
+				err := removeSnapshot(ctx, config, s.Name())
 				if err != nil {
 					log.Error().Err(err).Str("snapshot", s.Name()).Msg("Failed to purge snapshot")
+					purgeErrs = append(purgeErrs, fmt.Errorf("failed to purge snapshot %s: %w", s.Name(), err))
+				} else {
+					events.SnapshotPurged(SnapshotPurgedEvent{Snapshot: s.Name()})
 				}
 			}
 		}
 	}
 	log.Info().Msg("--- End Purge Summary ---")
 
+	return errors.Join(purgeErrs...)
+}
+
+// maxRsyncBlockSize is the largest --block-size rsync accepts since
+// protocol 30 (rsync 3.0).
+const maxRsyncBlockSize = 128 << 10
+
+// defaultMaxPurgePercent is the max_purge_percent used when none is set.
+const defaultMaxPurgePercent = 50
+
+// errPurgeTooMany is returned when a purge would delete more than
+// max_purge_percent of the snapshots.
+var errPurgeTooMany = errors.New("purge would delete too many snapshots")
+
+// checkPurgeRatio guards against a policy or clock bug wiping out most of
+// the history in one go: it fails with errPurgeTooMany if purging would
+// delete more than max_purge_percent of the total snapshots, unless
+// -force-purge is given. A dry run only warns.
+func checkPurgeRatio(config *Config, total, purging int, dryRun bool) error {
+	limit := config.MaxPurgePercent
+	if limit == 0 {
+		limit = defaultMaxPurgePercent
+	}
+	percent := float64(purging) * 100 / float64(total)
+	if percent <= limit || *forcePurge {
+		return nil
+	}
+	event := log.Error()
+	if dryRun {
+		event = log.Warn()
+	}
+	event.Int("purging", purging).Int("snapshots", total).Str("percent", fmt.Sprintf("%.0f%%", percent)).
+		Str("max_purge_percent", fmt.Sprintf("%g%%", limit)).
+		Msg(colorize("SAFETY STOP: purge would delete more snapshots than max_purge_percent allows; check the keep policy and the system clock, then rerun with -force-purge if this is intended", colorRed))
+	if dryRun {
+		return nil
+	}
+	return fmt.Errorf("%w: %d of %d (%.0f%%)", errPurgeTooMany, purging, total, percent)
+}
+
+// retentionPlan returns the snapshots, sorted newest to oldest, that keep,
+// purge_exclude, protect_tagged or purge_grace_period claim at now, and
+// why.
+func retentionPlan(snapshots []os.FileInfo, config *Config, keep Keep, now time.Time) map[string]keepReason {
+	toKeep := snapshotsToKeep(snapshots, keep)
+	// validateConfig has already checked the duration.
+	grace, _ := time.ParseDuration(config.PurgeGracePeriod)
+	for _, s := range snapshots {
+		if _, ok := toKeep[s.Name()]; ok {
+			continue
+		}
+		if by := protectedBy(config, s.Name()); by != "" {
+			toKeep[s.Name()] = keepReason{Tier: "protected", Detail: by}
+		} else if grace > 0 && now.Sub(s.ModTime()) < grace {
+			toKeep[s.Name()] = keepReason{Tier: "grace", Detail: config.PurgeGracePeriod}
+		}
+	}
+	return toKeep
+}
+
+// checkWritable creates and removes a file in dir to make sure dir can be
+// modified.
+func checkWritable(dir string) error {
+	f, err := createProbe(dir, ".goback-probe-")
+	if err != nil {
+		return fmt.Errorf("destination %s is not writable: %w", dir, err)
+	}
+	//nolint:errcheck
+	f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return fmt.Errorf("destination %s is not writable: %w", dir, err)
+	}
 	return nil
 }
+
+// keepReason records which retention tier claimed a snapshot.
+type keepReason struct {
+	Tier   string // "daily", "weekly" or "monthly"
+	Detail string // the daily slot, or the week or month the snapshot represents
+}
+
+func (r keepReason) String() string {
+	switch r.Tier {
+	case "daily":
+		return "kept: daily slot " + r.Detail
+	case "protected":
+		return "kept: protected by " + r.Detail
+	case "grace":
+		return "kept: within purge_grace_period " + r.Detail
+	}
+	return fmt.Sprintf("kept: %s %s", r.Tier, r.Detail)
+}
+
+// protectedBy describes what protects snapshot from purging: the
+// purge_exclude pattern matching it or the protect_tagged tag it carries. It
+// returns "" if the snapshot isn't protected.
+func protectedBy(config *Config, snapshot string) string {
+	name, _ := trimArchiveSuffix(snapshot)
+	for _, pattern := range config.PurgeExclude {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return "purge_exclude " + pattern
+		}
+	}
+	if tag := protectingTag(config, snapshot); tag != "" {
+		return "protect_tagged " + tag
+	}
+	return ""
+}
+
+// snapshotsToKeep applies the keep policy to snapshots, which must be sorted
+// newest to oldest. It returns the reason for every snapshot that is kept;
+// snapshots missing from the map are to be purged.
+func snapshotsToKeep(snapshots []os.FileInfo, keep Keep) map[string]keepReason {
+	toKeep := make(map[string]keepReason)
+
+	// Daily backups
+	if anchor, err := time.Parse(windowTimeFormat, keep.DailyAnchor); err == nil {
+		keepOnePerPeriod(snapshots, keep.Daily, nearestTimeOfDay(anchor), "daily", toKeep, func(t time.Time) string {
+			return t.Format("2006-01-02")
+		})
+		// The newest snapshot is the latest and the next run's link-dest,
+		// whatever the time of day it was taken at.
+		if keep.Daily > 0 && len(snapshots) > 0 {
+			if _, ok := toKeep[snapshots[0].Name()]; !ok {
+				toKeep[snapshots[0].Name()] = keepReason{Tier: "daily", Detail: "newest"}
+			}
+		}
+	} else {
+		for i := 0; i < len(snapshots) && i < keep.Daily; i++ {
+			toKeep[snapshots[i].Name()] = keepReason{Tier: "daily", Detail: strconv.Itoa(i + 1)}
+		}
+	}
+
+	// Weekly backups
+	keepOnePerPeriod(snapshots, keep.Weekly, anchorPick(keep.MonthlyAnchor), "weekly", toKeep, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+
+	// Monthly backups
+	keepOnePerPeriod(snapshots, keep.Monthly, anchorPick(keep.MonthlyAnchor), "monthly", toKeep, func(t time.Time) string {
+		return t.Format("2006-01")
+	})
+
+	return toKeep
+}
+
+// keepOnePerPeriod marks one snapshot from each of the count most recent
+// periods (as identified by periodKey) to be kept. snapshots must be sorted
+// newest to oldest. pick selects the snapshot retained from the snapshots
+// of a period, which are in the same order. A period whose chosen snapshot
+// is already kept by another tier doesn't count towards count.
+func keepOnePerPeriod(snapshots []os.FileInfo, count int, pick func([]os.FileInfo) os.FileInfo, tier string, toKeep map[string]keepReason, periodKey func(time.Time) string) {
+	var periods []string
+	members := make(map[string][]os.FileInfo)
+	for _, s := range snapshots {
+		key := periodKey(s.ModTime())
+		if _, ok := members[key]; !ok {
+			periods = append(periods, key)
+		}
+		members[key] = append(members[key], s)
+	}
+
+	kept := 0
+	for _, key := range periods {
+		if kept >= count {
+			break
+		}
+		s := pick(members[key])
+		if _, ok := toKeep[s.Name()]; !ok {
+			toKeep[s.Name()] = keepReason{Tier: tier, Detail: key}
+			kept++
+		}
+	}
+}
+
+// anchorPick returns the pick for keepOnePerPeriod that monthly_anchor
+// selects: the newest snapshot ("last", the default) or the oldest
+// ("first").
+func anchorPick(anchor string) func([]os.FileInfo) os.FileInfo {
+	return func(candidates []os.FileInfo) os.FileInfo {
+		if anchor == "first" {
+			return candidates[len(candidates)-1]
+		}
+		return candidates[0]
+	}
+}
+
+// nearestTimeOfDay returns a pick for keepOnePerPeriod that selects the
+// snapshot taken closest to the time of day of anchor, counting across
+// midnight, so that 23:50 is 10 minutes from 00:00. Of two equally close
+// snapshots the newer is kept.
+func nearestTimeOfDay(anchor time.Time) func([]os.FileInfo) os.FileInfo {
+	const day = 24 * time.Hour
+	target := time.Duration(anchor.Hour())*time.Hour + time.Duration(anchor.Minute())*time.Minute
+	distance := func(t time.Time) time.Duration {
+		d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second - target
+		if d < 0 {
+			d = -d
+		}
+		return min(d, day-d)
+	}
+	return func(candidates []os.FileInfo) os.FileInfo {
+		best := candidates[0]
+		for _, s := range candidates[1:] {
+			if distance(s.ModTime()) < distance(best.ModTime()) {
+				best = s
+			}
+		}
+		return best
+	}
+}
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
 func TestPurgeBackups(t *testing.T) {
@@ -18,7 +27,8 @@ func TestPurgeBackups(t *testing.T) {
 	defer os.RemoveAll(tmpDir)
 
 	config := &Config{
-		Destination: tmpDir,
+		Destination:    tmpDir,
+		SnapshotPrefix: "snapshot",
 		Keep: Keep{
 			Daily:   2,
 			Weekly:  2,
@@ -27,8 +37,10 @@ func TestPurgeBackups(t *testing.T) {
 	}
 
 	// Create snapshots with specific dates
-	// 2 daily, 2 weekly (from different weeks), 1 monthly, and some to be purged
-	now := time.Now()
+	// 2 daily, 2 weekly (from different weeks), 1 monthly, and some to be purged.
+	// Anchor to a fixed date so the ages fall into the same weeks and months
+	// regardless of when the test runs.
+	now := time.Date(2025, time.June, 28, 12, 0, 0, 0, time.Local)
 	ages := []int{
 		1, 2, // Daily
 		8, 9, // Week 2
@@ -36,30 +48,32 @@ func TestPurgeBackups(t *testing.T) {
 		45, 46, // Month 2
 		75, 76, // Month 3
 	}
+	names := make(map[int]string)
 	for _, age := range ages {
-		name := fmt.Sprintf("snapshot-%d", age)
+		modTime := now.AddDate(0, 0, -age)
+		name := "snapshot_" + modTime.Format(snapshotTimeFormat)
+		names[age] = name
 		path := filepath.Join(tmpDir, name)
 		if err := os.Mkdir(path, 0755); err != nil {
 			t.Fatalf("Failed to create dir: %v", err)
 		}
-		modTime := now.AddDate(0, 0, -age)
 		if err := os.Chtimes(path, modTime, modTime); err != nil {
 			t.Fatalf("Failed to set mod time: %v", err)
 		}
 	}
 
 	// Execute
-	if err := purgeBackups(config, false); err != nil {
+	if err := purgeBackups(context.Background(), config, false); err != nil {
 		t.Fatalf("purgeBackups failed: %v", err)
 	}
 
 	// Verify
 	expected_to_keep := map[string]bool{
-		"snapshot-1":  true, // daily
-		"snapshot-2":  true, // daily
-		"snapshot-8":  true, // weekly
-		"snapshot-15": true, // weekly
-		"snapshot-45": true, // monthly
+		names[1]:  true, // daily
+		names[2]:  true, // daily
+		names[8]:  true, // weekly
+		names[15]: true, // weekly
+		names[45]: true, // monthly
 	}
 
 	files, err := os.ReadDir(tmpDir)
@@ -88,231 +102,1458 @@ func TestPurgeBackups(t *testing.T) {
 	}
 }
 
-func TestReadConfig(t *testing.T) {
-	// Setup
-	configFileContent := `
-destination: /tmp/backup
-snapshot_prefix: test
-source:
-  - /tmp/source1
-exclude:
-  - /tmp/source1/excluded
-keep:
-  daily: 1
-  weekly: 1
-  monthly: 1
-rsync_extra_flags: "--verbose"
-ignore_vanished_files_error: true
-`
-	tmpFile, err := os.CreateTemp("", "config-*.yaml")
+func TestPurgeBackupsGracePeriod(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
 	if err != nil {
-		t.Fatalf("Failed to create temp file: %v", err)
+		t.Fatalf("Failed to create temp dir: %v", err)
 	}
-	defer os.Remove(tmpFile.Name())
+	defer os.RemoveAll(tmpDir)
 
-	if _, err := tmpFile.WriteString(configFileContent); err != nil {
-		t.Fatalf("Failed to write to temp file: %v", err)
+	now := time.Now()
+	var names []string
+	for _, age := range []time.Duration{72 * time.Hour, 30 * time.Hour, 2 * time.Hour} {
+		modTime := now.Add(-age)
+		name := "test_" + modTime.Format(snapshotTimeFormat)
+		path := filepath.Join(tmpDir, name)
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+		names = append(names, name)
 	}
-	tmpFile.Close()
 
-	// Execute
-	config, err := readConfig(tmpFile.Name())
+	// Only the latest snapshot is claimed by the policy; the one before it
+	// is young enough to be spared.
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Keep: Keep{Daily: 1}, PurgeGracePeriod: "48h"}
+	if err := validateConfig(config); err != nil {
+		t.Fatalf("validateConfig failed: %v", err)
+	}
+	if err := purgeBackups(context.Background(), config, false); err != nil {
+		t.Fatalf("purgeBackups failed: %v", err)
+	}
+	for i, name := range names {
+		_, err := os.Stat(filepath.Join(tmpDir, name))
+		if i == 0 && !os.IsNotExist(err) {
+			t.Errorf("Expected %s, older than the grace period, to be purged", name)
+		} else if i > 0 && err != nil {
+			t.Errorf("Expected %s to be kept, got %v", name, err)
+		}
+	}
+
+	if err := validateConfig(&Config{PurgeGracePeriod: "2 days"}); err == nil {
+		t.Error("Expected an invalid purge_grace_period to be rejected")
+	}
+}
+
+func TestPurgeBackupsSafetyStop(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
 	if err != nil {
-		t.Fatalf("readConfig failed: %v", err)
+		t.Fatalf("Failed to create temp dir: %v", err)
 	}
+	defer os.RemoveAll(tmpDir)
 
-	// Verify
-	if config.Destination != "/tmp/backup" {
-		t.Errorf("Expected destination '/tmp/backup', got '%s'", config.Destination)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		modTime := now.AddDate(0, 0, -i)
+		path := filepath.Join(tmpDir, "test_"+modTime.Format(snapshotTimeFormat))
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
 	}
-	if config.Mode != "" && config.Mode != "snapshot" {
-		t.Errorf("Expected mode 'snapshot' or empty, got '%s'", config.Mode)
+	count := func() int {
+		entries, err := os.ReadDir(tmpDir)
+		if err != nil {
+			t.Fatalf("Failed to read dir: %v", err)
+		}
+		return len(entries)
 	}
-	if config.SnapshotPrefix != "test" {
-		t.Errorf("Expected snapshot_prefix 'test', got '%s'", config.SnapshotPrefix)
+
+	// Keeping 2 of 10 would delete 80%, more than the default 50%.
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Keep: Keep{Daily: 2}}
+	if err := purgeBackups(context.Background(), config, true); err != nil {
+		t.Errorf("Expected a dry run only to warn, got %v", err)
 	}
-	if len(config.Source) != 1 || config.Source[0] != "/tmp/source1" {
-		t.Errorf("Expected source ['/tmp/source1'], got '%v'", config.Source)
+	if err := purgeBackups(context.Background(), config, false); !errors.Is(err, errPurgeTooMany) {
+		t.Fatalf("Expected errPurgeTooMany, got %v", err)
 	}
-	if config.Keep.Daily != 1 {
-		t.Errorf("Expected keep.daily 1, got %d", config.Keep.Daily)
+	if n := count(); n != 10 {
+		t.Errorf("Expected nothing to be purged, %d snapshots left", n)
 	}
-	if config.IgnoreVanishedFilesError != true {
-		t.Errorf("Expected ignore_vanished_files_error true, got %v", config.IgnoreVanishedFilesError)
+
+	// A higher limit lets it through.
+	config.MaxPurgePercent = 90
+	if err := checkPurgeRatio(config, 10, 8, false); err != nil {
+		t.Errorf("Expected 80%% to be within a 90%% limit, got %v", err)
 	}
-}
+	config.MaxPurgePercent = 0
 
-func TestReadConfig_SimpleMode(t *testing.T) {
-	// Setup
-	configFileContent := `
-mode: simple
-destination: /tmp/backup
-source:
-  - /tmp/source1
-`
-	tmpFile, err := os.CreateTemp("", "config-*.yaml")
-	if err != nil {
-		t.Fatalf("Failed to create temp file: %v", err)
+	*forcePurge = true
+	defer func() { *forcePurge = false }()
+	if err := purgeBackups(context.Background(), config, false); err != nil {
+		t.Fatalf("purgeBackups with -force-purge failed: %v", err)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("Expected 2 snapshots left after a forced purge, got %d", n)
 	}
-	defer os.Remove(tmpFile.Name())
 
-	if _, err := tmpFile.WriteString(configFileContent); err != nil {
-		t.Fatalf("Failed to write to temp file: %v", err)
+	if err := validateConfig(&Config{MaxPurgePercent: 150}); err == nil {
+		t.Error("Expected a max_purge_percent above 100 to be rejected")
 	}
-	tmpFile.Close()
+}
 
-	// Execute
-	config, err := readConfig(tmpFile.Name())
-	if err != nil {
-		t.Fatalf("readConfig failed: %v", err)
+func TestPurgeBackupsMonthlyAnchor(t *testing.T) {
+	// Three snapshots in one month and one in the month before.
+	dates := []time.Time{
+		time.Date(2025, time.May, 20, 2, 0, 0, 0, time.Local),
+		time.Date(2025, time.June, 3, 2, 0, 0, 0, time.Local),
+		time.Date(2025, time.June, 14, 2, 0, 0, 0, time.Local),
+		time.Date(2025, time.June, 25, 2, 0, 0, 0, time.Local),
 	}
 
-	// Verify
-	if config.Mode != "simple" {
-		t.Errorf("Expected mode 'simple', got '%s'", config.Mode)
+	for _, tc := range []struct {
+		anchor string
+		want   []int // indexes into dates
+	}{
+		{anchor: "", want: []int{0, 3}},
+		{anchor: "last", want: []int{0, 3}},
+		{anchor: "first", want: []int{0, 1}},
+	} {
+		tmpDir, err := os.MkdirTemp("", "goback-test")
+		if err != nil {
+			t.Fatalf("Failed to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		var names []string
+		for _, d := range dates {
+			name := "test_" + d.Format(snapshotTimeFormat)
+			names = append(names, name)
+			path := filepath.Join(tmpDir, name)
+			if err := os.Mkdir(path, 0755); err != nil {
+				t.Fatalf("Failed to create dir: %v", err)
+			}
+			if err := os.Chtimes(path, d, d); err != nil {
+				t.Fatalf("Failed to set mod time: %v", err)
+			}
+		}
+
+		config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Keep: Keep{Monthly: 2, MonthlyAnchor: tc.anchor}}
+		if err := purgeBackups(context.Background(), config, false); err != nil {
+			t.Fatalf("purgeBackups failed: %v", err)
+		}
+
+		remaining, err := getSnapshots(context.Background(), tmpDir)
+		if err != nil {
+			t.Fatalf("getSnapshots failed: %v", err)
+		}
+		var got []string
+		for _, info := range remaining {
+			got = append(got, info.Name())
+		}
+		var want []string
+		for _, i := range tc.want {
+			want = append(want, names[i])
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("anchor %q: expected to keep %v, got %v", tc.anchor, want, got)
+		}
 	}
-	if config.Destination != "/tmp/backup" {
-		t.Errorf("Expected destination '/tmp/backup', got '%s'", config.Destination)
+
+	if err := validateConfig(&Config{Keep: Keep{MonthlyAnchor: "middle"}}); err == nil {
+		t.Error("Expected an error for an invalid monthly_anchor")
 	}
 }
 
-func TestReadConfig_NotFound(t *testing.T) {
-	_, err := readConfig("non-existent-file.yaml")
-	if err == nil {
-		t.Errorf("Expected an error when reading a non-existent file, but got nil")
+func TestSnapshotsToKeepDailyAnchor(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2025, time.June, day, hour, minute, 0, 0, time.Local)
+	}
+	// Nightly backups around 02:00 with ad-hoc ones in between, newest first.
+	times := []time.Time{
+		at(4, 12, 0), // the only one that day
+		at(3, 23, 0),
+		at(3, 14, 30),
+		at(3, 2, 0),
+		at(2, 10, 0),
+		at(2, 1, 45),
+		at(1, 23, 55), // closer to 02:00 across midnight than 09:00
+		at(1, 9, 0),
+	}
+	var snapshots []os.FileInfo
+	for _, tm := range times {
+		snapshots = append(snapshots, snapshotStub{name: "test_" + tm.Format(snapshotTimeFormat), modTime: tm})
+	}
+
+	for _, tc := range []struct {
+		keep Keep
+		want []int // indexes into times
+	}{
+		{Keep{Daily: 3}, []int{0, 1, 2}},
+		{Keep{Daily: 3, DailyAnchor: "02:00"}, []int{0, 3, 5}},
+		{Keep{Daily: 4, DailyAnchor: "02:00"}, []int{0, 3, 5, 6}},
+		{Keep{Daily: 2, DailyAnchor: "14:00"}, []int{0, 2}},
+	} {
+		toKeep := snapshotsToKeep(snapshots, tc.keep)
+		var want []string
+		for _, i := range tc.want {
+			want = append(want, snapshots[i].Name())
+		}
+		var got []string
+		for _, s := range snapshots {
+			if _, ok := toKeep[s.Name()]; ok {
+				got = append(got, s.Name())
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%+v: expected to keep %v, got %v", tc.keep, want, got)
+		}
+	}
+
+	// The snapshot a run has just made is kept even when another snapshot
+	// of the same day is closer to the anchor.
+	ownRun := []os.FileInfo{
+		snapshotStub{name: "test_" + at(3, 15, 0).Format(snapshotTimeFormat), modTime: at(3, 15, 0)},
+		snapshotStub{name: "test_" + at(3, 2, 0).Format(snapshotTimeFormat), modTime: at(3, 2, 0)},
+		snapshotStub{name: "test_" + at(2, 2, 0).Format(snapshotTimeFormat), modTime: at(2, 2, 0)},
+		snapshotStub{name: "test_" + at(1, 2, 0).Format(snapshotTimeFormat), modTime: at(1, 2, 0)},
+	}
+	toKeep := snapshotsToKeep(ownRun, Keep{Daily: 2, DailyAnchor: "02:00"})
+	for i, s := range ownRun {
+		if _, kept := toKeep[s.Name()]; kept != (i < 3) {
+			t.Errorf("Expected %s kept=%v, got %v", s.Name(), i < 3, kept)
+		}
+	}
+
+	if err := validateConfig(&Config{Keep: Keep{DailyAnchor: "2am"}}); err == nil {
+		t.Error("Expected an error for an invalid daily_anchor")
 	}
 }
 
-func TestRunSnapshotBackupIgnoreVanishedFilesError(t *testing.T) {
-	// Setup
+func TestSnapshotsToKeepReasons(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "goback-test")
 	if err != nil {
 		t.Fatalf("Failed to create temp dir: %v", err)
 	}
 	defer os.RemoveAll(tmpDir)
 
-	sourceDir, err := os.MkdirTemp("", "goback-source")
-	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
+	dates := []time.Time{
+		time.Date(2023, time.February, 2, 2, 0, 0, 0, time.Local),  // Thursday, 2023-W05
+		time.Date(2023, time.February, 1, 2, 0, 0, 0, time.Local),  // Wednesday, 2023-W05
+		time.Date(2023, time.January, 25, 2, 0, 0, 0, time.Local),  // 2023-W04
+		time.Date(2023, time.January, 18, 2, 0, 0, 0, time.Local),  // 2023-W03
+		time.Date(2022, time.December, 20, 2, 0, 0, 0, time.Local), // 2022-W51
+		time.Date(2022, time.November, 1, 2, 0, 0, 0, time.Local),  // 2022-W44
 	}
-	defer os.RemoveAll(sourceDir)
-
-	// Create a file in the source directory
-	if err := os.WriteFile(filepath.Join(sourceDir, "testfile"), []byte("test"), 0644); err != nil {
-		t.Fatalf("Failed to create test file: %v", err)
+	for _, d := range dates {
+		path := filepath.Join(tmpDir, "test_"+d.Format(snapshotTimeFormat))
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.Chtimes(path, d, d); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
 	}
-
-	config := &Config{
-		Destination:              tmpDir,
-		SnapshotPrefix:           "test",
-		Source:                   []string{sourceDir},
-		IgnoreVanishedFilesError: true,
+	snapshots, err := getSnapshots(context.Background(), tmpDir)
+	if err != nil {
+		t.Fatalf("getSnapshots failed: %v", err)
+	}
+	for i, j := 0, len(snapshots)-1; i < j; i, j = i+1, j-1 {
+		snapshots[i], snapshots[j] = snapshots[j], snapshots[i]
 	}
 
-	execCommand = mockExecCommand
-	defer func() { execCommand = exec.Command }()
+	reasons := snapshotsToKeep(snapshots, Keep{Daily: 1, Weekly: 2, Monthly: 3})
 
-	err = runSnapshotBackup(config, false)
-	if err != nil {
-		t.Fatalf("runSnapshotBackup failed: %v", err)
+	want := []string{
+		"kept: daily slot 1",
+		"",
+		"kept: weekly 2023-W04",
+		"kept: weekly 2023-W03",
+		"kept: monthly 2022-12",
+		"kept: monthly 2022-11",
+	}
+	for i, s := range snapshots {
+		got := ""
+		if r, ok := reasons[s.Name()]; ok {
+			got = r.String()
+		}
+		if got != want[i] {
+			t.Errorf("%s: expected %q, got %q", s.Name(), want[i], got)
+		}
 	}
 }
 
-func TestRunSnapshotBackupIgnoreVanishedFilesError_False(t *testing.T) {
-	// Setup
+// cancelOnPurge cancels a context once the first snapshot has been purged.
+type cancelOnPurge struct {
+	noopEvents
+	cancel context.CancelFunc
+}
+
+func (c cancelOnPurge) SnapshotPurged(SnapshotPurgedEvent) { c.cancel() }
+
+func TestPurgeBackupsCancelled(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "goback-test")
 	if err != nil {
 		t.Fatalf("Failed to create temp dir: %v", err)
 	}
 	defer os.RemoveAll(tmpDir)
 
-	sourceDir, err := os.MkdirTemp("", "goback-source")
+	now := time.Now()
+	for age := 1; age <= 4; age++ {
+		modTime := now.AddDate(0, 0, -age)
+		path := filepath.Join(tmpDir, "test_"+modTime.Format(snapshotTimeFormat))
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+	}
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Keep: Keep{Daily: 1}, MaxPurgePercent: 100}
+
+	// Cancelled before purge starts: nothing is deleted.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := purgeBackups(ctx, config, false); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if entries, _ := os.ReadDir(tmpDir); len(entries) != 4 {
+		t.Errorf("Expected all 4 snapshots to remain, got %d", len(entries))
+	}
+
+	// Cancelled after the first deletion: purge stops there.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	events = cancelOnPurge{cancel: cancel}
+	defer func() { events = noopEvents{} }()
+	if err := purgeBackups(ctx, config, false); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if entries, _ := os.ReadDir(tmpDir); len(entries) != 3 {
+		t.Errorf("Expected purge to stop after one deletion leaving 3 snapshots, got %d", len(entries))
+	}
+}
+
+func TestPurgeBackupsPurgeExclude(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
 	if err != nil {
 		t.Fatalf("Failed to create temp dir: %v", err)
 	}
-	defer os.RemoveAll(sourceDir)
+	defer os.RemoveAll(tmpDir)
 
-	// Create a file in the source directory
-	if err := os.WriteFile(filepath.Join(sourceDir, "testfile"), []byte("test"), 0644); err != nil {
-		t.Fatalf("Failed to create test file: %v", err)
+	now := time.Now()
+	var names []string
+	for i, label := range []string{"-release-1.0", "", "-release-1.1", ""} {
+		modTime := now.AddDate(0, 0, i-10)
+		name := "test_" + modTime.Format(snapshotTimeFormat) + label
+		names = append(names, name)
+		path := filepath.Join(tmpDir, name)
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
 	}
 
-	config := &Config{
-		Destination:              tmpDir,
-		SnapshotPrefix:           "test",
-		Source:                   []string{sourceDir},
-		IgnoreVanishedFilesError: false,
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Keep: Keep{Daily: 1}, PurgeExclude: []string{"*-release-*", "*_1999-*"}}
+	if err := purgeBackups(context.Background(), config, false); err != nil {
+		t.Fatalf("purgeBackups failed: %v", err)
 	}
 
-	execCommand = mockExecCommand
-	defer func() { execCommand = exec.Command }()
+	// Both releases are protected, the newest unlabelled snapshot is the
+	// daily and the older one matches no pattern.
+	for i, name := range names {
+		_, err := os.Stat(filepath.Join(tmpDir, name))
+		if i == 1 {
+			if !os.IsNotExist(err) {
+				t.Errorf("Expected %s to be purged", name)
+			}
+		} else if err != nil {
+			t.Errorf("Expected %s to be kept, got %v", name, err)
+		}
+	}
 
-	err = runSnapshotBackup(config, false)
-	if err == nil {
-		t.Fatal("runSnapshotBackup should have failed but didn't")
+	if err := validateConfig(&Config{PurgeExclude: []string{"[release"}}); err == nil {
+		t.Error("Expected an error for a malformed purge_exclude pattern")
 	}
 }
 
-func TestRunSimpleBackup(t *testing.T) {
-	// Setup
+func TestPurgeBackupsReturnsErrors(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "goback-test")
 	if err != nil {
 		t.Fatalf("Failed to create temp dir: %v", err)
 	}
 	defer os.RemoveAll(tmpDir)
 
-	sourceDir, err := os.MkdirTemp("", "goback-source")
+	now := time.Now()
+	var names []string
+	for i := 0; i < 3; i++ {
+		modTime := now.AddDate(0, 0, -i)
+		name := "test_" + modTime.Format(snapshotTimeFormat)
+		names = append(names, name)
+		path := filepath.Join(tmpDir, name)
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+	}
+
+	// The middle snapshot can't be removed; the oldest still must be.
+	stuck := names[1]
+	removeAll = func(path string) error {
+		if filepath.Base(path) == stuck {
+			return os.ErrPermission
+		}
+		return os.RemoveAll(path)
+	}
+	defer func() { removeAll = os.RemoveAll }()
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Keep: Keep{Daily: 1}, MaxPurgePercent: 100}
+	err = purgeBackups(context.Background(), config, false)
+	if err == nil {
+		t.Fatal("Expected purgeBackups to return an error")
+	}
+	if !strings.Contains(err.Error(), stuck) || !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Expected error to mention %s, got %v", stuck, err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, names[2])); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be purged despite the earlier failure", names[2])
+	}
+}
+
+func TestBuildRsyncArgsSparse(t *testing.T) {
+	args := buildRsyncArgs(&Config{Sparse: true}, "/dest", "", false)
+	if !hasArg(args, "--sparse") || hasArg(args, "--inplace") {
+		t.Errorf("Expected --sparse instead of --inplace, got %v", args)
+	}
+	if args := buildRsyncArgs(&Config{}, "/dest", "", false); hasArg(args, "--sparse") {
+		t.Errorf("Expected no --sparse by default, got %v", args)
+	}
+
+	if err := validateConfig(&Config{Sparse: true, RsyncExtraFlags: "--numeric-ids"}); err != nil {
+		t.Errorf("Expected sparse to be valid, got %v", err)
+	}
+	if err := validateConfig(&Config{Sparse: true, RsyncExtraFlags: "--numeric-ids --inplace"}); err == nil {
+		t.Error("Expected sparse with --inplace to be rejected")
+	}
+	if err := validateConfig(&Config{Sparse: true, AppendVerify: true}); err == nil {
+		t.Error("Expected sparse with append_verify to be rejected")
+	}
+}
+
+func TestGetSnapshotsSkipsUnreadableEntries(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
 	if err != nil {
 		t.Fatalf("Failed to create temp dir: %v", err)
 	}
-	defer os.RemoveAll(sourceDir)
+	defer os.RemoveAll(tmpDir)
 
-	config := &Config{
-		Mode:                     "simple",
-		Destination:              tmpDir,
-		Source:                   []string{sourceDir},
-		IgnoreVanishedFilesError: true,
+	now := time.Now()
+	var names []string
+	for i := 2; i >= 0; i-- {
+		modTime := now.AddDate(0, 0, -i)
+		name := "test_" + modTime.Format(snapshotTimeFormat)
+		names = append(names, name)
+		path := filepath.Join(tmpDir, name)
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
 	}
 
-	execCommand = mockExecCommand
-	defer func() { execCommand = exec.Command }()
+	bad := names[1]
+	entryInfo = func(e os.DirEntry) (os.FileInfo, error) {
+		if e.Name() == bad {
+			return nil, os.ErrPermission
+		}
+		return e.Info()
+	}
+	defer func() { entryInfo = os.DirEntry.Info }()
 
-	err = runSimpleBackup(config, false)
+	snapshots, err := getSnapshots(context.Background(), tmpDir)
+	if err != nil {
+		t.Fatalf("getSnapshots failed: %v", err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Name() != names[0] || snapshots[1].Name() != names[2] {
+		t.Errorf("Expected %s and %s, got %v", names[0], names[2], snapshots)
+	}
+}
+
+func TestBuildRsyncArgsAppendVerify(t *testing.T) {
+	args := buildRsyncArgs(&Config{AppendVerify: true}, "/dest", "", false)
+	if !hasArg(args, "--append-verify") || hasArg(args, "--inplace") {
+		t.Errorf("Expected --append-verify instead of --inplace, got %v", args)
+	}
+	if args := buildRsyncArgs(&Config{}, "/dest", "", false); hasArg(args, "--append-verify") || !hasArg(args, "--inplace") {
+		t.Errorf("Expected --inplace by default, got %v", args)
+	}
+
+	if err := validateConfig(&Config{AppendVerify: true, RsyncExtraFlags: "--bwlimit=1000"}); err != nil {
+		t.Errorf("Expected append_verify to be valid, got %v", err)
+	}
+	for _, flags := range []string{"--inplace", "--compress --checksum", "-c"} {
+		if err := validateConfig(&Config{AppendVerify: true, RsyncExtraFlags: flags}); err == nil {
+			t.Errorf("Expected append_verify with %q to be rejected", flags)
+		}
+	}
+}
+
+func TestPurgeBackupsReadOnlyDestination(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		modTime := now.AddDate(0, 0, -i)
+		path := filepath.Join(tmpDir, "test_"+modTime.Format(snapshotTimeFormat))
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+	}
+	createProbe = func(dir, pattern string) (*os.File, error) {
+		return nil, &os.PathError{Op: "open", Path: filepath.Join(dir, pattern), Err: syscall.EROFS}
+	}
+	defer func() { createProbe = os.CreateTemp }()
+
+	var attempts int
+	removeAll = func(path string) error {
+		attempts++
+		return os.RemoveAll(path)
+	}
+	defer func() { removeAll = os.RemoveAll }()
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Keep: Keep{Daily: 1}}
+	err = purgeBackups(context.Background(), config, false)
+	if err == nil || !strings.Contains(err.Error(), "not writable") {
+		t.Errorf("Expected a not writable error, got %v", err)
+	}
+	if attempts != 0 {
+		t.Errorf("Expected no deletions to be attempted, got %d", attempts)
+	}
+}
+
+func TestRunSimpleBackupMaxDeleteExceeded(t *testing.T) {
+	assumeSourcesExist(t)
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, "", 25)
+	defer func() { execCommand = exec.CommandContext }()
+
+	config := &Config{Mode: "simple", Destination: tmpDir, Source: []string{"/tmp/source1"}, MaxDelete: 100}
+	if err := validateConfig(config); err != nil {
+		t.Fatalf("validateConfig failed: %v", err)
+	}
+	err = runSimpleBackup(context.Background(), config, false)
+	if !errors.Is(err, errMaxDeleteExceeded) {
+		t.Fatalf("Expected errMaxDeleteExceeded, got %v", err)
+	}
+	if !hasArg(gotArgs, "--max-delete=100") {
+		t.Errorf("Expected --max-delete=100 in rsync args, got %v", gotArgs)
+	}
+
+	// Without max_delete, exit code 25 is an ordinary failure.
+	config.MaxDelete = 0
+	if err := runSimpleBackup(context.Background(), config, false); err == nil || errors.Is(err, errMaxDeleteExceeded) {
+		t.Errorf("Expected a plain rsync failure, got %v", err)
+	}
+
+	// A hardlink snapshot starts from an empty directory, so there is
+	// nothing for --max-delete to count.
+	if err := validateConfig(&Config{MaxDelete: 100}); err == nil {
+		t.Error("Expected max_delete to be rejected for hardlink snapshots")
+	}
+	if err := validateConfig(&Config{MaxDelete: 100, SnapshotBackend: "btrfs"}); err != nil {
+		t.Errorf("Expected max_delete to be accepted with btrfs, got %v", err)
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	// Setup
+	configFileContent := `
+destination: /tmp/backup
+snapshot_prefix: test
+source:
+  - /tmp/source1
+exclude:
+  - /tmp/source1/excluded
+keep:
+  daily: 1
+  weekly: 1
+  monthly: 1
+rsync_extra_flags: "--verbose"
+ignore_vanished_files_error: true
+`
+	tmpFile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString(configFileContent); err != nil {
+		t.Fatalf("Failed to write to temp file: %v", err)
+	}
+	tmpFile.Close()
+
+	// Execute
+	config, err := readConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("readConfig failed: %v", err)
+	}
+
+	// Verify
+	if config.Destination != "/tmp/backup" {
+		t.Errorf("Expected destination '/tmp/backup', got '%s'", config.Destination)
+	}
+	if config.Mode != "" && config.Mode != "snapshot" {
+		t.Errorf("Expected mode 'snapshot' or empty, got '%s'", config.Mode)
+	}
+	if config.SnapshotPrefix != "test" {
+		t.Errorf("Expected snapshot_prefix 'test', got '%s'", config.SnapshotPrefix)
+	}
+	if len(config.Source) != 1 || config.Source[0] != "/tmp/source1" {
+		t.Errorf("Expected source ['/tmp/source1'], got '%v'", config.Source)
+	}
+	if config.Keep.Daily != 1 {
+		t.Errorf("Expected keep.daily 1, got %d", config.Keep.Daily)
+	}
+	if config.IgnoreVanishedFilesError != true {
+		t.Errorf("Expected ignore_vanished_files_error true, got %v", config.IgnoreVanishedFilesError)
+	}
+}
+
+func TestReadConfig_SimpleMode(t *testing.T) {
+	// Setup
+	configFileContent := `
+mode: simple
+destination: /tmp/backup
+source:
+  - /tmp/source1
+`
+	tmpFile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString(configFileContent); err != nil {
+		t.Fatalf("Failed to write to temp file: %v", err)
+	}
+	tmpFile.Close()
+
+	// Execute
+	config, err := readConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("readConfig failed: %v", err)
+	}
+
+	// Verify
+	if config.Mode != "simple" {
+		t.Errorf("Expected mode 'simple', got '%s'", config.Mode)
+	}
+	if config.Destination != "/tmp/backup" {
+		t.Errorf("Expected destination '/tmp/backup', got '%s'", config.Destination)
+	}
+}
+
+func TestReadConfigFormats(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configs := map[string]string{
+		"config.yaml": `
+destination: /tmp/backup
+snapshot_prefix: test
+source:
+  - /tmp/source1
+keep:
+  daily: 7
+  weekly: 4
+ignore_vanished_files_error: true
+`,
+		"config.json": `{
+  "destination": "/tmp/backup",
+  "snapshot_prefix": "test",
+  "source": ["/tmp/source1"],
+  "keep": {"daily": 7, "weekly": 4},
+  "ignore_vanished_files_error": true
+}`,
+		"config.toml": `
+destination = "/tmp/backup"
+snapshot_prefix = "test"
+source = ["/tmp/source1"]
+ignore_vanished_files_error = true
+
+[keep]
+daily = 7
+weekly = 4
+`,
+		// Files without a known extension are read as YAML.
+		"config": `
+destination: /tmp/backup
+snapshot_prefix: test
+source: [/tmp/source1]
+keep: {daily: 7, weekly: 4}
+ignore_vanished_files_error: true
+`,
+	}
+	for name, content := range configs {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		config, err := readConfig(path)
+		if err != nil {
+			t.Errorf("readConfig(%s) failed: %v", name, err)
+			continue
+		}
+		if config.Destination != "/tmp/backup" || config.SnapshotPrefix != "test" ||
+			len(config.Source) != 1 || config.Source[0] != "/tmp/source1" ||
+			config.Keep.Daily != 7 || config.Keep.Weekly != 4 || !config.IgnoreVanishedFilesError {
+			t.Errorf("readConfig(%s) = %+v, not the expected configuration", name, config)
+		}
+	}
+
+	// A JSON file isn't mistaken for TOML or the other way round.
+	path := filepath.Join(tmpDir, "broken.json")
+	if err := os.WriteFile(path, []byte(configs["config.toml"]), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := readConfig(path); err == nil {
+		t.Error("Expected TOML in a .json file to be rejected")
+	}
+}
+
+func TestReadConfig_NotFound(t *testing.T) {
+	_, err := readConfig("non-existent-file.yaml")
+	if err == nil {
+		t.Errorf("Expected an error when reading a non-existent file, but got nil")
+	}
+}
+
+func TestRunSnapshotBackupIgnoreVanishedFilesError(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sourceDir, err := os.MkdirTemp("", "goback-source")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(sourceDir)
+
+	// Create a file in the source directory
+	if err := os.WriteFile(filepath.Join(sourceDir, "testfile"), []byte("test"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	config := &Config{
+		Destination:              tmpDir,
+		SnapshotPrefix:           "test",
+		Source:                   []string{sourceDir},
+		IgnoreVanishedFilesError: true,
+	}
+
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.CommandContext }()
+
+	err = runSnapshotBackup(context.Background(), config, runOptions{}, false)
+	if err != nil {
+		t.Fatalf("runSnapshotBackup failed: %v", err)
+	}
+}
+
+func TestRunSnapshotBackupIgnoreVanishedFilesError_False(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sourceDir, err := os.MkdirTemp("", "goback-source")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(sourceDir)
+
+	// Create a file in the source directory
+	if err := os.WriteFile(filepath.Join(sourceDir, "testfile"), []byte("test"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	config := &Config{
+		Destination:              tmpDir,
+		SnapshotPrefix:           "test",
+		Source:                   []string{sourceDir},
+		IgnoreVanishedFilesError: false,
+	}
+
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.CommandContext }()
+
+	err = runSnapshotBackup(context.Background(), config, runOptions{}, false)
+	if err == nil {
+		t.Fatal("runSnapshotBackup should have failed but didn't")
+	}
+}
+
+func TestRunSimpleBackup(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sourceDir, err := os.MkdirTemp("", "goback-source")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(sourceDir)
+
+	config := &Config{
+		Mode:                     "simple",
+		Destination:              tmpDir,
+		Source:                   []string{sourceDir},
+		IgnoreVanishedFilesError: true,
+	}
+
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.CommandContext }()
+
+	err = runSimpleBackup(context.Background(), config, false)
 	if err != nil {
 		t.Fatalf("runSimpleBackup failed: %v", err)
 	}
 }
-    
-    func mockExecCommand(command string, args ...string) *exec.Cmd {
-    	cs := []string{"-test.run=TestHelperProcess", "--", command}
-    	cs = append(cs, args...)
-    	cmd := exec.Command(os.Args[0], cs...)
-    	cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
-    	return cmd
-    }
-        
-    func TestHelperProcess(t *testing.T) {
-    	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
-    		return
-    	}
-    	// Check the command line arguments to see which command we're supposed to be.
-    	args := os.Args
-    	for len(args) > 0 {
-    		if args[0] == "--" {
-    			args = args[1:]
-    			break
-    		}
-    		args = args[1:]
-    	}
-    	if len(args) == 0 {
-    		fmt.Fprintf(os.Stderr, "No command\n")
-    		os.Exit(2)
-    	}
-    
-    	cmd, args := args[0], args[1:]
-    	if cmd == "rsync" {
-    		// Simulate rsync exiting with code 24
-    		os.Exit(24)
-    	}
-    }
-    
\ No newline at end of file
+
+func TestRunSnapshotBackupItemizeChanges(t *testing.T) {
+	assumeSourcesExist(t)
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Destination:    tmpDir,
+		SnapshotPrefix: "test",
+		Source:         []string{"/tmp/source1"},
+		ItemizeChanges: true,
+	}
+
+	output := "sending incremental file list\n" +
+		">f+++++++++ docs/new.txt\n" +
+		">f.st...... docs/changed.txt\n" +
+		"cd+++++++++ docs/sub/\n" +
+		"*deleting   docs/old.txt\n" +
+		"Number of files: 4\n"
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, output, 0)
+	defer func() { execCommand = exec.CommandContext }()
+
+	if err := runSnapshotBackup(context.Background(), config, runOptions{}, false); err != nil {
+		t.Fatalf("runSnapshotBackup failed: %v", err)
+	}
+
+	if !hasArg(gotArgs, "--itemize-changes") {
+		t.Errorf("Expected --itemize-changes in rsync args, got %v", gotArgs)
+	}
+
+	snapshot, err := getLatestSnapshot(context.Background(), config)
+	if err != nil || snapshot == "" {
+		t.Fatalf("Failed to find snapshot: %v", err)
+	}
+	changes, err := os.ReadFile(filepath.Join(tmpDir, snapshot, "changes.log"))
+	if err != nil {
+		t.Fatalf("Failed to read changes log: %v", err)
+	}
+	for _, line := range []string{"docs/new.txt", "docs/changed.txt", "docs/sub/", "*deleting   docs/old.txt"} {
+		if !strings.Contains(string(changes), line) {
+			t.Errorf("Expected changes log to contain %q, got:\n%s", line, changes)
+		}
+	}
+	rsyncLog, err := os.ReadFile(filepath.Join(tmpDir, snapshot, "rsync.log"))
+	if err != nil {
+		t.Fatalf("Failed to read rsync log: %v", err)
+	}
+	if strings.Contains(string(rsyncLog), "docs/new.txt") {
+		t.Errorf("Expected itemized lines to be kept out of rsync.log, got:\n%s", rsyncLog)
+	}
+}
+
+func TestRsyncOutputWriterCountsChangedFiles(t *testing.T) {
+	var out, changes strings.Builder
+	w := &rsyncOutputWriter{out: &out, changes: &changes}
+	input := ">f+++++++++ a\ncd+++++++++ dir/\n.f..t...... b\n*deleting   c\nsent 10 bytes"
+	if _, err := w.Write([]byte(input)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	if w.stats.ChangedFiles != 3 {
+		t.Errorf("Expected 3 changed files, got %d", w.stats.ChangedFiles)
+	}
+	if out.String() != "sent 10 bytes" {
+		t.Errorf("Expected non-itemized output to pass through, got %q", out.String())
+	}
+}
+
+func TestBuildRsyncArgsLinks(t *testing.T) {
+	args := buildRsyncArgs(&Config{}, "/dest", "", false)
+	if hasArg(args, "--copy-links") || hasArg(args, "--copy-unsafe-links") {
+		t.Errorf("Expected symlinks to be preserved by default, got %v", args)
+	}
+
+	args = buildRsyncArgs(&Config{CopyLinks: true}, "/dest", "", false)
+	if !hasArg(args, "--copy-links") {
+		t.Errorf("Expected --copy-links with copy_links set, got %v", args)
+	}
+
+	args = buildRsyncArgs(&Config{CopyUnsafeLinks: true}, "/dest", "", false)
+	if !hasArg(args, "--copy-unsafe-links") || hasArg(args, "--copy-links") {
+		t.Errorf("Expected only --copy-unsafe-links with copy_unsafe_links set, got %v", args)
+	}
+}
+
+func TestValidateConfigLinks(t *testing.T) {
+	if err := validateConfig(&Config{CopyLinks: true}); err != nil {
+		t.Errorf("Expected copy_links alone to be valid, got %v", err)
+	}
+	if err := validateConfig(&Config{CopyUnsafeLinks: true}); err != nil {
+		t.Errorf("Expected copy_unsafe_links alone to be valid, got %v", err)
+	}
+	if err := validateConfig(&Config{CopyLinks: true, CopyUnsafeLinks: true}); err == nil {
+		t.Error("Expected an error when copy_links and copy_unsafe_links are both set")
+	}
+}
+
+func TestRsyncOutputWriterParsesStats(t *testing.T) {
+	var out strings.Builder
+	w := &rsyncOutputWriter{out: &out}
+	input := "Number of files: 1,234 (reg: 1,000, dir: 234)\n" +
+		"Number of regular files transferred: 12\n" +
+		"Total file size: 5.60G bytes\n" +
+		"Total transferred file size: 1,024 bytes\n"
+	if _, err := w.Write([]byte(input)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	if w.stats.FilesTransferred != 12 {
+		t.Errorf("Expected 12 files transferred, got %d", w.stats.FilesTransferred)
+	}
+	if w.stats.TotalBytes != 5600000000 {
+		t.Errorf("Expected total size 5600000000, got %d", w.stats.TotalBytes)
+	}
+	if w.stats.TransferredBytes != 1024 {
+		t.Errorf("Expected transferred size 1024, got %d", w.stats.TransferredBytes)
+	}
+	if out.String() != input {
+		t.Errorf("Expected stats output to pass through unchanged, got %q", out.String())
+	}
+}
+
+func TestGetSnapshotsIgnoresForeignDirectories(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Now()
+	snapshots := []string{
+		"test_" + now.AddDate(0, 0, -2).Format(snapshotTimeFormat),
+		"test_" + now.AddDate(0, 0, -1).Format(snapshotTimeFormat),
+	}
+	foreign := []string{"lost+found", "notes", "failed-2025-01-01.log", "test_not-a-time", ".unfinished"}
+	for _, name := range append(append([]string{}, snapshots...), foreign...) {
+		if err := os.Mkdir(filepath.Join(tmpDir, name), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+	// Make the foreign directories the oldest so they would be purged first.
+	old := now.AddDate(-1, 0, 0)
+	for _, name := range foreign {
+		if err := os.Chtimes(filepath.Join(tmpDir, name), old, old); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+	}
+
+	got, err := getSnapshots(context.Background(), tmpDir)
+	if err != nil {
+		t.Fatalf("getSnapshots failed: %v", err)
+	}
+	if len(got) != len(snapshots) {
+		t.Fatalf("Expected %d snapshots, got %d", len(snapshots), len(got))
+	}
+	for i, info := range got {
+		if info.Name() != snapshots[i] {
+			t.Errorf("Expected snapshot %d to be %s, got %s", i, snapshots[i], info.Name())
+		}
+	}
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Keep: Keep{Daily: 1}}
+	if err := purgeBackups(context.Background(), config, false); err != nil {
+		t.Fatalf("purgeBackups failed: %v", err)
+	}
+	for _, name := range foreign {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); err != nil {
+			t.Errorf("Expected foreign directory %s to be left alone, got %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, snapshots[0])); !os.IsNotExist(err) {
+		t.Errorf("Expected old snapshot %s to be purged", snapshots[0])
+	}
+}
+
+func TestRunSnapshotBackupDryRunLog(t *testing.T) {
+	assumeSourcesExist(t)
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	logPath := filepath.Join(tmpDir, "dry-run.log")
+	*dryRunLog = logPath
+	defer func() { *dryRunLog = "" }()
+
+	config := &Config{
+		Destination:    filepath.Join(tmpDir, "dest"),
+		SnapshotPrefix: "test",
+		Source:         []string{"/tmp/source1"},
+	}
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, "would transfer docs/a.txt\n", 0)
+	defer func() { execCommand = exec.CommandContext }()
+
+	if err := runSnapshotBackup(context.Background(), config, runOptions{}, true); err != nil {
+		t.Fatalf("runSnapshotBackup failed: %v", err)
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Expected dry run log to be written: %v", err)
+	}
+	if !strings.Contains(string(data), "would transfer docs/a.txt") {
+		t.Errorf("Expected dry run log to contain rsync output, got %q", data)
+	}
+}
+
+func TestBuildRsyncArgsDirMerge(t *testing.T) {
+	config := &Config{DirMerge: ".rsync-filter", Exclude: []string{"*.log"}}
+	args := buildRsyncArgs(config, "/dest", "", false)
+
+	filter, exclude := -1, -1
+	for i, arg := range args {
+		switch arg {
+		case "--filter=dir-merge /.rsync-filter":
+			filter = i
+		case "--exclude=*.log":
+			exclude = i
+		}
+	}
+	if filter < 0 {
+		t.Fatalf("Expected dir-merge filter in rsync args, got %v", args)
+	}
+	if exclude < 0 || filter > exclude {
+		t.Errorf("Expected dir-merge filter before the excludes, got %v", args)
+	}
+
+	if err := validateConfig(&Config{DirMerge: "my filter"}); err == nil {
+		t.Error("Expected an error for a dir_merge filename containing a space")
+	}
+}
+
+func TestSetupLogging(t *testing.T) {
+	oldLogger := log.Logger
+	defer func() { log.Logger = oldLogger }()
+
+	var buf bytes.Buffer
+	setupLogging(&buf, &Config{})
+	log.Info().Msg("hello")
+	if !strings.Contains(buf.String(), time.Now().Format("2006")) {
+		t.Errorf("Expected a timestamp by default, got %q", buf.String())
+	}
+
+	buf.Reset()
+	noTimestamps := false
+	setupLogging(&buf, &Config{LogTimestamps: &noTimestamps, LogPrefix: "goback: "})
+	log.Info().Msg("hello")
+	if !strings.HasPrefix(buf.String(), "goback: ") {
+		t.Errorf("Expected log line to start with the prefix, got %q", buf.String())
+	}
+	if strings.Contains(buf.String(), time.Now().Format("2006")) {
+		t.Errorf("Expected no timestamp with log_timestamps false, got %q", buf.String())
+	}
+}
+
+func TestParseSnapshotTimeWithoutColons(t *testing.T) {
+	when := time.Date(2025, time.March, 9, 14, 5, 30, 0, time.Local)
+
+	for _, layout := range []string{snapshotTimeFormat, snapshotTimeFormatNoColons} {
+		name := "test_" + when.Format(layout)
+		got, ok := parseSnapshotTime(name)
+		if !ok || !got.Equal(when) {
+			t.Errorf("Expected %s to parse as %v, got %v (%v)", name, when, got, ok)
+		}
+	}
+	if name := "test_" + when.Format(snapshotTimeFormatNoColons); strings.Contains(name, ":") {
+		t.Errorf("Expected no colons in %s", name)
+	}
+	if name := "test_" + when.Format(snapshotTimeFormatNoColons); name != "test_2025-03-09_14-05-30" {
+		t.Errorf("Unexpected snapshot name %s", name)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if !colonsSupported(tmpDir) {
+		t.Error("Expected the test filesystem to support colons")
+	}
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Expected the colon probe to be cleaned up, got %v (%v)", entries, err)
+	}
+}
+
+func TestBuildRsyncArgsVerbosity(t *testing.T) {
+	countV := func(args []string) int {
+		n := 0
+		for _, arg := range args {
+			if arg == "-v" {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := countV(buildRsyncArgs(&Config{}, "/dest", "", false)); n != 1 {
+		t.Errorf("Expected one -v by default, got %d", n)
+	}
+	for level := 0; level <= 3; level++ {
+		config := &Config{Verbosity: &level}
+		args := buildRsyncArgs(config, "/dest", "", false)
+		if n := countV(args); n != level {
+			t.Errorf("Expected %d -v flags, got %d in %v", level, n, args)
+		}
+		if !hasArg(args, "--stats") {
+			t.Errorf("Expected --stats at verbosity %d, got %v", level, args)
+		}
+	}
+
+	tooHigh := 4
+	if err := validateConfig(&Config{Verbosity: &tooHigh}); err == nil {
+		t.Error("Expected an error for verbosity 4")
+	}
+}
+
+func TestBuildRsyncArgsIncludeExtensions(t *testing.T) {
+	config := &Config{Exclude: []string{"/cache"}, IncludeExtensions: []string{"jpg", ".png"}}
+	args := buildRsyncArgs(config, "/dest", "", false)
+
+	want := []string{"--exclude=/cache", "--prune-empty-dirs", "--include=*/", "--include=*.jpg", "--include=*.png", "--exclude=*"}
+	start := -1
+	for i, arg := range args {
+		if arg == want[0] {
+			start = i
+			break
+		}
+	}
+	if start < 0 || start+len(want) > len(args) || strings.Join(args[start:start+len(want)], " ") != strings.Join(want, " ") {
+		t.Errorf("Expected filter sequence %v, got %v", want, args)
+	}
+
+	if args := buildRsyncArgs(&Config{}, "/dest", "", false); hasArg(args, "--exclude=*") {
+		t.Errorf("Expected no extension filters by default, got %v", args)
+	}
+}
+
+func TestBuildRsyncArgsChmod(t *testing.T) {
+	args := buildRsyncArgs(&Config{Chmod: "D755,F644"}, "/dest", "", false)
+	if !hasArg(args, "--chmod=D755,F644") {
+		t.Errorf("Expected --chmod=D755,F644 in rsync args, got %v", args)
+	}
+
+	for _, spec := range []string{"D755,F644", "Fgo-w", "u+rwX,go=rX", "0644"} {
+		if err := validateConfig(&Config{Chmod: spec}); err != nil {
+			t.Errorf("Expected chmod spec %q to be valid, got %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"D755,", "F999", "rwx", "D755 F644"} {
+		if err := validateConfig(&Config{Chmod: spec}); err == nil {
+			t.Errorf("Expected chmod spec %q to be rejected", spec)
+		}
+	}
+}
+
+func TestBuildRsyncArgsFuzzy(t *testing.T) {
+	for level := 0; level <= 2; level++ {
+		config := &Config{Fuzzy: level}
+		if err := validateConfig(config); err != nil {
+			t.Fatalf("validateConfig(fuzzy %d) failed: %v", level, err)
+		}
+		count := 0
+		for _, arg := range buildRsyncArgs(config, "/dest", "/prev", false) {
+			if arg == "--fuzzy" {
+				count++
+			}
+		}
+		if count != level {
+			t.Errorf("Expected %d --fuzzy flags for fuzzy %d, got %d", level, level, count)
+		}
+	}
+	for _, bad := range []int{-1, 3} {
+		if err := validateConfig(&Config{Fuzzy: bad}); err == nil {
+			t.Errorf("Expected fuzzy %d to be rejected", bad)
+		}
+	}
+}
+
+func TestBuildRsyncArgsModifyWindow(t *testing.T) {
+	for _, arg := range buildRsyncArgs(&Config{}, "/dest", "", false) {
+		if strings.HasPrefix(arg, "--modify-window") {
+			t.Errorf("Expected no --modify-window by default, got %s", arg)
+		}
+	}
+	args := buildRsyncArgs(&Config{ModifyWindow: 2}, "/dest", "/prev", false)
+	if !hasArg(args, "--modify-window=2") {
+		t.Errorf("Expected --modify-window=2, got %v", args)
+	}
+	if err := validateConfig(&Config{ModifyWindow: -1}); err == nil {
+		t.Error("Expected a negative modify_window to be rejected")
+	}
+}
+
+func TestBuildRsyncArgsBlockSizeAndChecksumSeed(t *testing.T) {
+	for _, arg := range buildRsyncArgs(&Config{}, "/dest", "", false) {
+		if strings.HasPrefix(arg, "--block-size") || strings.HasPrefix(arg, "--checksum-seed") {
+			t.Errorf("Expected no %s by default", arg)
+		}
+	}
+	args := buildRsyncArgs(&Config{BlockSize: 65536, ChecksumSeed: 42}, "/dest", "", false)
+	if !hasArg(args, "--block-size=65536") || !hasArg(args, "--checksum-seed=42") {
+		t.Errorf("Expected --block-size=65536 and --checksum-seed=42, got %v", args)
+	}
+	for _, config := range []*Config{
+		{BlockSize: -1},
+		{BlockSize: maxRsyncBlockSize + 1},
+		{ChecksumSeed: -1},
+		{ChecksumSeed: math.MaxInt32 + 1},
+	} {
+		if err := validateConfig(config); err == nil {
+			t.Errorf("Expected block_size %d, checksum_seed %d to be rejected", config.BlockSize, config.ChecksumSeed)
+		}
+	}
+}
+
+func TestBuildRsyncArgsSkipSpecialFiles(t *testing.T) {
+	if args := buildRsyncArgs(&Config{}, "/dest", "", false); hasArg(args, "--no-D") {
+		t.Errorf("Expected no --no-D by default, got %v", args)
+	}
+	args := buildRsyncArgs(&Config{SkipSpecialFiles: true}, "/dest", "", false)
+	archive, noD := -1, -1
+	for i, arg := range args {
+		switch arg {
+		case "-a":
+			archive = i
+		case "--no-D":
+			noD = i
+		}
+	}
+	if noD < 0 || noD < archive {
+		t.Errorf("Expected --no-D after -a in rsync args, got %v", args)
+	}
+}
+
+func TestRsyncDaemonDestination(t *testing.T) {
+	assumeSourcesExist(t)
+	dest := "rsync://backup.example.com/module/path"
+	config := &Config{Mode: "simple", Destination: dest, Source: []string{"/tmp/source1"}}
+
+	args := buildRsyncArgs(config, config.Destination, "", false)
+	if args[len(args)-1] != dest {
+		t.Errorf("Expected rsync target %s to be passed through, got %v", dest, args)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	t.Chdir(tmpDir)
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, "", 0)
+	defer func() { execCommand = exec.CommandContext }()
+
+	if err := runSimpleBackup(context.Background(), config, false); err != nil {
+		t.Fatalf("runSimpleBackup failed: %v", err)
+	}
+	if gotArgs[len(gotArgs)-1] != dest {
+		t.Errorf("Expected rsync to be run against %s, got %v", dest, gotArgs)
+	}
+	if entries, _ := os.ReadDir(tmpDir); len(entries) != 0 {
+		t.Errorf("Expected no local directories to be created, got %v", entries)
+	}
+
+	for _, d := range []string{dest, "backup.example.com::module/path"} {
+		if err := validateConfig(&Config{Mode: "simple", Destination: d}); err != nil {
+			t.Errorf("Expected simple mode to accept %s, got %v", d, err)
+		}
+		if err := validateConfig(&Config{Destination: d}); err == nil {
+			t.Errorf("Expected snapshot mode to reject %s", d)
+		}
+	}
+}
+
+func TestRunSnapshotBackupSameSecond(t *testing.T) {
+	assumeSourcesExist(t)
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	when := time.Date(2025, time.June, 1, 2, 0, 0, 0, time.Local)
+	timeNow = func() time.Time { return when }
+	defer func() { timeNow = time.Now }()
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, "", 0)
+	defer func() { execCommand = exec.CommandContext }()
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Source: []string{"/tmp/source1"}}
+	for i := 0; i < 3; i++ {
+		if err := runSnapshotBackup(context.Background(), config, runOptions{}, false); err != nil {
+			t.Fatalf("runSnapshotBackup failed: %v", err)
+		}
+	}
+
+	first := "test_" + when.Format(snapshotTimeFormat)
+	want := []string{first, first + "_2", first + "_3"}
+	// Give them all the same mtime so only the names can order them.
+	for _, name := range want {
+		if err := os.Chtimes(filepath.Join(tmpDir, name), when, when); err != nil {
+			t.Fatalf("Expected snapshot %s: %v", name, err)
+		}
+	}
+
+	snapshots, err := getSnapshots(context.Background(), tmpDir)
+	if err != nil {
+		t.Fatalf("getSnapshots failed: %v", err)
+	}
+	var got []string
+	for _, s := range snapshots {
+		got = append(got, s.Name())
+		if parsed, ok := parseSnapshotTime(s.Name()); !ok || !parsed.Equal(when) {
+			t.Errorf("Expected %s to parse as %v, got %v (%v)", s.Name(), when, parsed, ok)
+		}
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected snapshots %v, got %v", want, got)
+	}
+}
+
+// hasArg reports whether want appears in args.
+func hasArg(args []string, want string) bool {
+	for _, arg := range args {
+		if arg == want {
+			return true
+		}
+	}
+	return false
+}
+
+func mockExecCommand(ctx context.Context, command string, args ...string) *exec.Cmd {
+	cs := []string{"-test.run=TestHelperProcess", "--", command}
+	cs = append(cs, args...)
+	cmd := exec.CommandContext(ctx, os.Args[0], cs...)
+	cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
+	return cmd
+}
+
+// fakeRsync returns an execCommand replacement that records the arguments it
+// was called with and makes the helper process print output and exit with
+// exitCode.
+// assumeSourcesExist makes selectSources accept the made up sources of
+// tests that fake rsync.
+func assumeSourcesExist(t *testing.T) {
+	openSource = func(string) (*os.File, error) { return os.Open(os.DevNull) }
+	t.Cleanup(func() { openSource = os.Open })
+}
+
+func fakeRsync(gotArgs *[]string, output string, exitCode int) func(context.Context, string, ...string) *exec.Cmd {
+	return func(ctx context.Context, command string, args ...string) *exec.Cmd {
+		*gotArgs = append([]string(nil), args...)
+		cmd := mockExecCommand(ctx, command, args...)
+		cmd.Env = append(cmd.Env, "MOCK_RSYNC_OUTPUT="+output, fmt.Sprintf("MOCK_RSYNC_EXIT=%d", exitCode))
+		return cmd
+	}
+}
+
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	// Check the command line arguments to see which command we're supposed to be.
+	args := os.Args
+	for len(args) > 0 {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "No command\n")
+		os.Exit(2)
+	}
+
+	cmd, args := args[0], args[1:]
+	if cmd == "rsync" {
+		fmt.Fprint(os.Stdout, os.Getenv("MOCK_RSYNC_OUTPUT"))
+		// Simulate rsync exiting with code 24 unless told otherwise
+		code := 24
+		if v := os.Getenv("MOCK_RSYNC_EXIT"); v != "" {
+			code, _ = strconv.Atoi(v)
+		}
+		os.Exit(code)
+	}
+	if cmd == "zfs" && args[0] == "get" {
+		fmt.Fprintln(os.Stdout, os.Getenv("MOCK_ZFS_MOUNTPOINT"))
+		os.Exit(0)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// recentFilesList returns an rsync exclude list, one pattern per line, of
+// the files in the sources modified less than minAge before now. rsync
+// can't filter on modification time, so the sources are walked to find them.
+func recentFilesList(sources []string, minAge time.Duration, now time.Time) string {
+	cutoff := now.Add(-minAge)
+	var list strings.Builder
+	for _, src := range sources {
+		anchor := sourceAnchor(src)
+		err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// Unreadable directories are rsync's to report.
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || !info.ModTime().After(cutoff) {
+				return nil
+			}
+			rel, err := filepath.Rel(src, path)
+			if err != nil {
+				return err
+			}
+			if strings.ContainsAny(rel, "\r\n") {
+				log.Warn().Str("path", path).Msg("Can't exclude recently modified file with a newline in its name")
+				return nil
+			}
+			if rel == "." {
+				// The source is itself a file.
+				rel = filepath.Base(src)
+				anchor = "/"
+			}
+			list.WriteString(anchor + escapeRsyncPattern(filepath.ToSlash(rel)) + "\n")
+			return nil
+		})
+		if err != nil {
+			log.Warn().Err(err).Str("source", src).Msg("Could not look for recently modified files")
+		}
+	}
+	return list.String()
+}
+
+// setRecentFilesExcludes feeds rsync the list of files too new to back up
+// under min_file_age. buildRsyncArgs tells rsync to read it from stdin.
+func setRecentFilesExcludes(cmd *exec.Cmd, config *Config) {
+	if config.MinFileAge == "" {
+		return
+	}
+	// validateConfig has already checked the duration.
+	minAge, _ := time.ParseDuration(config.MinFileAge)
+	cmd.Stdin = strings.NewReader(recentFilesList(config.Source, minAge, timeNow()))
+}
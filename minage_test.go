@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecentFilesExcludes(t *testing.T) {
+	sourceDir, err := os.MkdirTemp("", "goback-source")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(sourceDir)
+
+	now := time.Now()
+	for rel, age := range map[string]time.Duration{
+		"old.txt":           time.Hour,
+		"new.txt":           time.Minute,
+		"sub/new [1].txt":   30 * time.Second,
+		"sub/settled.txt":   11 * time.Minute,
+		"sub/deeper/a.part": 0,
+	} {
+		path := filepath.Join(sourceDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(rel), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		modTime := now.Add(-age)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+	}
+
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	config := &Config{Source: []string{sourceDir}, MinFileAge: "10m"}
+	if err := validateConfig(config); err != nil {
+		t.Fatalf("validateConfig failed: %v", err)
+	}
+	if args := buildRsyncArgs(config, "/dest", "", false); !hasArg(args, "--exclude-from=-") {
+		t.Errorf("Expected rsync to read excludes from stdin, got %v", args)
+	}
+
+	cmd := exec.Command("rsync")
+	setRecentFilesExcludes(cmd, config)
+	if cmd.Stdin == nil {
+		t.Fatal("Expected the exclude list on stdin")
+	}
+	got, err := io.ReadAll(cmd.Stdin)
+	if err != nil {
+		t.Fatalf("Failed to read stdin: %v", err)
+	}
+	base := "/" + filepath.Base(sourceDir) + "/"
+	want := base + "new.txt\n" + base + "sub/deeper/a.part\n" + base + `sub/new \[1].txt` + "\n"
+	if string(got) != want {
+		t.Errorf("Expected exclude list %q, got %q", want, got)
+	}
+
+	for _, bad := range []string{"soon", "-5m", "0s"} {
+		config.MinFileAge = bad
+		if err := validateConfig(config); err == nil {
+			t.Errorf("Expected min_file_age %q to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// namedSnapshotMarker is written into snapshots named with -name. Their
+// names carry no timestamp, so the marker is what identifies them as
+// goback's snapshots, and it records the snapshot prefix they belong to.
+const namedSnapshotMarker = ".goback-snapshot"
+
+// checkSnapshotName reports whether name can be used for a new snapshot.
+func checkSnapshotName(config *Config, name string) error {
+	if !validLabel.MatchString(name) {
+		return fmt.Errorf("invalid snapshot name %q: names must start with a letter or digit and contain only letters, digits, '.', '_' and '-'", name)
+	}
+	if config.Archive || nativeBackend(config) {
+		return fmt.Errorf("snapshots can only be named with the hardlink backend and without archive")
+	}
+	if _, err := os.Lstat(filepath.Join(config.Destination, name)); err == nil {
+		return fmt.Errorf("a snapshot named %s already exists", name)
+	}
+	return nil
+}
+
+func writeNamedSnapshotMarker(dir, prefix string) error {
+	if err := os.WriteFile(filepath.Join(dir, namedSnapshotMarker), []byte(prefix+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to mark named snapshot: %w", err)
+	}
+	return nil
+}
+
+// namedSnapshotPrefix returns the prefix recorded in the snapshot directory
+// dir, and false if dir isn't a named snapshot.
+func namedSnapshotPrefix(dir string) (string, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, namedSnapshotMarker))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSuffix(string(data), "\n"), true
+}
+
+// isNamedSnapshot reports whether the entry in dest is a snapshot named with
+// -name.
+func isNamedSnapshot(dest string, entry os.DirEntry) bool {
+	if !entry.IsDir() {
+		return false
+	}
+	_, named := namedSnapshotPrefix(filepath.Join(dest, entry.Name()))
+	return named
+}
+
+// belongsToConfig reports whether the snapshot called name was made by
+// config: a timestamped name matching pattern, from configNamePattern, or a
+// snapshot named with -name under config's prefix.
+func belongsToConfig(config *Config, pattern *regexp.Regexp, name string) bool {
+	if base, _ := trimArchiveSuffix(name); pattern.MatchString(base) {
+		return true
+	}
+	prefix, named := namedSnapshotPrefix(filepath.Join(snapshotsDir(config), name))
+	return named && prefix == config.SnapshotPrefix
+}
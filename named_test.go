@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunSnapshotBackupNamed(t *testing.T) {
+	assumeSourcesExist(t)
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, "", 0)
+	defer func() { execCommand = exec.CommandContext }()
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Source: []string{"/home/user"}, Keep: Keep{Daily: 1}}
+	if err := runSnapshotBackup(context.Background(), config, runOptions{Name: "pre-deploy"}, false); err != nil {
+		t.Fatalf("runSnapshotBackup failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "pre-deploy", namedSnapshotMarker)); err != nil {
+		t.Fatalf("Expected a named snapshot called pre-deploy, got %v", err)
+	}
+	if err := runSnapshotBackup(context.Background(), config, runOptions{Name: "pre-deploy"}, false); err == nil {
+		t.Error("Expected reusing a snapshot name to fail")
+	}
+
+	// A later timestamped snapshot makes the named one the oldest, which is
+	// purged by its modification time.
+	old := time.Now().AddDate(0, 0, -2)
+	if err := os.Chtimes(filepath.Join(tmpDir, "pre-deploy"), old, old); err != nil {
+		t.Fatalf("Failed to set mod time: %v", err)
+	}
+	if err := runSnapshotBackup(context.Background(), config, runOptions{}, false); err != nil {
+		t.Fatalf("runSnapshotBackup failed: %v", err)
+	}
+	snapshots, err := configSnapshots(context.Background(), config)
+	if err != nil {
+		t.Fatalf("configSnapshots failed: %v", err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Name() != "pre-deploy" {
+		t.Fatalf("Expected the named snapshot to be the oldest of 2, got %v", snapshots)
+	}
+	if !hasArg(gotArgs, "--link-dest="+filepath.Join(tmpDir, "pre-deploy")) {
+		t.Errorf("Expected the named snapshot to be used for --link-dest, got %v", gotArgs)
+	}
+
+	if err := purgeBackups(context.Background(), config, false); err != nil {
+		t.Fatalf("purgeBackups failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "pre-deploy")); !os.IsNotExist(err) {
+		t.Errorf("Expected the named snapshot to be purged, got %v", err)
+	}
+}
+
+func TestCheckSnapshotName(t *testing.T) {
+	config := &Config{Destination: "/nonexistent"}
+	for _, name := range []string{"", ".hidden", "a/b", "../up"} {
+		if err := checkSnapshotName(config, name); err == nil {
+			t.Errorf("Expected name %q to be refused", name)
+		}
+	}
+	if err := checkSnapshotName(config, "pre-deploy"); err != nil {
+		t.Errorf("Expected pre-deploy to be allowed, got %v", err)
+	}
+	if err := checkSnapshotName(&Config{Archive: true}, "pre-deploy"); err == nil {
+		t.Error("Expected naming an archive snapshot to be refused")
+	}
+}
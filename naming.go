@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultSnapshotName is the snapshot_name template used when none is
+// configured.
+const defaultSnapshotName = "%prefix%_%time%"
+
+// snapshotTimeChars matches a snapshot timestamp in either layout.
+const snapshotTimeChars = `\d{4}-\d{2}-\d{2}_\d{2}[:-]\d{2}[:-]\d{2}`
+
+// snapshotTimePattern finds the timestamp in a snapshot name wherever the
+// snapshot_name template put it. It has to stand alone between underscores,
+// the start or end of the name, or the "-" that starts a label.
+var snapshotTimePattern = regexp.MustCompile(`(?:^|_)(\d{4}-\d{2}-\d{2}_\d{2}([:-])\d{2}[:-]\d{2})(?:[_-]|$)`)
+
+var snapshotNameToken = regexp.MustCompile(`%[^%]*%`)
+
+// hostname returns the name of the machine; tests swap it.
+var hostname = os.Hostname
+
+// checkSnapshotNameTemplate reports whether tmpl is a usable snapshot_name:
+// it must contain %time% exactly once and no tokens other than %prefix%,
+// %host% and %time%.
+func checkSnapshotNameTemplate(tmpl string) error {
+	for _, token := range snapshotNameToken.FindAllString(tmpl, -1) {
+		if token != "%prefix%" && token != "%host%" && token != "%time%" {
+			return fmt.Errorf("invalid snapshot_name %q: unknown token %s", tmpl, token)
+		}
+	}
+	if strings.Count(tmpl, "%time%") != 1 {
+		return fmt.Errorf("invalid snapshot_name %q: must contain %%time%% exactly once", tmpl)
+	}
+	if strings.ContainsAny(tmpl, "/") || strings.HasPrefix(tmpl, ".") {
+		return fmt.Errorf("invalid snapshot_name %q: can't contain '/' or start with '.'", tmpl)
+	}
+	return nil
+}
+
+// snapshotNameParts expands the tokens other than %time% in the
+// snapshot_name template and returns what comes before and after the
+// timestamp.
+func snapshotNameParts(config *Config) (before, after string, err error) {
+	tmpl := config.SnapshotName
+	if tmpl == "" {
+		tmpl = defaultSnapshotName
+	}
+	if strings.Contains(tmpl, "%host%") {
+		host, err := hostname()
+		if err != nil {
+			return "", "", fmt.Errorf("failed to get hostname: %w", err)
+		}
+		tmpl = strings.ReplaceAll(tmpl, "%host%", host)
+	}
+	tmpl = strings.ReplaceAll(tmpl, "%prefix%", config.SnapshotPrefix)
+	before, after, _ = strings.Cut(tmpl, "%time%")
+	return before, after, nil
+}
+
+// snapshotKey identifies the snapshots of config among others in the
+// destination by the expanded snapshot_name without its timestamp, e.g.
+// "server" or "server_alpha". It names the files goback keeps per
+// configuration.
+func snapshotKey(config *Config) (string, error) {
+	before, after, err := snapshotNameParts(config)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(before+after, "_"), nil
+}
+
+// expandSnapshotName returns the name of a snapshot taken at t, formatting
+// the timestamp with layout.
+func expandSnapshotName(config *Config, t time.Time, layout string) (string, error) {
+	before, after, err := snapshotNameParts(config)
+	if err != nil {
+		return "", err
+	}
+	return before + t.Format(layout) + after, nil
+}
+
+// configNamePattern matches the timestamped snapshot names config's
+// snapshot_name produces, with the sequence number and label that may
+// follow. The timestamp, sequence number and label are submatches 1 to 3.
+func configNamePattern(config *Config) (*regexp.Regexp, error) {
+	before, after, err := snapshotNameParts(config)
+	if err != nil {
+		return nil, err
+	}
+	return regexp.Compile(`^` + regexp.QuoteMeta(before) + `(` + snapshotTimeChars + `)` + regexp.QuoteMeta(after) + `(?:_(\d+))?(?:-(` + labelChars + `))?$`)
+}
+
+// snapshotSeqPattern matches the sequence number, and any label, in what
+// follows the timestamp of a snapshot name. Whatever snapshot_name puts
+// after the timestamp comes first, the label starts at the first '-'.
+var snapshotSeqPattern = regexp.MustCompile(`^[^-]*?_(\d+)(?:-` + labelChars + `)?$`)
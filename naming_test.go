@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpandSnapshotName(t *testing.T) {
+	oldHostname := hostname
+	hostname = func() (string, error) { return "web-1", nil }
+	defer func() { hostname = oldHostname }()
+
+	when := time.Date(2025, time.March, 9, 14, 5, 30, 0, time.Local)
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"", "test_2025-03-09_14:05:30"},
+		{"%prefix%_%host%_%time%", "test_web-1_2025-03-09_14:05:30"},
+		{"%time%_%host%", "2025-03-09_14:05:30_web-1"},
+	}
+	for _, tt := range tests {
+		config := &Config{SnapshotPrefix: "test", SnapshotName: tt.template}
+		got, err := expandSnapshotName(config, when, snapshotTimeFormat)
+		if err != nil {
+			t.Fatalf("expandSnapshotName(%q) failed: %v", tt.template, err)
+		}
+		if got != tt.want {
+			t.Errorf("expandSnapshotName(%q) = %s, want %s", tt.template, got, tt.want)
+		}
+		parsed, ok := parseSnapshotTime(got)
+		if !ok || !parsed.Equal(when) {
+			t.Errorf("Expected %s to parse as %v, got %v (%v)", got, when, parsed, ok)
+		}
+	}
+
+	for _, bad := range []string{"%prefix%", "%time%_%time%", "%prefix%_%user%_%time%", "%host%/%time%"} {
+		if err := checkSnapshotNameTemplate(bad); err == nil {
+			t.Errorf("Expected snapshot_name %q to be rejected", bad)
+		}
+	}
+}
+
+func TestConfigSnapshotsWithHostname(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	oldHostname := hostname
+	hostname = func() (string, error) { return "web-1", nil }
+	defer func() { hostname = oldHostname }()
+
+	when := time.Now().AddDate(0, 0, -1).Truncate(time.Second)
+	stamp := when.Format(snapshotTimeFormat)
+	names := []string{
+		"test_web-1_" + stamp,
+		"test_web-1_" + stamp + "_2-before-upgrade",
+		"test_web-2_" + stamp,
+		"test_" + stamp,
+	}
+	for _, name := range names {
+		if err := os.Mkdir(filepath.Join(tmpDir, name), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", SnapshotName: "%prefix%_%host%_%time%"}
+	snapshots, err := configSnapshots(context.Background(), config)
+	if err != nil {
+		t.Fatalf("configSnapshots failed: %v", err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Name() != names[0] || snapshots[1].Name() != names[1] {
+		var got []string
+		for _, s := range snapshots {
+			got = append(got, s.Name())
+		}
+		t.Errorf("Expected only this host's snapshots %v, got %v", names[:2], got)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// liveDirName is the directory native backends rsync into. It is updated in
+// place by every run and snapshotted by the filesystem afterwards.
+const liveDirName = ".live"
+
+// nativeBackend reports whether snapshots are filesystem snapshots rather
+// than hard linked directory trees.
+func nativeBackend(config *Config) bool {
+	return config.SnapshotBackend == "btrfs" || config.SnapshotBackend == "zfs"
+}
+
+// snapshotsDir returns the directory the snapshots appear in. ZFS exposes
+// the snapshots of a dataset under .zfs/snapshot in its mountpoint.
+func snapshotsDir(config *Config) string {
+	if config.SnapshotBackend == "zfs" {
+		return filepath.Join(config.Destination, ".zfs", "snapshot")
+	}
+	return config.Destination
+}
+
+// snapshotContents returns the directory holding the files of snapshot. A
+// ZFS snapshot is of the whole dataset, so they are in its live directory.
+func snapshotContents(config *Config, snapshot string) string {
+	if config.SnapshotBackend == "zfs" {
+		return filepath.Join(snapshotsDir(config), snapshot, liveDirName)
+	}
+	return filepath.Join(snapshotsDir(config), snapshot)
+}
+
+// runCommand runs an external command, logging it and including its output
+// in the error if it fails.
+func runCommand(ctx context.Context, name string, args ...string) error {
+	log.Info().Str("command", name+" "+strings.Join(args, " ")).Msg("Running command")
+	out, err := execCommand(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %w: %s", name, args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// checkZFSMountpoint makes sure the destination is where zfs_dataset is
+// mounted. Otherwise the live directory wouldn't be in the dataset that is
+// snapshotted, and the snapshots wouldn't appear under .zfs/snapshot.
+func checkZFSMountpoint(ctx context.Context, config *Config) error {
+	out, err := execCommand(ctx, "zfs", "get", "-H", "-o", "value", "mountpoint", config.ZFSDataset).Output()
+	if err != nil {
+		return fmt.Errorf("failed to get mountpoint of %s: %w", config.ZFSDataset, err)
+	}
+	if mountpoint := strings.TrimSpace(string(out)); mountpoint != filepath.Clean(config.Destination) {
+		return fmt.Errorf("destination %s is not the mountpoint of zfs_dataset %s, which is %s", config.Destination, config.ZFSDataset, mountpoint)
+	}
+	return nil
+}
+
+// runNativeSnapshotBackup rsyncs the sources into the live directory and
+// then takes a read-only btrfs or zfs snapshot of it.
+func runNativeSnapshotBackup(ctx context.Context, config *Config, dryRun bool) error {
+	log.Info().Strs("source", config.Source).Str("destination", config.Destination).Str("backend", config.SnapshotBackend).Msg("Snapshot Backup")
+
+	if config.SnapshotBackend == "zfs" {
+		if err := checkZFSMountpoint(ctx, config); err != nil {
+			return err
+		}
+	}
+
+	liveDir := filepath.Join(config.Destination, liveDirName)
+	if _, err := os.Stat(liveDir); os.IsNotExist(err) {
+		if dryRun {
+			log.Info().Str("path", liveDir).Msg("[Dry Run] Would create live directory")
+		} else if config.SnapshotBackend == "btrfs" {
+			if err := runCommand(ctx, "btrfs", "subvolume", "create", liveDir); err != nil {
+				return err
+			}
+		} else if err := os.MkdirAll(liveDir, 0755); err != nil {
+			return fmt.Errorf("failed to create live directory: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to check live directory: %w", err)
+	}
+
+	name, err := expandSnapshotName(config, timeNow(), snapshotTimeFormat)
+	if err != nil {
+		return err
+	}
+	snapshotName := uniqueSnapshotName(config, name)
+	events.BackupStarted(BackupStartedEvent{Mode: "snapshot", Destination: config.Destination, Snapshot: snapshotName, RunID: runID})
+	stats, err := runRsync(ctx, config, liveDir, "", snapshotName, dryRun)
+	if err != nil {
+		return err
+	}
+	if config.ItemizeChanges {
+		log.Info().Int("changed_files", stats.ChangedFiles).Msg("Itemized changes recorded")
+	}
+	events.RsyncFinished(newRsyncFinishedEvent(snapshotName, stats))
+
+	if err := runCommandSources(ctx, config, liveDir, dryRun); err != nil {
+		return err
+	}
+
+	if dryRun {
+		log.Info().Str("path", liveDir).Str("snapshot", snapshotName).Msg("[Dry Run] Would take filesystem snapshot")
+		log.Info().Msg("Snapshot backup finished successfully")
+		return nil
+	}
+
+	// Retention goes by the modification time of the snapshot's root, which
+	// rsync doesn't necessarily touch, so stamp it with the backup time.
+	root := liveDir
+	if config.SnapshotBackend == "zfs" {
+		root = config.Destination
+	}
+	now := timeNow()
+	if err := os.Chtimes(root, now, now); err != nil {
+		return fmt.Errorf("failed to set snapshot time: %w", err)
+	}
+
+	if config.SnapshotBackend == "btrfs" {
+		err = runCommand(ctx, "btrfs", "subvolume", "snapshot", "-r", liveDir, filepath.Join(config.Destination, snapshotName))
+	} else {
+		err = runCommand(ctx, "zfs", "snapshot", config.ZFSDataset+"@"+snapshotName)
+	}
+	if err != nil {
+		return err
+	}
+	if err := updateLatestLink(config, snapshotName, false); err != nil {
+		log.Warn().Err(err).Msg("Could not update latest symlink")
+	}
+
+	log.Info().Msg("Snapshot backup finished successfully")
+	return nil
+}
+
+// removeSnapshot deletes a snapshot using the configured backend.
+func removeSnapshot(ctx context.Context, config *Config, name string) error {
+	switch config.SnapshotBackend {
+	case "btrfs":
+		return runCommand(ctx, "btrfs", "subvolume", "delete", filepath.Join(config.Destination, name))
+	case "zfs":
+		return runCommand(ctx, "zfs", "destroy", config.ZFSDataset+"@"+name)
+	default:
+		return removeAll(filepath.Join(config.Destination, name))
+	}
+}
+
+// checkRemovable makes sure removeSnapshot can delete snapshots of config,
+// by checking that the directory holding them is writable. ZFS snapshots
+// are destroyed through the dataset rather than by changing a directory, so
+// there is nothing to check for them.
+func checkRemovable(config *Config) error {
+	if config.SnapshotBackend == "zfs" {
+		return nil
+	}
+	return checkWritable(config.Destination)
+}
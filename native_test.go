@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSnapshotCommands returns an execCommand replacement that records every
+// command line and creates the directories btrfs would create, so the rest
+// of the backup can proceed.
+func fakeSnapshotCommands(calls *[]string) func(context.Context, string, ...string) *exec.Cmd {
+	return func(ctx context.Context, command string, args ...string) *exec.Cmd {
+		*calls = append(*calls, command+" "+strings.Join(args, " "))
+		if command == "btrfs" && (args[1] == "create" || args[1] == "snapshot") {
+			os.MkdirAll(args[len(args)-1], 0755) //nolint:errcheck
+		}
+		cmd := mockExecCommand(ctx, command, args...)
+		cmd.Env = append(cmd.Env, "MOCK_RSYNC_EXIT=0")
+		return cmd
+	}
+}
+
+func TestRunSnapshotBackupBtrfs(t *testing.T) {
+	assumeSourcesExist(t)
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var calls []string
+	execCommand = fakeSnapshotCommands(&calls)
+	defer func() { execCommand = exec.CommandContext }()
+	now := time.Date(2025, time.June, 28, 10, 0, 0, 0, time.Local)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Source: []string{"/home/user"}, SnapshotBackend: "btrfs"}
+	if err := runSnapshotBackup(context.Background(), config, runOptions{}, false); err != nil {
+		t.Fatalf("runSnapshotBackup failed: %v", err)
+	}
+
+	liveDir := filepath.Join(tmpDir, liveDirName)
+	snapshot := filepath.Join(tmpDir, "test_"+now.Format(snapshotTimeFormat))
+	if len(calls) != 3 {
+		t.Fatalf("Expected 3 commands, got %q", calls)
+	}
+	if want := "btrfs subvolume create " + liveDir; calls[0] != want {
+		t.Errorf("Expected %q, got %q", want, calls[0])
+	}
+	if !strings.HasPrefix(calls[1], "rsync ") || !strings.HasSuffix(calls[1], " "+liveDir) || strings.Contains(calls[1], "--link-dest") {
+		t.Errorf("Expected rsync into the live directory without --link-dest, got %q", calls[1])
+	}
+	if want := "btrfs subvolume snapshot -r " + liveDir + " " + snapshot; calls[2] != want {
+		t.Errorf("Expected %q, got %q", want, calls[2])
+	}
+	info, err := os.Stat(liveDir)
+	if err != nil {
+		t.Fatalf("Failed to stat live directory: %v", err)
+	}
+	if !info.ModTime().Equal(now) {
+		t.Errorf("Expected the live directory to be stamped with %v, got %v", now, info.ModTime())
+	}
+}
+
+func TestPurgeBackupsNativeBackends(t *testing.T) {
+	for _, backend := range []string{"btrfs", "zfs"} {
+		t.Run(backend, func(t *testing.T) {
+			tmpDir, err := os.MkdirTemp("", "goback-test")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			config := &Config{Destination: tmpDir, SnapshotPrefix: "test", SnapshotBackend: backend, ZFSDataset: "tank/backups", Keep: Keep{Daily: 1}}
+			now := time.Now()
+			var names []string
+			for i := 0; i < 2; i++ {
+				modTime := now.AddDate(0, 0, -i)
+				name := "test_" + modTime.Format(snapshotTimeFormat)
+				names = append(names, name)
+				path := filepath.Join(snapshotsDir(config), name)
+				if err := os.MkdirAll(path, 0755); err != nil {
+					t.Fatalf("Failed to create dir: %v", err)
+				}
+				if err := os.Chtimes(path, modTime, modTime); err != nil {
+					t.Fatalf("Failed to set mod time: %v", err)
+				}
+			}
+
+			var calls []string
+			execCommand = fakeSnapshotCommands(&calls)
+			defer func() { execCommand = exec.CommandContext }()
+			var probed []string
+			createProbe = func(dir, pattern string) (*os.File, error) {
+				probed = append(probed, dir)
+				return os.CreateTemp(dir, pattern)
+			}
+			defer func() { createProbe = os.CreateTemp }()
+
+			if err := purgeBackups(context.Background(), config, false); err != nil {
+				t.Fatalf("purgeBackups failed: %v", err)
+			}
+
+			want := "btrfs subvolume delete " + filepath.Join(tmpDir, names[1])
+			if backend == "zfs" {
+				want = "zfs destroy tank/backups@" + names[1]
+			}
+			if len(calls) != 1 || calls[0] != want {
+				t.Errorf("Expected only %q, got %q", want, calls)
+			}
+			// btrfs deletes the subvolume from the destination, while ZFS
+			// destroys it through the dataset.
+			wantProbed := []string{tmpDir}
+			if backend == "zfs" {
+				wantProbed = nil
+			}
+			if strings.Join(probed, "|") != strings.Join(wantProbed, "|") {
+				t.Errorf("Expected %q to be checked for writability, got %q", wantProbed, probed)
+			}
+		})
+	}
+}
+
+func TestValidateConfigSnapshotBackend(t *testing.T) {
+	valid := []*Config{
+		{SnapshotBackend: "hardlink"},
+		{SnapshotBackend: "btrfs"},
+		{SnapshotBackend: "zfs", ZFSDataset: "tank/backups"},
+	}
+	for _, c := range valid {
+		if err := validateConfig(c); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", c, err)
+		}
+	}
+	invalid := []*Config{
+		{SnapshotBackend: "lvm"},
+		{SnapshotBackend: "zfs"},
+		{SnapshotBackend: "btrfs", Mode: "simple"},
+		{SnapshotBackend: "btrfs", Archive: true},
+	}
+	for _, c := range invalid {
+		if err := validateConfig(c); err == nil {
+			t.Errorf("Expected %+v to be invalid", c)
+		}
+	}
+}
+
+func TestCheckZFSMountpoint(t *testing.T) {
+	config := &Config{Destination: "/tank/backups/", SnapshotBackend: "zfs", ZFSDataset: "tank/backups"}
+	for mountpoint, ok := range map[string]bool{"/tank/backups": true, "/mnt/elsewhere": false} {
+		execCommand = func(ctx context.Context, command string, args ...string) *exec.Cmd {
+			cmd := mockExecCommand(ctx, command, args...)
+			cmd.Env = append(cmd.Env, "MOCK_ZFS_MOUNTPOINT="+mountpoint)
+			return cmd
+		}
+		if err := checkZFSMountpoint(context.Background(), config); (err == nil) != ok {
+			t.Errorf("For mountpoint %s expected ok=%v, got %v", mountpoint, ok, err)
+		}
+	}
+	execCommand = exec.CommandContext
+}
+
+func TestLabelSnapshotZFS(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", SnapshotBackend: "zfs", ZFSDataset: "tank/backups"}
+	name := "test_2025-06-28_10:00:00"
+	if err := os.MkdirAll(filepath.Join(snapshotsDir(config), name), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+
+	var calls []string
+	execCommand = fakeSnapshotCommands(&calls)
+	defer func() { execCommand = exec.CommandContext }()
+
+	if _, err := labelSnapshot(context.Background(), config, name, "keep", false); err != nil {
+		t.Fatalf("labelSnapshot failed: %v", err)
+	}
+	if want := "zfs rename tank/backups@" + name + " tank/backups@" + name + "-keep"; len(calls) != 1 || calls[0] != want {
+		t.Errorf("Expected only %q, got %q", want, calls)
+	}
+	if resolved, err := resolveLinkDest(context.Background(), config); err != nil || resolved != filepath.Join(snapshotsDir(config), name) {
+		t.Errorf("Expected link-dest in %s, got %q, %v", snapshotsDir(config), resolved, err)
+	}
+}
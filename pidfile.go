@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// errAlreadyRunning is returned by acquirePidFile when a live process holds
+// the PID file.
+var errAlreadyRunning = errors.New("another goback process is already running")
+
+// pidFileName is the name of the single instance guard in the destination.
+// In simple mode rsync is told to leave it alone; see buildRsyncArgs.
+const pidFileName = "goback.pid"
+
+// pidFilePath returns where the single instance guard for config lives.
+func pidFilePath(config *Config) string {
+	return filepath.Join(config.Destination, pidFileName)
+}
+
+// acquirePidFile writes the current PID to path, failing with
+// errAlreadyRunning if the file names a process that is still alive. A file
+// left behind by a process that has exited is taken over. The returned
+// function removes the file again.
+//
+// The PID is written to a temporary file that is then linked into place, so
+// another process never sees the file without a PID in it. A stale file is
+// only removed while holding a lock on it, after checking that it is still
+// the file at path, so two processes taking it over at once can't remove
+// the file the other one just linked into place.
+func acquirePidFile(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create PID file directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to write PID file: %w", err)
+	}
+	//nolint:errcheck
+	defer os.Remove(tmp.Name())
+	_, err = fmt.Fprintf(tmp, "%d\n", os.Getpid())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write PID file: %w", err)
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		err := os.Link(tmp.Name(), path)
+		if err == nil {
+			return func() {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					log.Error().Err(err).Str("path", path).Msg("Failed to remove PID file")
+				}
+			}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create PID file: %w", err)
+		}
+		if err := removeStalePidFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("failed to acquire PID file %s", path)
+}
+
+// processAlive checks the process a PID file names; tests swap it to hold up
+// a run between reading a stale file and removing it.
+var processAlive = pidAlive
+
+// removeStalePidFile removes the PID file at path unless it names a live
+// process, in which case it returns errAlreadyRunning. It returns nil
+// without removing anything if the file is replaced or removed while it
+// waits for the lock, so that the caller tries again.
+func removeStalePidFile(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open PID file: %w", err)
+	}
+	//nolint:errcheck
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock PID file: %w", err)
+	}
+	locked, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat PID file: %w", err)
+	}
+	if current, err := os.Stat(path); err != nil || !os.SameFile(locked, current) {
+		return nil
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("failed to read PID file: %w", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err == nil && processAlive(pid) {
+		return fmt.Errorf("%w (pid %d, %s)", errAlreadyRunning, pid, path)
+	}
+	log.Warn().Str("path", path).Msg("Removing stale PID file")
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale PID file: %w", err)
+	}
+	return nil
+}
+
+// releaseOnFatal returns a logger hook that calls release before a fatal
+// message exits the process, which skips deferred calls.
+func releaseOnFatal(release func()) zerolog.Hook {
+	return zerolog.HookFunc(func(e *zerolog.Event, level zerolog.Level, msg string) {
+		if level == zerolog.FatalLevel {
+			release()
+		}
+	})
+}
+
+// lockRetryInterval is how long waitForPidFile first waits before trying
+// again. The wait doubles with every attempt, up to lockRetryMax.
+var (
+	lockRetryInterval = time.Second
+	lockRetryMax      = 30 * time.Second
+)
+
+// waitForPidFile is acquirePidFile, but while another process holds the
+// file it keeps trying, backing off between attempts, for up to wait.
+func waitForPidFile(ctx context.Context, path string, wait time.Duration) (func(), error) {
+	deadline := timeNow().Add(wait)
+	interval := lockRetryInterval
+	for {
+		release, err := acquirePidFile(path)
+		if !errors.Is(err, errAlreadyRunning) {
+			return release, err
+		}
+		remaining := deadline.Sub(timeNow())
+		if remaining <= 0 {
+			return nil, err
+		}
+		sleep := min(interval, remaining)
+		log.Info().Err(err).Str("retry_in", sleep.String()).Msg("Waiting for the other run to finish")
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(sleep):
+		}
+		interval = min(2*interval, lockRetryMax)
+	}
+}
+
+func readPidFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// pidAlive reports whether a process with the given PID exists. A process we
+// aren't allowed to signal still counts as alive.
+func pidAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// exitedPid returns the PID of a process that has already exited.
+func exitedPid(t *testing.T) int {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to run helper process: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestPidAlive(t *testing.T) {
+	if !pidAlive(os.Getpid()) {
+		t.Error("Expected the current process to be alive")
+	}
+	if pidAlive(exitedPid(t)) {
+		t.Error("Expected an exited process not to be alive")
+	}
+	if pidAlive(0) {
+		t.Error("Expected PID 0 not to be treated as alive")
+	}
+}
+
+func TestAcquirePidFile(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "goback.pid")
+
+	// A live process holds the file.
+	if err := os.WriteFile(path, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+		t.Fatalf("Failed to write PID file: %v", err)
+	}
+	if _, err := acquirePidFile(path); !errors.Is(err, errAlreadyRunning) {
+		t.Errorf("Expected errAlreadyRunning for a live PID, got %v", err)
+	}
+
+	// A stale file is taken over.
+	if err := os.WriteFile(path, []byte(fmt.Sprintf("%d\n", exitedPid(t))), 0644); err != nil {
+		t.Fatalf("Failed to write PID file: %v", err)
+	}
+	release, err := acquirePidFile(path)
+	if err != nil {
+		t.Fatalf("Expected to take over a stale PID file, got %v", err)
+	}
+	pid, err := readPidFile(path)
+	if err != nil || pid != os.Getpid() {
+		t.Errorf("Expected PID file to hold %d, got %d (%v)", os.Getpid(), pid, err)
+	}
+
+	// The PID is linked into place from a temporary file, which is gone.
+	if matches, _ := filepath.Glob(path + ".*.tmp"); len(matches) != 0 {
+		t.Errorf("Expected no temporary PID files, got %v", matches)
+	}
+
+	release()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected PID file to be removed on release, got %v", err)
+	}
+}
+
+func TestAcquireStalePidFileConcurrently(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "goback.pid")
+	stale := fmt.Sprintf("%d\n", exitedPid(t))
+
+	// Hold every run up after it has read the file, so that they all find
+	// it stale before any of them takes it over.
+	defer func(orig func(int) bool) { processAlive = orig }(processAlive)
+	processAlive = func(pid int) bool {
+		time.Sleep(10 * time.Millisecond)
+		return pidAlive(pid)
+	}
+
+	// Runs that all find the same stale file must not end up holding it
+	// together.
+	for round := 0; round < 10; round++ {
+		if err := os.WriteFile(path, []byte(stale), 0644); err != nil {
+			t.Fatalf("Failed to write PID file: %v", err)
+		}
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		releases := make([]func(), 4)
+		errs := make([]error, 4)
+		for i := range releases {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				releases[i], errs[i] = acquirePidFile(path)
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		acquired := 0
+		for i, err := range errs {
+			if err == nil {
+				acquired++
+				releases[i]()
+			} else if !errors.Is(err, errAlreadyRunning) {
+				t.Fatalf("Round %d: expected errAlreadyRunning, got %v", round, err)
+			}
+		}
+		if acquired != 1 {
+			t.Fatalf("Round %d: expected exactly one run to acquire the PID file, got %d", round, acquired)
+		}
+	}
+}
+
+func TestWaitForPidFile(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "goback.pid")
+
+	oldInterval := lockRetryInterval
+	lockRetryInterval = 10 * time.Millisecond
+	defer func() { lockRetryInterval = oldInterval }()
+
+	if err := os.WriteFile(path, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+		t.Fatalf("Failed to write PID file: %v", err)
+	}
+
+	// Without a wait, a held lock fails at once.
+	if _, err := waitForPidFile(context.Background(), path, 0); !errors.Is(err, errAlreadyRunning) {
+		t.Errorf("Expected errAlreadyRunning without a wait, got %v", err)
+	}
+
+	// The other run finishes while we wait.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		os.Remove(path)
+	}()
+	release, err := waitForPidFile(context.Background(), path, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Expected to acquire the PID file once released, got %v", err)
+	}
+	release()
+
+	// A lock held for longer than the wait still fails.
+	if err := os.WriteFile(path, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+		t.Fatalf("Failed to write PID file: %v", err)
+	}
+	if _, err := waitForPidFile(context.Background(), path, 50*time.Millisecond); !errors.Is(err, errAlreadyRunning) {
+		t.Errorf("Expected errAlreadyRunning after the wait, got %v", err)
+	}
+}
+
+func TestPidFilePerDestination(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	oldInterval := lockRetryInterval
+	lockRetryInterval = 10 * time.Millisecond
+	defer func() { lockRetryInterval = oldInterval }()
+
+	shared := &Config{Destination: filepath.Join(tmpDir, "shared")}
+	other := &Config{Destination: filepath.Join(tmpDir, "other")}
+
+	release, err := acquirePidFile(pidFilePath(shared))
+	if err != nil {
+		t.Fatalf("Failed to acquire PID file: %v", err)
+	}
+
+	// A run with a different destination isn't held up.
+	releaseOther, err := acquirePidFile(pidFilePath(other))
+	if err != nil {
+		t.Fatalf("Expected a different destination to run concurrently, got %v", err)
+	}
+	releaseOther()
+
+	// A second run with the same destination waits for the first to finish.
+	released := make(chan time.Time, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		released <- time.Now()
+		release()
+	}()
+	releaseSecond, err := waitForPidFile(context.Background(), pidFilePath(shared), 5*time.Second)
+	acquired := time.Now()
+	if err != nil {
+		t.Fatalf("Expected the second run to acquire the PID file, got %v", err)
+	}
+	defer releaseSecond()
+	if releasedAt := <-released; acquired.Before(releasedAt) {
+		t.Errorf("Expected the second run to start after the first finished")
+	}
+}
+
+func TestPidFileProtectedInSimpleMode(t *testing.T) {
+	protect := "--filter=P /" + pidFileName
+	if args := buildRsyncArgs(&Config{Mode: "simple", PidFile: true}, "/dest", "", false); !hasArg(args, protect) {
+		t.Errorf("Expected %s in simple mode rsync args, got %v", protect, args)
+	}
+	if args := buildRsyncArgs(&Config{PidFile: true}, "/dest/snap", "", false); hasArg(args, protect) {
+		t.Errorf("Expected no %s in snapshot mode, got %v", protect, args)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// rsyncCommandLine returns the rsync command line a backup with config would
+// run, quoted so it can be pasted into a shell.
+func rsyncCommandLine(ctx context.Context, config *Config, dryRun bool) (string, error) {
+	destDir := config.Destination
+	linkDest := ""
+	switch {
+	case config.Mode == "simple":
+	case nativeBackend(config):
+		destDir = filepath.Join(config.Destination, liveDirName)
+	default:
+		destDir = filepath.Join(config.Destination, ".unfinished")
+		var err error
+		if linkDest, err = resolveLinkDest(ctx, config); err != nil {
+			return "", err
+		}
+	}
+
+	name, args := withPriority(config, "rsync", buildRsyncArgs(config, destDir, linkDest, dryRun))
+	words := []string{name}
+	for _, arg := range args {
+		// A backup writes the min_file_age list to rsync's stdin. Pasted,
+		// the command would wait for it there, so it is spelled out.
+		if arg == "--exclude-from=-" {
+			// validateConfig has already checked the duration.
+			minAge, _ := time.ParseDuration(config.MinFileAge)
+			for _, pattern := range strings.Split(recentFilesList(config.Source, minAge, timeNow()), "\n") {
+				if pattern != "" {
+					words = append(words, shellQuote("--exclude="+pattern))
+				}
+			}
+			continue
+		}
+		words = append(words, shellQuote(arg))
+	}
+	return strings.Join(words, " "), nil
+}
+
+// shellSafe matches arguments a POSIX shell passes through unchanged.
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes s for a POSIX shell. Single quotes are closed, escaped
+// and reopened, since nothing can be escaped inside them.
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
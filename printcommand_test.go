@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRsyncCommandLine(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	latest := "test_" + time.Date(2025, time.June, 28, 10, 0, 0, 0, time.Local).Format(snapshotTimeFormat)
+	if err := os.Mkdir(filepath.Join(tmpDir, latest), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+
+	config := &Config{
+		Destination:    tmpDir,
+		SnapshotPrefix: "test",
+		Source:         []string{"/home/user"},
+		Exclude:        []string{"My Documents/", "it's"},
+	}
+	got, err := rsyncCommandLine(context.Background(), config, false)
+	if err != nil {
+		t.Fatalf("rsyncCommandLine failed: %v", err)
+	}
+
+	want := "rsync -a -v -h --delete --stats --inplace" +
+		" --link-dest=" + filepath.Join(tmpDir, latest) +
+		" '--exclude=My Documents/' '--exclude=it'\\''s'" +
+		" /home/user " + filepath.Join(tmpDir, ".unfinished")
+	if got != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+
+	// The min_file_age list a backup feeds rsync on stdin is spelled out,
+	// so the pasted command doesn't wait for input.
+	src := filepath.Join(tmpDir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "new file.txt"), []byte("x"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	config = &Config{Destination: tmpDir, SnapshotPrefix: "test", Source: []string{src + "/"}, MinFileAge: "1h"}
+	got, err = rsyncCommandLine(context.Background(), config, false)
+	if err != nil {
+		t.Fatalf("rsyncCommandLine failed: %v", err)
+	}
+	if strings.Contains(got, "--exclude-from=-") || !strings.Contains(got, "'--exclude=/new file.txt'") {
+		t.Errorf("Expected the recent file to be excluded inline, got:\n%s", got)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"--delete":          "--delete",
+		"/mnt/backups":      "/mnt/backups",
+		"a b":               "'a b'",
+		"*.log":             "'*.log'",
+		"it's":              `'it'\''s'`,
+		"":                  "''",
+		"$HOME":             "'$HOME'",
+		"--exclude=a;b":     "'--exclude=a;b'",
+		"--chmod=Du+rwx,Fo": "--chmod=Du+rwx,Fo",
+	}
+	for in, want := range tests {
+		if got := shellQuote(in); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
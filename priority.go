@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ioniceClasses maps the ionice scheduling class names to their numbers.
+var ioniceClasses = map[string]string{
+	"realtime":    "1",
+	"best-effort": "2",
+	"idle":        "3",
+}
+
+// parseIonice splits an ionice setting like "best-effort:7" into its class
+// number and priority level, which is "" if none was given.
+func parseIonice(s string) (class, level string, err error) {
+	name, level, hasLevel := strings.Cut(s, ":")
+	class, ok := ioniceClasses[name]
+	if !ok {
+		return "", "", fmt.Errorf("invalid ionice class %q: must be \"realtime\", \"best-effort\" or \"idle\"", name)
+	}
+	if hasLevel {
+		if name == "idle" {
+			return "", "", fmt.Errorf("ionice class idle takes no priority level")
+		}
+		if n, err := strconv.Atoi(level); err != nil || n < 0 || n > 7 {
+			return "", "", fmt.Errorf("invalid ionice priority level %q: must be 0-7", level)
+		}
+	}
+	return class, level, nil
+}
+
+// withPriority wraps the command name with args in nice and ionice as
+// configured, returning the command to run and its arguments.
+func withPriority(config *Config, name string, args []string) (string, []string) {
+	argv := append([]string{name}, args...)
+	if config.Nice != 0 {
+		argv = append([]string{"nice", "-n", strconv.Itoa(config.Nice)}, argv...)
+	}
+	if config.Ionice != "" {
+		// validateConfig has already checked the setting.
+		class, level, _ := parseIonice(config.Ionice)
+		prefix := []string{"ionice", "-c", class}
+		if level != "" {
+			prefix = append(prefix, "-n", level)
+		}
+		argv = append(prefix, argv...)
+	}
+	return argv[0], argv[1:]
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWithPriority(t *testing.T) {
+	tests := []struct {
+		nice   int
+		ionice string
+		want   string
+	}{
+		{0, "", "rsync -a /src /dest"},
+		{10, "", "nice -n 10 rsync -a /src /dest"},
+		{0, "idle", "ionice -c 3 rsync -a /src /dest"},
+		{19, "best-effort:7", "ionice -c 2 -n 7 nice -n 19 rsync -a /src /dest"},
+		{-5, "realtime:0", "ionice -c 1 -n 0 nice -n -5 rsync -a /src /dest"},
+	}
+	for _, tc := range tests {
+		config := &Config{Nice: tc.nice, Ionice: tc.ionice}
+		name, args := withPriority(config, "rsync", []string{"-a", "/src", "/dest"})
+		if got := strings.Join(append([]string{name}, args...), " "); got != tc.want {
+			t.Errorf("nice %d, ionice %q: expected %q, got %q", tc.nice, tc.ionice, tc.want, got)
+		}
+	}
+}
+
+func TestValidateConfigPriority(t *testing.T) {
+	for _, c := range []*Config{{Nice: 19}, {Nice: -20}, {Ionice: "idle"}, {Ionice: "best-effort"}, {Ionice: "best-effort:4"}} {
+		if err := validateConfig(c); err != nil {
+			t.Errorf("Expected nice %d, ionice %q to be valid, got %v", c.Nice, c.Ionice, err)
+		}
+	}
+	for _, c := range []*Config{{Nice: 20}, {Nice: -21}, {Ionice: "low"}, {Ionice: "idle:3"}, {Ionice: "best-effort:8"}, {Ionice: "realtime:x"}} {
+		if err := validateConfig(c); err == nil {
+			t.Errorf("Expected nice %d, ionice %q to be rejected", c.Nice, c.Ionice)
+		}
+	}
+	if class, level, _ := parseIonice("best-effort:4"); !slices.Equal([]string{class, level}, []string{"2", "4"}) {
+		t.Errorf("Unexpected class %q and level %q", class, level)
+	}
+}
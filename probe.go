@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// errDestinationUnreachable is returned by probeDestination when the
+// destination doesn't answer in time, as a hung network mount doesn't.
+var errDestinationUnreachable = errors.New("destination unreachable")
+
+// destinationProbeTimeout bounds how long probeDestination waits.
+var destinationProbeTimeout = 10 * time.Second
+
+// probeStat stats the destination for probeDestination; tests swap it to
+// simulate a mount that hangs.
+var probeStat = os.Stat
+
+// probeDestination stats the destination, failing with
+// errDestinationUnreachable if that takes longer than
+// destinationProbeTimeout, so a network mount that is down stops the run
+// with a clear message instead of hanging it. A destination that doesn't
+// exist yet is fine; the backup creates it.
+func probeDestination(ctx context.Context, config *Config) error {
+	if isRsyncDaemon(config.Destination) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, destinationProbeTimeout)
+	defer cancel()
+
+	// A stat stuck in the kernel can't be interrupted; the goroutine is
+	// abandoned and the run ends soon after.
+	done := make(chan error, 1)
+	go func() {
+		_, err := probeStat(config.Destination)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to stat destination %s: %w", config.Destination, err)
+		}
+		return nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %s did not respond within %s; check that it is mounted and the server is up", errDestinationUnreachable, config.Destination, destinationProbeTimeout)
+		}
+		return ctx.Err()
+	}
+}
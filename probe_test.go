@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestProbeDestination(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := probeDestination(context.Background(), &Config{Destination: tmpDir}); err != nil {
+		t.Errorf("Expected a local destination to be reachable, got %v", err)
+	}
+	if err := probeDestination(context.Background(), &Config{Destination: tmpDir + "/missing"}); err != nil {
+		t.Errorf("Expected a missing destination to be left to the backup, got %v", err)
+	}
+
+	// A hung mount never answers.
+	hang := make(chan struct{})
+	defer close(hang)
+	oldStat, oldTimeout := probeStat, destinationProbeTimeout
+	probeStat = func(string) (os.FileInfo, error) {
+		<-hang
+		return nil, errors.New("unreachable")
+	}
+	destinationProbeTimeout = 50 * time.Millisecond
+	defer func() { probeStat, destinationProbeTimeout = oldStat, oldTimeout }()
+
+	start := time.Now()
+	err = probeDestination(context.Background(), &Config{Destination: tmpDir})
+	if !errors.Is(err, errDestinationUnreachable) {
+		t.Errorf("Expected errDestinationUnreachable, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the probe to give up after its timeout, took %s", elapsed)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// progress2Line matches the overall progress rsync prints with
+// --info=progress2, e.g.
+// "  1,238,099,136  99%   31.50MB/s    0:00:37 (xfr#1563, to-chk=0/2029)".
+// The counts in parentheses are only printed once a file has been
+// transferred.
+var progress2Line = regexp.MustCompile(`^\s*([\d.,]+[KMGT]?)\s+(\d+)%\s+([\d.,]+)([kMGT]?B)/s\s+(\d+):(\d{2}):(\d{2})(?:\s+\(xfr#(\d+), (?:ir|to)-chk=(\d+)/(\d+)\))?\s*$`)
+
+// rateUnits are the multipliers of the units rsync prints transfer rates in.
+var rateUnits = map[string]float64{"B": 1, "kB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30, "TB": 1 << 40}
+
+// parseProgress2 parses a line of --info=progress2 output. While rsync is
+// still building the file list with incremental recursion (ir-chk), the
+// percentage covers only the files found so far.
+func parseProgress2(line string) (RsyncProgressEvent, bool) {
+	m := progress2Line.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+	if m == nil {
+		return RsyncProgressEvent{}, false
+	}
+	var ev RsyncProgressEvent
+	var err error
+	if ev.Bytes, err = parseRsyncNumber(m[1]); err != nil {
+		return RsyncProgressEvent{}, false
+	}
+	ev.Percent, _ = strconv.Atoi(m[2])
+	rate, err := strconv.ParseFloat(strings.ReplaceAll(m[3], ",", ""), 64)
+	if err != nil {
+		return RsyncProgressEvent{}, false
+	}
+	ev.Rate = rate * rateUnits[m[4]]
+	hours, _ := strconv.Atoi(m[5])
+	minutes, _ := strconv.Atoi(m[6])
+	seconds, _ := strconv.Atoi(m[7])
+	ev.ETA = time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
+	if m[8] != "" {
+		ev.FilesTransferred, _ = strconv.ParseInt(m[8], 10, 64)
+		ev.FilesToCheck, _ = strconv.ParseInt(m[9], 10, 64)
+		ev.FilesTotal, _ = strconv.ParseInt(m[10], 10, 64)
+	}
+	ev.RunID = runID
+	return ev, true
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseProgress2(t *testing.T) {
+	tests := []struct {
+		line string
+		want RsyncProgressEvent
+	}{
+		{
+			"      1,238,099,136  99%   31.50MB/s    0:00:37 (xfr#1563, to-chk=0/2029)\n",
+			RsyncProgressEvent{Bytes: 1238099136, Percent: 99, Rate: 31.50 * (1 << 20), ETA: 37 * time.Second,
+				FilesTransferred: 1563, FilesToCheck: 0, FilesTotal: 2029},
+		},
+		{
+			"          1.24G  45%  512.00kB/s    1:02:03 (xfr#12, ir-chk=1000/2000)\r",
+			RsyncProgressEvent{Bytes: 1240000000, Percent: 45, Rate: 512 * (1 << 10), ETA: time.Hour + 2*time.Minute + 3*time.Second,
+				FilesTransferred: 12, FilesToCheck: 1000, FilesTotal: 2000},
+		},
+		{
+			"              0   0%    0.00kB/s    0:00:00  \r",
+			RsyncProgressEvent{},
+		},
+	}
+	for _, tt := range tests {
+		got, ok := parseProgress2(tt.line)
+		if !ok {
+			t.Errorf("parseProgress2(%q) didn't match", tt.line)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseProgress2(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+
+	for _, line := range []string{
+		">f+++++++++ home/user/99% done.txt\n",
+		"Number of files: 45 (reg: 40, dir: 5)\n",
+		"sent 1.23K bytes  received 35 bytes  2.53K bytes/sec\n",
+	} {
+		if _, ok := parseProgress2(line); ok {
+			t.Errorf("parseProgress2(%q) matched", line)
+		}
+	}
+}
+
+func TestRsyncOutputWriterProgress(t *testing.T) {
+	r := &recordingEvents{}
+	events = r
+	defer func() { events = noopEvents{} }()
+
+	var out bytes.Buffer
+	w := &rsyncOutputWriter{out: &out}
+	output := "sending incremental file list\n" +
+		"          1.00M  10%    1.00MB/s    0:00:09\r" +
+		"          5.00M  50%    2.00MB/s    0:00:05 (xfr#1, to-chk=1/2)\r" +
+		"         10.00M 100%    2.00MB/s    0:00:05 (xfr#2, to-chk=0/2)\n" +
+		"Number of regular files transferred: 2\n"
+	if _, err := w.Write([]byte(output)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	if len(r.progress) != 3 {
+		t.Fatalf("Expected 3 progress events, got %+v", r.progress)
+	}
+	for i, percent := range []int{10, 50, 100} {
+		if r.progress[i].Percent != percent {
+			t.Errorf("Event %d: expected %d%%, got %d%%", i, percent, r.progress[i].Percent)
+		}
+	}
+	if w.stats.FilesTransferred != 2 {
+		t.Errorf("Expected stats to still be parsed, got %+v", w.stats)
+	}
+	if out.String() != output {
+		t.Errorf("Expected the output to be passed through unchanged, got %q", out.String())
+	}
+}
+
+func TestBuildRsyncArgsProgress(t *testing.T) {
+	config := &Config{Progress: true}
+	if args := buildRsyncArgs(config, "/tmp/dest", "", false); !hasArg(args, "--info=progress2") {
+		t.Errorf("Expected --info=progress2, got %v", args)
+	}
+	if args := buildRsyncArgs(config, "/tmp/dest", "", true); hasArg(args, "--info=progress2") {
+		t.Errorf("Expected no progress in a dry run, got %v", args)
+	}
+}
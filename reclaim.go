@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+var statfs = syscall.Statfs
+
+// reclaimTarget is the amount of free space -reclaim-to should reach, either
+// as a percentage of the volume or as an absolute number of bytes.
+type reclaimTarget struct {
+	percent float64
+	bytes   uint64
+}
+
+// parseReclaimTarget parses "20%" or a size such as "50G".
+func parseReclaimTarget(s string) (reclaimTarget, error) {
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		percent, err := strconv.ParseFloat(p, 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return reclaimTarget{}, fmt.Errorf("invalid percentage %q", s)
+		}
+		return reclaimTarget{percent: percent}, nil
+	}
+	n, err := parseSize(s)
+	if err != nil {
+		return reclaimTarget{}, err
+	}
+	return reclaimTarget{bytes: uint64(n)}, nil
+}
+
+func (t reclaimTarget) reached(free, total uint64) bool {
+	if t.percent > 0 {
+		return float64(free) >= float64(total)*t.percent/100
+	}
+	return free >= t.bytes
+}
+
+func (t reclaimTarget) String() string {
+	if t.percent > 0 {
+		return strconv.FormatFloat(t.percent, 'f', -1, 64) + "%"
+	}
+	return humanizeBytes(int64(t.bytes))
+}
+
+// parseSize parses a byte count with an optional K, M, G or T suffix, using
+// binary (1024 based) units.
+func parseSize(s string) (int64, error) {
+	multiplier := int64(1)
+	num := strings.TrimSuffix(strings.ToUpper(s), "B")
+	if n := len(num); n > 0 {
+		switch num[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier != 1 {
+			num = num[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(f * float64(multiplier)), nil
+}
+
+// freeSpace returns the bytes available to unprivileged users and the total
+// size of the filesystem holding path.
+func freeSpace(path string) (free, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err := statfs(path, &st); err != nil {
+		return 0, 0, fmt.Errorf("failed to stat filesystem at %s: %w", path, err)
+	}
+	return st.Bavail * uint64(st.Bsize), st.Blocks * uint64(st.Bsize), nil
+}
+
+// checkFreeInodes fails if the filesystem holding the destination has fewer
+// than min_free_inodes free inodes. Filesystems full of small files can run
+// out of inodes long before they run out of bytes.
+func checkFreeInodes(config *Config) error {
+	if config.MinFreeInodes == 0 {
+		return nil
+	}
+	// The destination may not exist before the first run; check the
+	// filesystem it will be created on.
+	dir := config.Destination
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	var st syscall.Statfs_t
+	if err := statfs(dir, &st); err != nil {
+		return fmt.Errorf("failed to stat filesystem at %s: %w", dir, err)
+	}
+	free := uint64(st.Ffree)
+	if free < config.MinFreeInodes {
+		return fmt.Errorf("only %d free inodes on %s, need at least %d", free, dir, config.MinFreeInodes)
+	}
+	log.Info().Uint64("free_inodes", free).Str("path", dir).Msg("Free inode check passed")
+	return nil
+}
+
+// reclaimSpace deletes snapshots oldest first until the destination has at
+// least target free space. The latest snapshot and snapshots protected by
+// purge_exclude or protect_tagged are never deleted.
+func reclaimSpace(ctx context.Context, config *Config, target reclaimTarget, dryRun bool) error {
+	free, total, err := freeSpace(config.Destination)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("free", humanizeBytes(int64(free))).Str("total", humanizeBytes(int64(total))).Str("target", target.String()).Msg("Reclaiming space")
+	if target.reached(free, total) {
+		log.Info().Msg("Free space target already reached, nothing to reclaim.")
+		return nil
+	}
+
+	snapshots, err := configSnapshots(ctx, config) // sorted oldest to newest
+	if err != nil {
+		return err
+	}
+	if len(snapshots) <= 1 {
+		return fmt.Errorf("free space target %s not reached and there are no snapshots that can be deleted", target)
+	}
+	var candidates []os.FileInfo
+	for _, s := range snapshots[:len(snapshots)-1] {
+		if by := protectedBy(config, s.Name()); by != "" {
+			log.Info().Str("snapshot", s.Name()).Str("protected_by", by).Msg("Not reclaiming protected snapshot")
+			continue
+		}
+		candidates = append(candidates, s)
+	}
+
+	if dryRun {
+		// The space freed by a snapshot depends on how many of its files are
+		// hard linked from others, so we can't predict where this would stop.
+		for _, s := range candidates {
+			log.Info().Str("path", filepath.Join(config.Destination, s.Name())).Msg("[Dry Run] Would purge snapshot directory if still below target")
+		}
+		return nil
+	}
+
+	for _, s := range candidates {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		log.Info().Str("snapshot", s.Name()).Msg("Reclaiming snapshot")
+		if err := removeSnapshot(ctx, config, s.Name()); err != nil {
+			return fmt.Errorf("failed to purge snapshot %s: %w", s.Name(), err)
+		}
+		events.SnapshotPurged(SnapshotPurgedEvent{Snapshot: s.Name()})
+
+		free, total, err = freeSpace(config.Destination)
+		if err != nil {
+			return err
+		}
+		log.Info().Str("snapshot", s.Name()).Str("free", humanizeBytes(int64(free))).Msg("Reclaimed snapshot")
+		if target.reached(free, total) {
+			log.Info().Msg("Free space target reached.")
+			return nil
+		}
+	}
+
+	return fmt.Errorf("free space target %s not reached after deleting all unprotected snapshots but the latest", target)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseReclaimTarget(t *testing.T) {
+	target, err := parseReclaimTarget("20%")
+	if err != nil || target.percent != 20 {
+		t.Errorf("Expected 20%% target, got %+v (%v)", target, err)
+	}
+	target, err = parseReclaimTarget("50G")
+	if err != nil || target.bytes != 50<<30 {
+		t.Errorf("Expected 50G target, got %+v (%v)", target, err)
+	}
+	for _, bad := range []string{"", "abc", "0%", "120%", "-5G"} {
+		if _, err := parseReclaimTarget(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestReclaimSpace(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Now()
+	var names []string
+	for age := 5; age >= 1; age-- {
+		modTime := now.AddDate(0, 0, -age)
+		name := "test_" + modTime.Format(snapshotTimeFormat)
+		names = append(names, name)
+		path := filepath.Join(tmpDir, name)
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+	}
+
+	// Each deleted snapshot frees 10% of a 1000 block volume that starts out
+	// 5% free.
+	statfs = func(path string, st *syscall.Statfs_t) error {
+		remaining, err := getSnapshots(context.Background(), tmpDir)
+		if err != nil {
+			return err
+		}
+		st.Bsize = 1
+		st.Blocks = 1000
+		st.Bavail = uint64(50 + 100*(len(names)-len(remaining)))
+		return nil
+	}
+	defer func() { statfs = syscall.Statfs }()
+
+	if err := reclaimSpace(context.Background(), &Config{Destination: tmpDir, SnapshotPrefix: "test"}, reclaimTarget{percent: 25}, false); err != nil {
+		t.Fatalf("reclaimSpace failed: %v", err)
+	}
+
+	for i, name := range names {
+		_, err := os.Stat(filepath.Join(tmpDir, name))
+		if i < 2 && !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be reclaimed", name)
+		}
+		if i >= 2 && err != nil {
+			t.Errorf("Expected %s to be kept, got %v", name, err)
+		}
+	}
+
+	// A target that can't be reached stops short of the latest snapshot.
+	if err := reclaimSpace(context.Background(), &Config{Destination: tmpDir, SnapshotPrefix: "test"}, reclaimTarget{percent: 90}, false); err == nil {
+		t.Error("Expected an error when the target can't be reached")
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, names[len(names)-1])); err != nil {
+		t.Errorf("Expected the latest snapshot to be kept, got %v", err)
+	}
+}
+
+func TestCheckFreeInodes(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var statted string
+	statfs = func(path string, st *syscall.Statfs_t) error {
+		statted = path
+		st.Files = 1000000
+		st.Ffree = 500
+		return nil
+	}
+	defer func() { statfs = syscall.Statfs }()
+
+	// The destination doesn't exist yet, so its parent is checked.
+	config := &Config{Destination: filepath.Join(tmpDir, "backups", "host"), MinFreeInodes: 1000}
+	if err := checkFreeInodes(config); err == nil {
+		t.Error("Expected an error with 500 free inodes and min_free_inodes 1000")
+	}
+	if statted != tmpDir {
+		t.Errorf("Expected the nearest existing directory %s to be checked, got %s", tmpDir, statted)
+	}
+
+	config.MinFreeInodes = 500
+	if err := checkFreeInodes(config); err != nil {
+		t.Errorf("Expected 500 free inodes to be enough, got %v", err)
+	}
+
+	config.MinFreeInodes = 1000
+	if err := runSnapshotBackup(context.Background(), config, runOptions{}, false); err == nil {
+		t.Error("Expected the backup to abort with too few free inodes")
+	}
+	if _, err := os.Stat(config.Destination); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be created when aborting, got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// repairDestination brings the destination back to the state goback expects
+// after it has been changed by hand, and returns a description of every
+// anomaly it found:
+//
+//   - Directories and archives whose names aren't snapshot names, so goback
+//     ignores them, are reported.
+//   - Snapshots with the same timestamp, such as a directory and an archive
+//     of it, are reported.
+//   - Snapshots are ordered by modification time, which must lie between the
+//     time in the snapshot's name and the time of the next one. Snapshots
+//     whose modification time doesn't are reset to the time in their name.
+//   - The latest symlink is pointed at the newest snapshot.
+//
+// With dryRun the fixes are only logged.
+func repairDestination(ctx context.Context, config *Config, dryRun bool) ([]string, error) {
+	var problems []string
+	report := func(format string, args ...any) {
+		p := fmt.Sprintf(format, args...)
+		log.Warn().Msg(p)
+		problems = append(problems, p)
+	}
+
+	dir := snapshotsDir(config)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read destination: %w", err)
+	}
+	snapshots, err := getSnapshots(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool)
+	for _, s := range snapshots {
+		known[s.Name()] = true
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if known[name] || strings.HasPrefix(name, ".") || name == "lost+found" {
+			continue
+		}
+		if _, isArchive := trimArchiveSuffix(name); entry.IsDir() || (isArchive && entry.Type().IsRegular()) {
+			report("%s: not a snapshot name, ignored by goback", name)
+		}
+	}
+
+	snapshots, err = configSnapshots(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	type timed struct {
+		info os.FileInfo
+		time time.Time
+	}
+	var ordered []timed
+	seen := make(map[string]string)
+	for _, s := range snapshots {
+		base, _ := trimArchiveSuffix(s.Name())
+		t, ok := parseSnapshotTime(base)
+		if !ok {
+			continue // named with -name
+		}
+		key := fmt.Sprintf("%s#%d", t.Format(time.RFC3339), snapshotSeq(s.Name()))
+		if other, ok := seen[key]; ok {
+			report("%s: same timestamp as %s", s.Name(), other)
+			continue
+		}
+		seen[key] = s.Name()
+		ordered = append(ordered, timed{s, t})
+	}
+	// snapshots is sorted by modification time; order by name instead.
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if !ordered[i].time.Equal(ordered[j].time) {
+			return ordered[i].time.Before(ordered[j].time)
+		}
+		return snapshotSeq(ordered[i].info.Name()) < snapshotSeq(ordered[j].info.Name())
+	})
+
+	modTimes := make(map[string]time.Time)
+	for _, s := range snapshots {
+		modTimes[s.Name()] = s.ModTime()
+	}
+	for i, s := range ordered {
+		upper := timeNow()
+		if i+1 < len(ordered) {
+			upper = ordered[i+1].time
+		}
+		mtime := s.info.ModTime()
+		if !mtime.Before(s.time) && !mtime.After(upper) {
+			continue
+		}
+		name := s.info.Name()
+		report("%s: modification time %s doesn't match its name", name, mtime.Format(time.RFC3339))
+		if nativeBackend(config) {
+			continue // read-only filesystem snapshots
+		}
+		modTimes[name] = s.time
+		path := filepath.Join(dir, name)
+		if dryRun {
+			log.Info().Str("snapshot", name).Time("time", s.time).Msg("[Dry Run] Would reset modification time")
+			continue
+		}
+		if err := os.Chtimes(path, s.time, s.time); err != nil {
+			return nil, fmt.Errorf("failed to reset modification time of %s: %w", name, err)
+		}
+		log.Info().Str("snapshot", name).Time("time", s.time).Msg("Reset modification time")
+	}
+
+	// Sorted as getSnapshots does, with the reset times.
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		a, b := modTimes[snapshots[i].Name()], modTimes[snapshots[j].Name()]
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return snapshotSeq(snapshots[i].Name()) < snapshotSeq(snapshots[j].Name())
+	})
+	latest := ""
+	if len(snapshots) > 0 {
+		latest = snapshots[len(snapshots)-1].Name()
+	}
+	if err := repairLatestLink(config, latest, dryRun, report); err != nil {
+		return nil, err
+	}
+	return problems, nil
+}
+
+// repairLatestLink points the latest symlink at latest if it points
+// elsewhere, or removes it if there are no snapshots.
+func repairLatestLink(config *Config, latest string, dryRun bool, report func(string, ...any)) error {
+	link, err := latestLinkPath(config)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(link)
+	target, err := os.Readlink(link)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read latest symlink: %w", err)
+	}
+	exists := err == nil
+	if latest == "" {
+		if !exists {
+			return nil
+		}
+		report("%s: points at %s but there are no snapshots", name, target)
+		if dryRun {
+			log.Info().Str("link", link).Msg("[Dry Run] Would remove latest symlink")
+			return nil
+		}
+		if err := os.Remove(link); err != nil {
+			return fmt.Errorf("failed to remove latest symlink: %w", err)
+		}
+		log.Info().Str("link", link).Msg("Removed latest symlink")
+		return nil
+	}
+	if exists && filepath.Base(target) == latest {
+		return nil
+	}
+	if exists {
+		report("%s: points at %s instead of %s", name, target, latest)
+	} else {
+		report("%s: missing, should point at %s", name, latest)
+	}
+	return updateLatestLink(config, latest, dryRun)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRepairDestination(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Now().Truncate(time.Second)
+	var names []string
+	var times []time.Time
+	for i := 3; i >= 1; i-- {
+		ts := now.AddDate(0, 0, -i)
+		name := "test_" + ts.Format(snapshotTimeFormat)
+		if err := os.Mkdir(filepath.Join(tmpDir, name), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		finished := ts.Add(10 * time.Minute)
+		if err := os.Chtimes(filepath.Join(tmpDir, name), finished, finished); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+		names = append(names, name)
+		times = append(times, ts)
+	}
+	// The newest snapshot was touched so it sorts first, and latest still
+	// points at the middle one.
+	touched := now.AddDate(0, 0, -5)
+	if err := os.Chtimes(filepath.Join(tmpDir, names[2]), touched, touched); err != nil {
+		t.Fatalf("Failed to set mod time: %v", err)
+	}
+	if err := os.Symlink(names[1], filepath.Join(tmpDir, "latest-test")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(tmpDir, "test_yesterday"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test"}
+
+	problems, err := repairDestination(context.Background(), config, true)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if len(problems) != 3 {
+		t.Errorf("Expected 3 problems, got %v", problems)
+	}
+	if target, _ := os.Readlink(filepath.Join(tmpDir, "latest-test")); target != names[1] {
+		t.Errorf("Dry run changed latest to %s", target)
+	}
+
+	problems, err = repairDestination(context.Background(), config, false)
+	if err != nil {
+		t.Fatalf("repairDestination failed: %v", err)
+	}
+	got := strings.Join(problems, "\n")
+	for _, want := range []string{
+		"test_yesterday: not a snapshot name",
+		names[2] + ": modification time",
+		"latest-test: points at " + names[1] + " instead of " + names[2],
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected a problem %q, got %v", want, problems)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(tmpDir, names[2]))
+	if err != nil {
+		t.Fatalf("Failed to stat snapshot: %v", err)
+	}
+	if !info.ModTime().Equal(times[2]) {
+		t.Errorf("Expected modification time %v, got %v", times[2], info.ModTime())
+	}
+	// The others were consistent and are left alone.
+	if info, _ := os.Stat(filepath.Join(tmpDir, names[0])); !info.ModTime().Equal(times[0].Add(10 * time.Minute)) {
+		t.Errorf("Expected %s to keep its modification time, got %v", names[0], info.ModTime())
+	}
+	if target, _ := os.Readlink(filepath.Join(tmpDir, "latest-test")); target != names[2] {
+		t.Errorf("Expected latest to point at %s, got %s", names[2], target)
+	}
+
+	problems, err = repairDestination(context.Background(), config, false)
+	if err != nil {
+		t.Fatalf("repairDestination failed: %v", err)
+	}
+	if len(problems) != 1 {
+		t.Errorf("Expected only the misnamed directory to remain, got %v", problems)
+	}
+}
+
+func TestRepairDestinationDuplicateTimestamps(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ts := time.Now().Add(-time.Hour).Truncate(time.Second)
+	name := "test_" + ts.Format(snapshotTimeFormat)
+	if err := os.Mkdir(filepath.Join(tmpDir, name), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, name+".tar"), []byte("tar"), 0644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	for _, path := range []string{name, name + ".tar"} {
+		if err := os.Chtimes(filepath.Join(tmpDir, path), ts, ts); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+	}
+	if err := os.Symlink(name+".tar", filepath.Join(tmpDir, "latest-test")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test"}
+	problems, err := repairDestination(context.Background(), config, false)
+	if err != nil {
+		t.Fatalf("repairDestination failed: %v", err)
+	}
+	if len(problems) != 1 || !strings.Contains(problems[0], "same timestamp") {
+		t.Errorf("Expected a duplicate timestamp, got %v", problems)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// unfinishedSourcesFile records, next to .unfinished, the sources the
+// unfinished backup was started with. It can't live inside .unfinished
+// because rsync --delete would remove it.
+const unfinishedSourcesFile = ".unfinished.sources"
+
+func unfinishedSourcesPath(config *Config) string {
+	return filepath.Join(config.Destination, unfinishedSourcesFile)
+}
+
+// recordUnfinishedSources notes the sources a new .unfinished directory is
+// being filled from, so a later -resume can check they haven't changed.
+func recordUnfinishedSources(config *Config) error {
+	if err := os.WriteFile(unfinishedSourcesPath(config), []byte(strings.Join(config.Source, "\n")), 0644); err != nil {
+		return fmt.Errorf("failed to record sources of unfinished directory: %w", err)
+	}
+	return nil
+}
+
+// canResume reports whether the existing .unfinished directory can be reused.
+// It is an error to resume a backup that was started with other sources.
+func canResume(config *Config, unfinishedDir string) (bool, error) {
+	if _, err := os.Stat(unfinishedDir); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to check unfinished directory: %w", err)
+	}
+	data, err := os.ReadFile(unfinishedSourcesPath(config))
+	if err != nil {
+		return false, fmt.Errorf("can't resume, the sources of the unfinished backup are unknown: %w", err)
+	}
+	if string(data) != strings.Join(config.Source, "\n") {
+		return false, fmt.Errorf("can't resume, the sources changed since the unfinished backup started; run without -resume to start over")
+	}
+	return true, nil
+}
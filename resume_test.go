@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunSnapshotBackupResume(t *testing.T) {
+	assumeSourcesExist(t)
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Source: []string{"/home/user"}}
+
+	// A previous run got part of the way through.
+	unfinishedDir := filepath.Join(tmpDir, ".unfinished")
+	if err := os.Mkdir(unfinishedDir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(unfinishedDir, "partial"), []byte("copied"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := recordUnfinishedSources(config); err != nil {
+		t.Fatalf("Failed to record sources: %v", err)
+	}
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, "", 0)
+	defer func() { execCommand = exec.CommandContext }()
+
+	if err := runSnapshotBackup(context.Background(), config, runOptions{Resume: true}, false); err != nil {
+		t.Fatalf("runSnapshotBackup failed: %v", err)
+	}
+
+	latest, err := getLatestSnapshot(context.Background(), config)
+	if err != nil || latest == "" {
+		t.Fatalf("Expected a snapshot, got %q (%v)", latest, err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, latest, "partial")); err != nil {
+		t.Errorf("Expected the unfinished directory to be reused, got %v", err)
+	}
+	if _, err := os.Stat(unfinishedSourcesPath(config)); !os.IsNotExist(err) {
+		t.Errorf("Expected the sources record to be removed, got %v", err)
+	}
+}
+
+func TestRunSnapshotBackupResumeSourcesChanged(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Source: []string{"/home/user"}}
+	unfinishedDir := filepath.Join(tmpDir, ".unfinished")
+	if err := os.Mkdir(unfinishedDir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := recordUnfinishedSources(config); err != nil {
+		t.Fatalf("Failed to record sources: %v", err)
+	}
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, "", 0)
+	defer func() { execCommand = exec.CommandContext }()
+
+	config.Source = []string{"/home/other"}
+	if err := runSnapshotBackup(context.Background(), config, runOptions{Resume: true}, false); err == nil {
+		t.Fatal("Expected resuming with different sources to fail")
+	}
+	if gotArgs != nil {
+		t.Errorf("Expected rsync not to run, got %v", gotArgs)
+	}
+	if _, err := os.Stat(unfinishedDir); err != nil {
+		t.Errorf("Expected the unfinished directory to be left alone, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// rsyncVersionInfo is the version of the installed rsync.
+type rsyncVersionInfo struct {
+	Major, Minor, Patch int
+}
+
+func (v rsyncVersionInfo) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast reports whether v is the given version or newer.
+func (v rsyncVersionInfo) AtLeast(major, minor, patch int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	if v.Minor != minor {
+		return v.Minor > minor
+	}
+	return v.Patch >= patch
+}
+
+// rsyncVersionPattern matches the first line of rsync --version, e.g.
+// "rsync  version 3.2.7  protocol version 31" or "rsync  version v3.4.1".
+var rsyncVersionPattern = regexp.MustCompile(`rsync\s+version\s+v?(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// parseRsyncVersion extracts the version from the output of rsync --version.
+func parseRsyncVersion(output string) (rsyncVersionInfo, error) {
+	m := rsyncVersionPattern.FindStringSubmatch(output)
+	if m == nil {
+		line, _, _ := strings.Cut(output, "\n")
+		return rsyncVersionInfo{}, fmt.Errorf("no rsync version in %q", line)
+	}
+	var v rsyncVersionInfo
+	v.Major, _ = strconv.Atoi(m[1])
+	v.Minor, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		v.Patch, _ = strconv.Atoi(m[3])
+	}
+	return v, nil
+}
+
+// rsyncVersionCache holds the result of the first rsyncVersion call, so
+// rsync --version runs at most once per run.
+var rsyncVersionCache struct {
+	once    sync.Once
+	version rsyncVersionInfo
+	err     error
+}
+
+// rsyncVersion returns the version of the installed rsync.
+func rsyncVersion(ctx context.Context) (rsyncVersionInfo, error) {
+	rsyncVersionCache.once.Do(func() {
+		out, err := execCommand(ctx, "rsync", "--version").Output()
+		if err != nil {
+			rsyncVersionCache.err = fmt.Errorf("failed to run rsync --version: %w", err)
+			return
+		}
+		rsyncVersionCache.version, rsyncVersionCache.err = parseRsyncVersion(string(out))
+	})
+	return rsyncVersionCache.version, rsyncVersionCache.err
+}
+
+// rsyncFeature is an option whose rsync flag needs a minimum rsync version.
+type rsyncFeature struct {
+	Option              string
+	Major, Minor, Patch int
+}
+
+// rsyncFeatures returns the features config uses that older versions of
+// rsync lack.
+func rsyncFeatures(config *Config) []rsyncFeature {
+	var features []rsyncFeature
+	if config.AppendVerify {
+		features = append(features, rsyncFeature{"append_verify (--append-verify)", 3, 0, 0})
+	}
+	if config.Fuzzy >= 2 {
+		features = append(features, rsyncFeature{"fuzzy: 2 (--fuzzy --fuzzy)", 3, 1, 0})
+	}
+	if config.Progress {
+		features = append(features, rsyncFeature{"progress (--info=progress2)", 3, 1, 0})
+	}
+	return features
+}
+
+// checkRsyncFeatures fails if the installed rsync is too old for a feature
+// config uses, naming the option instead of leaving rsync to fail with an
+// unknown option. If the version can't be found out, it only warns.
+func checkRsyncFeatures(ctx context.Context, config *Config) error {
+	features := rsyncFeatures(config)
+	if len(features) == 0 {
+		return nil
+	}
+	version, err := rsyncVersion(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("Could not find out the rsync version")
+		return nil
+	}
+	for _, f := range features {
+		if !version.AtLeast(f.Major, f.Minor, f.Patch) {
+			return fmt.Errorf("%s needs rsync %d.%d.%d or newer, but rsync is version %s", f.Option, f.Major, f.Minor, f.Patch, version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// setRsyncVersion makes rsyncVersion return v without running rsync.
+func setRsyncVersion(t *testing.T, v rsyncVersionInfo) {
+	t.Helper()
+	rsyncVersionCache.once = sync.Once{}
+	rsyncVersionCache.once.Do(func() {})
+	rsyncVersionCache.version, rsyncVersionCache.err = v, nil
+	t.Cleanup(func() {
+		rsyncVersionCache.once = sync.Once{}
+		rsyncVersionCache.version, rsyncVersionCache.err = rsyncVersionInfo{}, nil
+	})
+}
+
+func TestParseRsyncVersion(t *testing.T) {
+	tests := []struct {
+		output string
+		want   rsyncVersionInfo
+	}{
+		{"rsync  version 3.2.7  protocol version 31\nCopyright (C) 1996-2022 by Andrew Tridgell, Wayne Davison, and others.\nWeb site: https://rsync.samba.org/\n", rsyncVersionInfo{3, 2, 7}},
+		{"rsync  version 2.6.9  protocol version 29\nCopyright (C) 1996-2006 by Andrew Tridgell, Wayne Davison, and others.\n", rsyncVersionInfo{2, 6, 9}},
+		{"rsync  version v3.4.1  protocol version 32\n", rsyncVersionInfo{3, 4, 1}},
+	}
+	for _, tt := range tests {
+		got, err := parseRsyncVersion(tt.output)
+		if err != nil || got != tt.want {
+			t.Errorf("parseRsyncVersion = %v, %v; want %v", got, err, tt.want)
+		}
+	}
+	if _, err := parseRsyncVersion("openrsync: protocol version 29\n"); err == nil {
+		t.Error("Expected output without an rsync version to be rejected")
+	}
+
+	v := rsyncVersionInfo{3, 1, 2}
+	for _, tt := range []struct {
+		major, minor, patch int
+		want                bool
+	}{
+		{3, 0, 0, true}, {3, 1, 2, true}, {3, 1, 3, false}, {3, 2, 0, false}, {2, 9, 9, true}, {4, 0, 0, false},
+	} {
+		if got := v.AtLeast(tt.major, tt.minor, tt.patch); got != tt.want {
+			t.Errorf("%v.AtLeast(%d, %d, %d) = %v, want %v", v, tt.major, tt.minor, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRsyncFeatures(t *testing.T) {
+	setRsyncVersion(t, rsyncVersionInfo{2, 6, 9})
+	config := &Config{AppendVerify: true}
+	err := checkRsyncFeatures(context.Background(), config)
+	if err == nil || !strings.Contains(err.Error(), "append_verify") || !strings.Contains(err.Error(), "2.6.9") {
+		t.Errorf("Expected append_verify to be refused on rsync 2.6.9, got %v", err)
+	}
+	if err := checkRsyncFeatures(context.Background(), &Config{Fuzzy: 1}); err != nil {
+		t.Errorf("Expected no version needs for fuzzy 1, got %v", err)
+	}
+
+	setRsyncVersion(t, rsyncVersionInfo{3, 2, 7})
+	if err := checkRsyncFeatures(context.Background(), &Config{AppendVerify: true, Fuzzy: 2}); err != nil {
+		t.Errorf("Expected rsync 3.2.7 to support everything, got %v", err)
+	}
+}
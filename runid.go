@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strings"
+)
+
+// runID identifies this run. It starts every log line and the rsync log,
+// so the lines of one run can be picked out of a log shared by many.
+var runID string
+
+// runIDLinePrefix starts the line of the rsync log that records the run ID.
+const runIDLinePrefix = "goback run "
+
+// newRunID returns a short random run ID such as "a1b2c3".
+func newRunID() string {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// readRunID returns the run ID recorded at the top of the rsync log at
+// path, or "" if there is none.
+func readRunID(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	//nolint:errcheck
+	defer f.Close()
+	line, _ := bufio.NewReader(f).ReadString('\n')
+	id, ok := strings.CutPrefix(strings.TrimSpace(line), runIDLinePrefix)
+	if !ok {
+		return ""
+	}
+	return id
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+func TestRunID(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	id := newRunID()
+	if !regexp.MustCompile(`^[0-9a-f]{6}$`).MatchString(id) {
+		t.Fatalf("Expected a 6 character hex run ID, got %q", id)
+	}
+	oldID := runID
+	runID = id
+	defer func() { runID = oldID }()
+
+	var buf bytes.Buffer
+	setupLogging(&buf, &Config{LogPrefix: "goback: "})
+	defer setupLogging(os.Stdout, &Config{})
+	log.Info().Msg("hello")
+	if !strings.HasPrefix(buf.String(), "goback: ["+id+"] ") {
+		t.Errorf("Expected the log line to start with the prefix and run ID, got %q", buf.String())
+	}
+
+	// The run ID heads the rsync log kept with the snapshot.
+	name := "test_" + time.Now().AddDate(0, 0, -1).Format(snapshotTimeFormat)
+	snapshot := filepath.Join(tmpDir, name)
+	if err := os.Mkdir(snapshot, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test"}
+	logs, err := openRunLogs(config, snapshot, name)
+	if err != nil {
+		t.Fatalf("openRunLogs failed: %v", err)
+	}
+	if _, err := logs.rsync.Write([]byte("Number of regular files transferred: 3\nTotal file size: 10 bytes\n")); err != nil {
+		t.Fatalf("Failed to write rsync log: %v", err)
+	}
+	logs.Close()
+
+	if got := readRunID(filepath.Join(snapshot, "rsync.log")); got != id {
+		t.Errorf("Expected run ID %s in the rsync log, got %q", id, got)
+	}
+	history, err := statsHistory(context.Background(), config)
+	if err != nil {
+		t.Fatalf("statsHistory failed: %v", err)
+	}
+	if len(history) != 1 || history[0].RunID != id || history[0].Stats.FilesTransferred != 3 {
+		t.Errorf("Expected run %s with 3 files in the history, got %+v", id, history)
+	}
+	if ev := newRsyncFinishedEvent(name, &rsyncStats{}); ev.RunID != id {
+		t.Errorf("Expected run ID %s in the event, got %q", id, ev.RunID)
+	}
+}
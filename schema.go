@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// schemaConstraints adds what the Go types can't express to the generated
+// schema, keyed by the dotted path of the option.
+var schemaConstraints = map[string]map[string]any{
+	"mode":                {"enum": []any{"snapshot", "simple"}},
+	"snapshot_backend":    {"enum": []any{"hardlink", "btrfs", "zfs"}},
+	"finalize_mode":       {"enum": []any{"rename", "copy"}},
+	"keep.monthly_anchor": {"enum": []any{"first", "last"}},
+	"nice":                {"minimum": -20, "maximum": 19},
+	"fuzzy":               {"minimum": 0, "maximum": 2},
+	"drift_threshold":     {"minimum": 0, "maximum": 100},
+	"slow_run_factor":     {"minimum": 0},
+	"max_delete":          {"minimum": 0},
+	"modify_window":       {"minimum": 0},
+	"block_size":          {"minimum": 0, "maximum": maxRsyncBlockSize},
+	"checksum_seed":       {"minimum": 0, "maximum": math.MaxInt32},
+	"log_retain":          {"minimum": 0},
+	"log_tail_lines":      {"minimum": 0},
+	"verbosity":           {"minimum": 0, "maximum": 3},
+	"max_purge_percent":   {"minimum": 0, "maximum": 100},
+}
+
+// schemaRequired lists the options every configuration must set.
+var schemaRequired = []any{"destination", "source"}
+
+// configSchema returns a JSON Schema for the configuration file, generated
+// from the yaml tags of Config.
+func configSchema() map[string]any {
+	schema := typeSchema(reflect.TypeOf(Config{}), "")
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "goback configuration"
+	schema["required"] = schemaRequired
+	return schema
+}
+
+// printSchema writes configSchema to w as indented JSON, for editors and
+// other tooling.
+func printSchema(w io.Writer) error {
+	data, err := json.MarshalIndent(configSchema(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+func typeSchema(t reflect.Type, path string) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var schema map[string]any
+	switch {
+	case t == reflect.TypeOf(source{}):
+		// A source is a path, or a command whose output is saved.
+		command := typeSchema(reflect.TypeOf(commandSource{}), path)
+		command["required"] = []any{"command", "output"}
+		schema = map[string]any{"anyOf": []any{map[string]any{"type": "string"}, command}}
+	case t.Kind() == reflect.Struct:
+		properties := make(map[string]any)
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			properties[name] = typeSchema(t.Field(i).Type, joinSchemaPath(path, name))
+		}
+		schema = map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+	case t.Kind() == reflect.Slice:
+		schema = map[string]any{"type": "array", "items": typeSchema(t.Elem(), path)}
+	case t.Kind() == reflect.String:
+		schema = map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		schema = map[string]any{"type": "boolean"}
+	case t.Kind() == reflect.Int, t.Kind() == reflect.Int64:
+		schema = map[string]any{"type": "integer"}
+	case t.Kind() == reflect.Uint64:
+		schema = map[string]any{"type": "integer", "minimum": 0}
+	case t.Kind() == reflect.Float64:
+		schema = map[string]any{"type": "number"}
+	default:
+		panic(fmt.Sprintf("no schema for %s at %s", t, path))
+	}
+	for k, v := range schemaConstraints[path] {
+		schema[k] = v
+	}
+	return schema
+}
+
+func joinSchemaPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// validateSchema checks the configuration read from path against
+// configSchema and returns a description of every problem. Like
+// unmarshalConfig it goes by the file extension. YAML, and JSON, which YAML
+// reads too, are checked with line numbers; a TOML file is decoded and
+// converted first, which loses them.
+func validateSchema(path string, data []byte) ([]string, error) {
+	var doc yaml.Node
+	if strings.ToLower(filepath.Ext(path)) == ".toml" {
+		var values map[string]any
+		if err := toml.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+		if len(values) == 0 {
+			return []string{"configuration is empty"}, nil
+		}
+		var root yaml.Node
+		if err := root.Encode(values); err != nil {
+			return nil, err
+		}
+		doc.Content = []*yaml.Node{&root}
+	} else if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return []string{"configuration is empty"}, nil
+	}
+	var problems []string
+	checkSchema(doc.Content[0], configSchema(), "", &problems)
+	return problems, nil
+}
+
+// atLine starts a problem found at line, which is 0 for converted TOML.
+func atLine(line int) string {
+	if line == 0 {
+		return ""
+	}
+	return fmt.Sprintf("line %d: ", line)
+}
+
+func checkSchema(node *yaml.Node, schema map[string]any, path string, problems *[]string) {
+	report := func(format string, args ...any) {
+		where := path
+		if where == "" {
+			where = "configuration"
+		}
+		*problems = append(*problems, fmt.Sprintf("%s%s: %s", atLine(node.Line), where, fmt.Sprintf(format, args...)))
+	}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Tag == "!!null" {
+		return // an option left empty keeps its default
+	}
+
+	if alternatives, ok := schema["anyOf"].([]any); ok {
+		// Check the node against the alternative of its kind, so the
+		// problems reported are those of what was meant.
+		for _, a := range alternatives {
+			alternative := a.(map[string]any)
+			if (alternative["type"] == "object") == (node.Kind == yaml.MappingNode) {
+				checkSchema(node, alternative, path, problems)
+				return
+			}
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		if node.Kind != yaml.MappingNode {
+			report("expected a mapping")
+			return
+		}
+		properties := schema["properties"].(map[string]any)
+		seen := make(map[string]bool)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			seen[key.Value] = true
+			property, ok := properties[key.Value]
+			if !ok {
+				*problems = append(*problems, fmt.Sprintf("%s%s: unknown option", atLine(key.Line), joinSchemaPath(path, key.Value)))
+				continue
+			}
+			checkSchema(value, property.(map[string]any), joinSchemaPath(path, key.Value), problems)
+		}
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if !seen[name.(string)] {
+				report("missing required option %s", name)
+			}
+		}
+		return
+	case "array":
+		if node.Kind != yaml.SequenceNode {
+			report("expected a list")
+			return
+		}
+		for i, item := range node.Content {
+			checkSchema(item, schema["items"].(map[string]any), fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+		return
+	}
+
+	want := map[any]string{"string": "a string", "boolean": "a boolean", "integer": "an integer", "number": "a number"}[schema["type"]]
+	if node.Kind != yaml.ScalarNode {
+		report("expected %s", want)
+		return
+	}
+	wantTags := map[any][]string{
+		"string":  {"!!str"},
+		"boolean": {"!!bool"},
+		"integer": {"!!int"},
+		"number":  {"!!int", "!!float"},
+	}[schema["type"]]
+	if !slices.Contains(wantTags, node.Tag) {
+		report("expected %s, got %q", want, node.Value)
+		return
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, any(node.Value)) {
+		var values []string
+		for _, v := range enum {
+			values = append(values, fmt.Sprint(v))
+		}
+		sort.Strings(values)
+		report("must be one of %s, got %q", strings.Join(values, ", "), node.Value)
+	}
+	if schema["type"] == "integer" || schema["type"] == "number" {
+		var n float64
+		if err := node.Decode(&n); err != nil {
+			report("invalid number %q", node.Value)
+			return
+		}
+		if min, ok := schema["minimum"].(int); ok && n < float64(min) {
+			report("must be at least %d, got %s", min, node.Value)
+		}
+		if max, ok := schema["maximum"].(int); ok && n > float64(max) {
+			report("must be at most %d, got %s", max, node.Value)
+		}
+	}
+}
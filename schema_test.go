@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateSchema(t *testing.T) {
+	good := `
+mode: snapshot
+destination: /backups/server
+snapshot_prefix: server
+source:
+  - /home
+  - /etc
+exclude: [".cache"]
+keep:
+  daily: 7
+  weekly: 4
+  monthly_anchor: first
+verbosity: 2
+drift_threshold: 12.5
+log_timestamps: false
+rsync_extra_flags: "--numeric-ids"
+max_log_size:
+`
+	problems, err := validateSchema("config.yaml", []byte(good))
+	if err != nil {
+		t.Fatalf("validateSchema failed: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Expected a valid config, got %v", problems)
+	}
+
+	for _, tc := range []struct {
+		config string
+		want   string
+	}{
+		{"destination: /b\nsource: [/a]\nmode: weekly\n", `line 3: mode: must be one of simple, snapshot, got "weekly"`},
+		{"destination: /b\nsource: [/a]\nkeep:\n  daily: seven\n", `line 4: keep.daily: expected an integer, got "seven"`},
+		{"destination: /b\nsource: /a\n", "line 2: source: expected a list"},
+		{"destination: /b\nsource: [/a, [/c]]\n", "line 2: source[1]: expected a string"},
+		{"destination: /b\nsource: [/a]\nexlcude: [tmp]\n", "line 3: exlcude: unknown option"},
+		{"source: [/a]\n", "line 1: configuration: missing required option destination"},
+		{"destination: /b\nsource: [/a]\npid_file: maybe\n", `line 3: pid_file: expected a boolean, got "maybe"`},
+		{"destination: /b\nsource: [/a]\nnice: 40\n", "line 3: nice: must be at most 19, got 40"},
+		{"destination: /b\nsource: [/a]\nverbosity: 4\n", "line 3: verbosity: must be at most 3, got 4"},
+		{"destination: /b\nsource: [/a]\nmax_purge_percent: -5\n", "line 3: max_purge_percent: must be at least 0, got -5"},
+		{"destination: /b\nsource: [/a]\nkeep:\n  monthly_anchor: middle\n", `line 4: keep.monthly_anchor: must be one of first, last, got "middle"`},
+	} {
+		problems, err := validateSchema("config.yaml", []byte(tc.config))
+		if err != nil {
+			t.Fatalf("validateSchema(%q) failed: %v", tc.config, err)
+		}
+		if len(problems) != 1 || problems[0] != tc.want {
+			t.Errorf("For %q expected [%s], got %v", tc.config, tc.want, problems)
+		}
+	}
+}
+
+func TestValidateSchemaTOML(t *testing.T) {
+	good := `
+destination = "/backups/server"
+source = ["/home", "/etc"]
+drift_threshold = 12.5
+pid_file = true
+
+[keep]
+daily = 7
+`
+	problems, err := validateSchema("config.toml", []byte(good))
+	if err != nil {
+		t.Fatalf("validateSchema failed: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Expected a valid config, got %v", problems)
+	}
+
+	problems, err = validateSchema("config.toml", []byte("destination = \"/b\"\nsource = [\"/a\"]\nmode = \"weekly\"\n"))
+	if err != nil {
+		t.Fatalf("validateSchema failed: %v", err)
+	}
+	if want := `mode: must be one of simple, snapshot, got "weekly"`; len(problems) != 1 || problems[0] != want {
+		t.Errorf("Expected [%s], got %v", want, problems)
+	}
+}
+
+func TestPrintSchema(t *testing.T) {
+	var b strings.Builder
+	if err := printSchema(&b); err != nil {
+		t.Fatalf("printSchema failed: %v", err)
+	}
+	var schema struct {
+		Schema     string `json:"$schema"`
+		Required   []string
+		Properties map[string]struct {
+			Type       string
+			Enum       []string
+			Properties map[string]struct{ Type string }
+		}
+	}
+	if err := json.Unmarshal([]byte(b.String()), &schema); err != nil {
+		t.Fatalf("Expected valid JSON, got %v:\n%s", err, b.String())
+	}
+	if !strings.Contains(schema.Schema, "json-schema.org") {
+		t.Errorf("Expected a $schema, got %q", schema.Schema)
+	}
+	if !slices.Equal(schema.Required, []string{"destination", "source"}) {
+		t.Errorf("Expected destination and source to be required, got %v", schema.Required)
+	}
+	for name, want := range map[string]string{
+		"destination":   "string",
+		"source":        "array",
+		"keep":          "object",
+		"pid_file":      "boolean",
+		"fuzzy":         "integer",
+		"snapshot_name": "string",
+	} {
+		if got := schema.Properties[name].Type; got != want {
+			t.Errorf("Expected %s to be %s, got %q", name, want, got)
+		}
+	}
+	if got := schema.Properties["keep"].Properties["daily"].Type; got != "integer" {
+		t.Errorf("Expected keep.daily to be integer, got %q", got)
+	}
+	if !slices.Equal(schema.Properties["mode"].Enum, []string{"snapshot", "simple"}) {
+		t.Errorf("Expected the mode enum, got %v", schema.Properties["mode"].Enum)
+	}
+}
+
+func TestConfigSchemaCoversConfig(t *testing.T) {
+	properties := configSchema()["properties"].(map[string]any)
+	for _, name := range []string{"mode", "keep", "snapshot_backend", "purge_grace_period"} {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("Expected %s in the schema", name)
+		}
+	}
+	keep := properties["keep"].(map[string]any)
+	if _, ok := keep["properties"].(map[string]any)["monthly_anchor"]; keep["type"] != "object" || !ok {
+		t.Errorf("Expected keep to be an object with monthly_anchor, got %v", keep)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// envReference matches a "${NAME}" environment variable reference.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// resolveSecrets fills in the secret-bearing config fields from their _file
+// variants and expands environment variable references in them, so the
+// secrets themselves don't have to be written into config.yaml.
+func resolveSecrets(config *Config) error {
+	password, err := resolveSecret("rsync_password", config.RsyncPassword, config.RsyncPasswordFile)
+	if err != nil {
+		return err
+	}
+	config.RsyncPassword = password
+	return nil
+}
+
+// resolveSecret returns the secret read from file if it is set, otherwise
+// value with any ${NAME} references replaced by the environment variable.
+func resolveSecret(name, value, file string) (string, error) {
+	if file != "" {
+		if value != "" {
+			return "", fmt.Errorf("%s and %s_file are mutually exclusive", name, name)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s_file: %w", name, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	var missing []string
+	resolved := envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		env := envReference.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(env)
+		if !ok {
+			missing = append(missing, env)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%s refers to unset environment variable %s", name, strings.Join(missing, ", "))
+	}
+	return resolved, nil
+}
+
+// setRsyncPassword passes the rsync daemon password to cmd through the
+// environment, which keeps it off the command line.
+func setRsyncPassword(cmd *exec.Cmd, config *Config) {
+	if config.RsyncPassword != "" {
+		cmd.Env = append(cmd.Environ(), "RSYNC_PASSWORD="+config.RsyncPassword)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSecretFromFile(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	secretFile := filepath.Join(tmpDir, "rsync.secret")
+	if err := os.WriteFile(secretFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatalf("Failed to write secret file: %v", err)
+	}
+	configFile := filepath.Join(tmpDir, "config.yaml")
+	configData := "destination: rsync://backup@nas/backups\nmode: simple\nrsync_password_file: " + secretFile + "\n"
+	if err := os.WriteFile(configFile, []byte(configData), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	config, err := readConfig(configFile)
+	if err != nil {
+		t.Fatalf("readConfig failed: %v", err)
+	}
+	if config.RsyncPassword != "s3cret" {
+		t.Errorf("Expected password from file, got %q", config.RsyncPassword)
+	}
+}
+
+func TestResolveSecretFromEnv(t *testing.T) {
+	t.Setenv("GOBACK_TEST_PASSWORD", "from-env")
+
+	got, err := resolveSecret("rsync_password", "${GOBACK_TEST_PASSWORD}", "")
+	if err != nil {
+		t.Fatalf("resolveSecret failed: %v", err)
+	}
+	if got != "from-env" {
+		t.Errorf("Expected %q, got %q", "from-env", got)
+	}
+
+	if _, err := resolveSecret("rsync_password", "${GOBACK_TEST_UNSET}", ""); err == nil {
+		t.Error("Expected an error for an unset environment variable")
+	}
+	if got, _ := resolveSecret("rsync_password", "plain", ""); got != "plain" {
+		t.Errorf("Expected a plain value to be left alone, got %q", got)
+	}
+}
+
+func TestResolveSecretMissingFile(t *testing.T) {
+	if _, err := resolveSecret("rsync_password", "", "/nonexistent/rsync.secret"); err == nil {
+		t.Error("Expected an error for a missing secret file")
+	}
+	if _, err := resolveSecret("rsync_password", "inline", "/etc/hostname"); err == nil {
+		t.Error("Expected an error when both the value and the file are set")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// signatureFile is written into snapshots made with skip_if_unchanged and
+// holds the signature of the sources the snapshot was taken from.
+const signatureFile = ".goback-signature"
+
+// sourceSignature summarises the sources without reading any file: the
+// number of files and directories the exclude rules don't skip, their total
+// size and the newest modification time among them, together with a hash of
+// the source and exclude lists. Directory times change when entries are
+// added, removed or renamed. A file changed in place without changing its
+// size or modification time goes unnoticed.
+func sourceSignature(ctx context.Context, config *Config) (string, error) {
+	h := sha256.New()
+	for _, src := range config.Source {
+		fmt.Fprintf(h, "source %s\n", src)
+	}
+	for _, rule := range config.Exclude {
+		fmt.Fprintf(h, "exclude %s\n", rule)
+	}
+
+	var count, size int64
+	var newest time.Time
+	for _, src := range config.Source {
+		anchor := sourceAnchor(src)
+		err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			if rel == "." {
+				rel = ""
+			}
+			anchored := strings.TrimSuffix(anchor+filepath.ToSlash(rel), "/")
+			if anchored != "" && excludedByRules(anchored, d.IsDir(), config.Exclude) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			count++
+			if info.Mode().IsRegular() {
+				size += info.Size()
+			}
+			if info.ModTime().After(newest) {
+				newest = info.ModTime()
+			}
+			return nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to compute signature of %s: %w", src, err)
+		}
+	}
+	return fmt.Sprintf("entries=%d size=%d newest=%s config=%x\n", count, size, newest.UTC().Format(time.RFC3339Nano), h.Sum(nil)[:8]), nil
+}
+
+// errSourcesUnchanged is returned by runSnapshotBackup when
+// skip_if_unchanged skipped the backup.
+var errSourcesUnchanged = errors.New("sources unchanged since the latest snapshot")
+
+// latestSignature returns the signature stored in the latest snapshot, or ""
+// if there is none.
+func latestSignature(ctx context.Context, config *Config) (string, error) {
+	latest, err := getLatestSnapshot(ctx, config)
+	if err != nil || latest == "" {
+		return "", err
+	}
+	data, err := os.ReadFile(filepath.Join(snapshotsDir(config), latest, signatureFile))
+	if err != nil {
+		return "", nil
+	}
+	return string(data), nil
+}
+
+func writeSignature(dir, signature string) error {
+	if err := os.WriteFile(filepath.Join(dir, signatureFile), []byte(signature), 0644); err != nil {
+		return fmt.Errorf("failed to write source signature: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// makeSignatureSource creates a source tree with a file, a subdirectory and
+// an excluded cache directory, all with fixed times.
+func makeSignatureSource(t *testing.T, dir string) string {
+	t.Helper()
+	src := filepath.Join(dir, "source")
+	for _, d := range []string{filepath.Join(src, "docs"), filepath.Join(src, "cache")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(src, "docs", "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	for _, p := range []string{filepath.Join(src, "docs", "a.txt"), filepath.Join(src, "docs"), filepath.Join(src, "cache"), src} {
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+	}
+	return src
+}
+
+func TestSourceSignature(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	src := makeSignatureSource(t, tmpDir)
+	config := &Config{Source: []string{src}, Exclude: []string{"cache/"}}
+	signature := func() string {
+		t.Helper()
+		sig, err := sourceSignature(context.Background(), config)
+		if err != nil {
+			t.Fatalf("sourceSignature failed: %v", err)
+		}
+		return sig
+	}
+
+	first := signature()
+	if again := signature(); again != first {
+		t.Errorf("Expected the same signature for an unchanged tree, got %q and %q", first, again)
+	}
+
+	if err := os.WriteFile(filepath.Join(src, "cache", "tmp"), []byte("scratch"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if sig := signature(); sig != first {
+		t.Errorf("Expected changes in excluded directories to be ignored, got %q and %q", first, sig)
+	}
+
+	if err := os.WriteFile(filepath.Join(src, "docs", "a.txt"), []byte("hello, world"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	changed := signature()
+	if changed == first {
+		t.Error("Expected a changed file to change the signature")
+	}
+
+	if err := os.Rename(filepath.Join(src, "docs", "a.txt"), filepath.Join(src, "docs", "b.txt")); err != nil {
+		t.Fatalf("Failed to rename file: %v", err)
+	}
+	// The directory gets a time that can't be mistaken for the last write.
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(src, "docs"), later, later); err != nil {
+		t.Fatalf("Failed to set mod time: %v", err)
+	}
+	renamed := signature()
+	if renamed == changed {
+		t.Error("Expected a renamed file to change the signature")
+	}
+
+	config.Exclude = []string{"cache/", "*.tmp"}
+	if sig := signature(); sig == renamed {
+		t.Error("Expected different exclude rules to change the signature")
+	}
+}
+
+func TestRunSnapshotBackupSkipIfUnchanged(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	src := makeSignatureSource(t, tmpDir)
+	dest := filepath.Join(tmpDir, "dest")
+	if err := os.Mkdir(dest, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+
+	when := time.Date(2025, time.June, 1, 2, 0, 0, 0, time.Local)
+	timeNow = func() time.Time { return when }
+	defer func() { timeNow = time.Now }()
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, "", 0)
+	defer func() { execCommand = exec.CommandContext }()
+
+	config := &Config{Destination: dest, SnapshotPrefix: "test", Source: []string{src}, SkipIfUnchanged: true}
+	if err := validateConfig(config); err != nil {
+		t.Fatalf("validateConfig failed: %v", err)
+	}
+	if err := runSnapshotBackup(context.Background(), config, runOptions{}, false); err != nil {
+		t.Fatalf("runSnapshotBackup failed: %v", err)
+	}
+	first := "test_" + when.Format(snapshotTimeFormat)
+	if _, err := os.Stat(filepath.Join(dest, first, signatureFile)); err != nil {
+		t.Fatalf("Expected the signature to be stored in the snapshot: %v", err)
+	}
+
+	gotArgs = nil
+	when = when.Add(time.Hour)
+	if err := runSnapshotBackup(context.Background(), config, runOptions{}, false); !errors.Is(err, errSourcesUnchanged) {
+		t.Fatalf("Expected the backup to be skipped, got %v", err)
+	}
+	if gotArgs != nil {
+		t.Errorf("Expected rsync not to run for unchanged sources, got %v", gotArgs)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "test_"+when.Format(snapshotTimeFormat))); !os.IsNotExist(err) {
+		t.Errorf("Expected no new snapshot, got %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(src, "docs", "new.txt"), []byte("new"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := runSnapshotBackup(context.Background(), config, runOptions{}, false); err != nil {
+		t.Fatalf("runSnapshotBackup failed: %v", err)
+	}
+	if gotArgs == nil {
+		t.Error("Expected rsync to run after the sources changed")
+	}
+	if _, err := os.Stat(filepath.Join(dest, "test_"+when.Format(snapshotTimeFormat))); err != nil {
+		t.Errorf("Expected a new snapshot: %v", err)
+	}
+
+	for _, bad := range []*Config{
+		{Mode: "simple", SkipIfUnchanged: true},
+		{Archive: true, SkipIfUnchanged: true},
+		{SnapshotBackend: "btrfs", SkipIfUnchanged: true},
+		{Commands: []commandSource{{Command: "pg_dump", Output: "db.sql"}}, SkipIfUnchanged: true},
+	} {
+		if err := validateConfig(bad); err == nil {
+			t.Errorf("Expected an error for skip_if_unchanged with %+v", bad)
+		}
+	}
+}
+
+func TestRunBackupPurgeOnlyAfterNewSnapshot(t *testing.T) {
+	for _, purgeOnlyAfter := range []bool{false, true} {
+		tmpDir, err := os.MkdirTemp("", "goback-test")
+		if err != nil {
+			t.Fatalf("Failed to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		src := makeSignatureSource(t, tmpDir)
+		dest := filepath.Join(tmpDir, "dest")
+		if err := os.Mkdir(dest, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+
+		var gotArgs []string
+		execCommand = fakeRsync(&gotArgs, "", 0)
+		defer func() { execCommand = exec.CommandContext }()
+
+		config := &Config{Destination: dest, SnapshotPrefix: "test", Source: []string{src}, Keep: Keep{Daily: 1},
+			MaxPurgePercent: 100, SkipIfUnchanged: true, PurgeOnlyAfterNewSnapshot: purgeOnlyAfter}
+		if err := runBackup(context.Background(), config, runOptions{}, false); err != nil {
+			t.Fatalf("runBackup failed: %v", err)
+		}
+
+		// An older snapshot the keep policy has no room for.
+		old := time.Now().AddDate(0, 0, -10)
+		oldName := "test_" + old.Format(snapshotTimeFormat)
+		if err := os.Mkdir(filepath.Join(dest, oldName), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.Chtimes(filepath.Join(dest, oldName), old, old); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+
+		gotArgs = nil
+		if err := runBackup(context.Background(), config, runOptions{}, false); err != nil {
+			t.Fatalf("runBackup failed: %v", err)
+		}
+		if gotArgs != nil {
+			t.Fatalf("Expected the backup to be skipped, got %v", gotArgs)
+		}
+		_, err = os.Stat(filepath.Join(dest, oldName))
+		if purgeOnlyAfter && err != nil {
+			t.Errorf("Expected purge to be bypassed after a skipped backup, got %v", err)
+		} else if !purgeOnlyAfter && !os.IsNotExist(err) {
+			t.Errorf("Expected purge to run after a skipped backup, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// simulateRetention writes what the current keep policy and keep would each
+// do with every snapshot of config's prefix, from oldest to newest. Nothing
+// is deleted.
+func simulateRetention(ctx context.Context, config *Config, keep Keep, w io.Writer) error {
+	snapshots, err := configSnapshots(ctx, config) // sorted oldest to newest
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		fmt.Fprintln(w, "No snapshots found.")
+		return nil
+	}
+	newestFirst := make([]os.FileInfo, len(snapshots))
+	for i, s := range snapshots {
+		newestFirst[len(snapshots)-1-i] = s
+	}
+	current := retentionPlan(newestFirst, config, config.Keep, timeNow())
+	simulated := retentionPlan(newestFirst, config, keep, timeNow())
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SNAPSHOT\tCURRENT\tSIMULATED")
+	for _, s := range snapshots {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Name(), retentionDecision(current, s.Name()), retentionDecision(simulated, s.Name()))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Current policy keeps %d of %d snapshots, simulated policy keeps %d.\n", len(current), len(snapshots), len(simulated))
+	return err
+}
+
+func retentionDecision(plan map[string]keepReason, name string) string {
+	if reason, ok := plan[name]; ok {
+		return reason.String()
+	}
+	return "deleted"
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulateRetention(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// One snapshot a day from Wednesday 1 to Friday 10 January 2025, which
+	// spans ISO weeks 1 and 2.
+	var names []string
+	for day := 1; day <= 10; day++ {
+		modTime := time.Date(2025, time.January, day, 3, 0, 0, 0, time.Local)
+		name := "test_" + modTime.Format(snapshotTimeFormat)
+		path := filepath.Join(tmpDir, name)
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+		names = append(names, name)
+	}
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Keep: Keep{Daily: 3}}
+	var out strings.Builder
+	if err := simulateRetention(context.Background(), config, Keep{Daily: 1, Weekly: 2}, &out); err != nil {
+		t.Fatalf("simulateRetention failed: %v", err)
+	}
+	lines := make(map[string]string)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			lines[fields[0]] = strings.Join(fields, " ")
+		}
+	}
+	want := map[string]string{
+		names[9]: "kept: daily slot 1 kept: daily slot 1",
+		names[8]: "kept: daily slot 2 deleted",
+		names[7]: "kept: daily slot 3 deleted",
+		names[4]: "deleted kept: weekly 2025-W01",
+		names[0]: "deleted deleted",
+	}
+	for name, decisions := range want {
+		if got := lines[name]; got != name+" "+decisions {
+			t.Errorf("Expected %q, got %q", name+" "+decisions, got)
+		}
+	}
+	if !strings.Contains(out.String(), "Current policy keeps 3 of 10 snapshots, simulated policy keeps 2.") {
+		t.Errorf("Expected a summary line, got %q", out.String())
+	}
+
+	// Nothing is deleted.
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil || len(entries) != len(names) {
+		t.Errorf("Expected all %d snapshots to remain, got %d (%v)", len(names), len(entries), err)
+	}
+}
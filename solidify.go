@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// solidifiedMarker is written into snapshots solidifySnapshot replaced, which
+// by design share no files with their neighbours.
+const solidifiedMarker = ".goback-solidified"
+
+// isSolidified reports whether the snapshot at path was solidified.
+func isSolidified(path string) bool {
+	_, err := os.Lstat(filepath.Join(path, solidifiedMarker))
+	return err == nil
+}
+
+// solidifySnapshot gives a snapshot its own copy of every file, so that it no
+// longer shares any data with the rest of the chain through hard links. The
+// copy replaces the snapshot, or is written to copyTo if that is set.
+func solidifySnapshot(ctx context.Context, config *Config, name string, copyTo string, dryRun bool) error {
+	if nativeBackend(config) {
+		return fmt.Errorf("%s snapshots share data through the filesystem, not hard links", config.SnapshotBackend)
+	}
+	snapshots, err := configSnapshots(ctx, config)
+	if err != nil {
+		return err
+	}
+	var found os.FileInfo
+	for _, s := range snapshots {
+		if s.Name() == name {
+			found = s
+		}
+	}
+	if found == nil {
+		return fmt.Errorf("no snapshot named %s in %s", name, config.Destination)
+	}
+	if !found.IsDir() {
+		return fmt.Errorf("%s is an archive, which shares no data with other snapshots", name)
+	}
+
+	src := filepath.Join(config.Destination, name)
+	if copyTo != "" {
+		if dryRun {
+			log.Info().Str("snapshot", src).Str("to", copyTo).Msg("[Dry Run] Would copy snapshot")
+			return nil
+		}
+		if _, err := os.Lstat(copyTo); err == nil {
+			return fmt.Errorf("%s already exists", copyTo)
+		}
+		log.Info().Str("snapshot", src).Str("to", copyTo).Msg("Copying snapshot")
+		return copyTree(ctx, src, copyTo)
+	}
+
+	if dryRun {
+		log.Info().Str("snapshot", src).Msg("[Dry Run] Would replace snapshot with an unlinked copy")
+		return nil
+	}
+	tmp := filepath.Join(config.Destination, ".solidify-"+name)
+	old := filepath.Join(config.Destination, ".solidify-old-"+name)
+	// Left over from an interrupted run.
+	if err := removeAll(tmp); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", tmp, err)
+	}
+	log.Info().Str("snapshot", src).Msg("Copying snapshot")
+	if err := copyTree(ctx, src, tmp); err != nil {
+		removeAll(tmp) //nolint:errcheck
+		return err
+	}
+	// Writing the marker changes the copy's time, which drives retention.
+	if err := os.WriteFile(filepath.Join(tmp, solidifiedMarker), nil, 0644); err != nil {
+		removeAll(tmp) //nolint:errcheck
+		return fmt.Errorf("failed to mark snapshot as solidified: %w", err)
+	}
+	if err := os.Chtimes(tmp, found.ModTime(), found.ModTime()); err != nil {
+		removeAll(tmp) //nolint:errcheck
+		return fmt.Errorf("failed to set time of %s: %w", tmp, err)
+	}
+	if err := os.Rename(src, old); err != nil {
+		return fmt.Errorf("failed to move snapshot aside: %w", err)
+	}
+	if err := os.Rename(tmp, src); err != nil {
+		os.Rename(old, src) //nolint:errcheck
+		return fmt.Errorf("failed to move copy into place: %w", err)
+	}
+	if err := removeAll(old); err != nil {
+		return fmt.Errorf("failed to remove original snapshot %s: %w", old, err)
+	}
+	log.Info().Str("snapshot", src).Msg("Snapshot solidified")
+	return nil
+}
+
+// copyTree copies the directory src to dst, which must not exist, without
+// reusing any of src's inodes. Permissions, ownership and modification times
+// are kept, as are hard links between files inside src.
+func copyTree(ctx context.Context, src, dst string) error {
+	type dirTime struct {
+		path    string
+		mode    fs.FileMode
+		modTime time.Time
+	}
+	var dirs []dirTime
+	linked := make(map[uint64]string) // inode in src to its copy
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		st, _ := info.Sys().(*syscall.Stat_t)
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			// Writable until its contents are in place.
+			if err := os.Mkdir(target, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirTime{target, mode, info.ModTime()})
+		case mode&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if st != nil && st.Nlink > 1 {
+				if first, ok := linked[st.Ino]; ok {
+					return os.Link(first, target)
+				}
+				linked[st.Ino] = target
+			}
+			if err := copyFile(path, target, mode.Perm()); err != nil {
+				return err
+			}
+		default:
+			if st == nil {
+				return fmt.Errorf("can't copy special file %s", path)
+			}
+			if err := syscall.Mknod(target, st.Mode, int(st.Rdev)); err != nil {
+				return fmt.Errorf("failed to create %s: %w", target, err)
+			}
+		}
+
+		if st != nil {
+			// Only root can give files away; like rsync, carry on without.
+			if err := os.Lchown(target, int(st.Uid), int(st.Gid)); err != nil && !errors.Is(err, fs.ErrPermission) {
+				return err
+			}
+		}
+		if !info.IsDir() && info.Mode()&fs.ModeSymlink == 0 {
+			// Chown clears the setuid and setgid bits.
+			if err := os.Chmod(target, info.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+				return err
+			}
+			return os.Chtimes(target, info.ModTime(), info.ModTime())
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+
+	// Directory times change as their contents are written, so they are set
+	// last, innermost first. The snapshot's own time drives retention.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if err := os.Chmod(d.path, d.mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			return fmt.Errorf("failed to set mode of %s: %w", d.path, err)
+		}
+		if err := os.Chtimes(d.path, d.modTime, d.modTime); err != nil {
+			return fmt.Errorf("failed to set time of %s: %w", d.path, err)
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close() //nolint:errcheck
+		return err
+	}
+	return out.Close()
+}
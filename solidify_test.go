@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSolidifySnapshot(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	names := makeLinkChain(t, tmpDir)
+	middle := filepath.Join(tmpDir, names[1])
+	// A hard link within the snapshot itself is kept.
+	if err := os.Link(filepath.Join(middle, "changed.txt"), filepath.Join(middle, "sub", "alias.txt")); err != nil {
+		t.Fatalf("Failed to link file: %v", err)
+	}
+	if err := os.Symlink("changed.txt", filepath.Join(middle, "link")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	before, err := os.Stat(middle)
+	if err != nil {
+		t.Fatalf("Failed to stat snapshot: %v", err)
+	}
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test"}
+	if err := solidifySnapshot(context.Background(), config, names[1], "", false); err != nil {
+		t.Fatalf("solidifySnapshot failed: %v", err)
+	}
+
+	after, err := os.Stat(middle)
+	if err != nil {
+		t.Fatalf("Failed to stat snapshot: %v", err)
+	}
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("Expected the snapshot time %v to be kept, got %v", before.ModTime(), after.ModTime())
+	}
+
+	// No file in the solidified snapshot may share an inode with another.
+	inodes := make(map[string]os.FileInfo)
+	for _, name := range []string{names[0], names[2]} {
+		err := walkSnapshotFiles(&Config{}, filepath.Join(tmpDir, name), func(rel string, path string) error {
+			info, err := os.Stat(path)
+			inodes[name+"/"+rel] = info
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Failed to walk snapshot: %v", err)
+		}
+	}
+	err = walkSnapshotFiles(&Config{}, middle, func(rel string, path string) error {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		for other, otherInfo := range inodes {
+			if os.SameFile(info, otherInfo) {
+				t.Errorf("Expected %s not to share an inode with %s", rel, other)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk snapshot: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(middle, "sub", "unchanged.txt"))
+	if err != nil || string(content) != "same" {
+		t.Errorf("Expected the copied file to hold %q, got %q (%v)", "same", content, err)
+	}
+	a, errA := os.Stat(filepath.Join(middle, "changed.txt"))
+	b, errB := os.Stat(filepath.Join(middle, "sub", "alias.txt"))
+	if errA != nil || errB != nil || !os.SameFile(a, b) {
+		t.Errorf("Expected the hard link within the snapshot to be kept")
+	}
+	if target, err := os.Readlink(filepath.Join(middle, "link")); err != nil || target != "changed.txt" {
+		t.Errorf("Expected the symlink to be kept, got %q (%v)", target, err)
+	}
+	if entries, _ := os.ReadDir(tmpDir); len(entries) != 3 {
+		t.Errorf("Expected no leftover copies in the destination, got %v", entries)
+	}
+	if problems, err := verifyLinkChain(context.Background(), config); err != nil || len(problems) != 0 {
+		t.Errorf("Expected the solidified snapshot to be left out of the link chain check, got %v (%v)", problems, err)
+	}
+
+	// With a target directory the snapshot is left alone.
+	copyTo := filepath.Join(tmpDir, "copy")
+	if err := solidifySnapshot(context.Background(), config, names[2], copyTo, false); err != nil {
+		t.Fatalf("solidifySnapshot failed: %v", err)
+	}
+	orig, errA := os.Stat(filepath.Join(tmpDir, names[2], "sub", "unchanged.txt"))
+	copied, errB := os.Stat(filepath.Join(copyTo, "sub", "unchanged.txt"))
+	if errA != nil || errB != nil || os.SameFile(orig, copied) {
+		t.Errorf("Expected an independent copy in %s (%v, %v)", copyTo, errA, errB)
+	}
+
+	if err := solidifySnapshot(context.Background(), config, "test_missing", "", false); err == nil {
+		t.Errorf("Expected an error for a missing snapshot")
+	}
+}
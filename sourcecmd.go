@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// commandSource is a source whose data is the output of a command, such as
+// a database dump, saved to a file in the snapshot.
+type commandSource struct {
+	Command string `yaml:"command" json:"command" toml:"command"`
+	Output  string `yaml:"output" json:"output" toml:"output"`
+}
+
+// source is one item of the source option: either a path for rsync or
+// a command.
+type source struct {
+	Path    string
+	Command *commandSource
+}
+
+func (s *source) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		s.Command = &commandSource{}
+		return node.Decode(s.Command)
+	}
+	return node.Decode(&s.Path)
+}
+
+func (s source) MarshalYAML() (any, error) {
+	if s.Command != nil {
+		return s.Command, nil
+	}
+	return s.Path, nil
+}
+
+func (s *source) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		s.Command = &commandSource{}
+		return json.Unmarshal(data, s.Command)
+	}
+	return json.Unmarshal(data, &s.Path)
+}
+
+func (s *source) UnmarshalTOML(v any) error {
+	switch v := v.(type) {
+	case string:
+		s.Path = v
+	case map[string]any:
+		command, _ := v["command"].(string)
+		output, _ := v["output"].(string)
+		s.Command = &commandSource{Command: command, Output: output}
+	default:
+		return fmt.Errorf("source must be a path or a table with command and output, got %v", v)
+	}
+	return nil
+}
+
+// splitSources sorts the source option into the paths rsync copies and the
+// commands goback runs itself.
+func splitSources(config *Config) {
+	config.Source, config.Commands = nil, nil
+	for _, e := range config.Sources {
+		if e.Command != nil {
+			config.Commands = append(config.Commands, *e.Command)
+		} else {
+			config.Source = append(config.Source, e.Path)
+		}
+	}
+}
+
+// validateCommandSources checks that every command source has a command and
+// writes to a relative path inside the snapshot.
+func validateCommandSources(config *Config) error {
+	if len(config.Commands) > 0 && isRsyncDaemon(config.Destination) {
+		return fmt.Errorf("command sources can't be used with rsync daemon destination %s", config.Destination)
+	}
+	for _, c := range config.Commands {
+		if strings.TrimSpace(c.Command) == "" {
+			return fmt.Errorf("source with output %q has no command", c.Output)
+		}
+		if c.Output == "" {
+			return fmt.Errorf("source command %q has no output", c.Command)
+		}
+		if !filepath.IsLocal(c.Output) {
+			return fmt.Errorf("invalid output %q for source command %q: must be a relative path inside the snapshot", c.Output, c.Command)
+		}
+	}
+	return nil
+}
+
+// runCommandSources runs every command source with sh -c and saves its
+// standard output to its output file under dir. A command that fails fails
+// the backup, since its output is then most likely incomplete.
+func runCommandSources(ctx context.Context, config *Config, dir string, dryRun bool) error {
+	for _, c := range config.Commands {
+		path := filepath.Join(dir, c.Output)
+		if dryRun {
+			log.Info().Str("command", c.Command).Str("path", path).Msg("[Dry Run] Would save command output")
+			continue
+		}
+		log.Info().Str("command", c.Command).Str("path", path).Msg("Saving command output")
+		if err := saveCommandOutput(ctx, c.Command, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveCommandOutput streams the standard output of command into a temporary
+// file next to path and renames it into place once the command succeeded.
+// Writing path in place would change the file every snapshot hard-linked to
+// it, and a failed command would leave a truncated file behind.
+func saveCommandOutput(ctx context.Context, command, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for command output: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create command output file: %w", err)
+	}
+	//nolint:errcheck
+	defer os.Remove(f.Name())
+	//nolint:errcheck
+	defer f.Close()
+
+	var stderr bytes.Buffer
+	cmd := execCommand(ctx, "sh", "-c", command)
+	cmd.Stdout = f
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("source command %q failed: %w: %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write command output: %w", err)
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set command output permissions: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to save command output: %w", err)
+	}
+	return nil
+}
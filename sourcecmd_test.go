@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCommandSources(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{Commands: []commandSource{{Command: "echo hello dump", Output: "dumps/db.sql"}}}
+
+	if err := runCommandSources(context.Background(), config, tmpDir, true); err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "dumps")); !os.IsNotExist(err) {
+		t.Errorf("Dry run wrote output: %v", err)
+	}
+
+	if err := runCommandSources(context.Background(), config, tmpDir, false); err != nil {
+		t.Fatalf("runCommandSources failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(tmpDir, "dumps", "db.sql"))
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if string(data) != "hello dump\n" {
+		t.Errorf("Expected output %q, got %q", "hello dump\n", data)
+	}
+
+	// A snapshot hard-linked to the output keeps its copy.
+	linked := filepath.Join(tmpDir, "linked.sql")
+	if err := os.Link(filepath.Join(tmpDir, "dumps", "db.sql"), linked); err != nil {
+		t.Fatalf("Failed to link output: %v", err)
+	}
+	config.Commands = []commandSource{{Command: "echo new dump", Output: "dumps/db.sql"}}
+	if err := runCommandSources(context.Background(), config, tmpDir, false); err != nil {
+		t.Fatalf("runCommandSources failed: %v", err)
+	}
+	if data, _ := os.ReadFile(linked); string(data) != "hello dump\n" {
+		t.Errorf("Expected the hard-linked copy to be left alone, got %q", data)
+	}
+
+	// A failed command leaves the previous output in place.
+	config.Commands = []commandSource{{Command: "echo partial; echo oops >&2; exit 3", Output: "dumps/db.sql"}}
+	err = runCommandSources(context.Background(), config, tmpDir, false)
+	if err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("Expected an error with the command's stderr, got %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(tmpDir, "dumps", "db.sql")); string(data) != "new dump\n" {
+		t.Errorf("Expected the previous output to be kept after a failure, got %q", data)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(tmpDir, "dumps")); len(entries) != 1 {
+		t.Errorf("Expected no temporary files to be left behind, got %v", entries)
+	}
+
+	protect := "--filter=P /dumps/db.sql"
+	if args := buildRsyncArgs(&Config{Mode: "simple", Commands: config.Commands}, "/dest", "", false); !hasArg(args, protect) {
+		t.Errorf("Expected %s in simple mode rsync args, got %v", protect, args)
+	}
+}
+
+func TestReadConfigCommandSources(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configs := map[string]string{
+		"config.yaml": `
+destination: /tmp/backup
+source:
+  - /tmp/source1
+  - command: echo hi
+    output: db.sql
+`,
+		"config.json": `{
+  "destination": "/tmp/backup",
+  "source": ["/tmp/source1", {"command": "echo hi", "output": "db.sql"}]
+}`,
+		"config.toml": `
+destination = "/tmp/backup"
+source = ["/tmp/source1", {command = "echo hi", output = "db.sql"}]
+`,
+	}
+	for name, data := range configs {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		config, err := readConfig(path)
+		if err != nil {
+			t.Errorf("%s: readConfig failed: %v", name, err)
+			continue
+		}
+		if len(config.Source) != 1 || config.Source[0] != "/tmp/source1" {
+			t.Errorf("%s: expected paths [/tmp/source1], got %v", name, config.Source)
+		}
+		if len(config.Commands) != 1 || config.Commands[0] != (commandSource{Command: "echo hi", Output: "db.sql"}) {
+			t.Errorf("%s: expected one command source, got %+v", name, config.Commands)
+		}
+	}
+
+	for _, output := range []string{"", "../db.sql", "/tmp/db.sql"} {
+		config := &Config{Commands: []commandSource{{Command: "echo hi", Output: output}}}
+		if err := validateConfig(config); err == nil {
+			t.Errorf("Expected output %q to be rejected", output)
+		}
+	}
+}
+
+func TestValidateSchemaCommandSources(t *testing.T) {
+	problems, err := validateSchema("config.yaml", []byte(`
+destination: /tmp/backup
+source:
+  - /tmp/source1
+  - command: echo hi
+    output: db.sql
+`))
+	if err != nil {
+		t.Fatalf("validateSchema failed: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+
+	problems, err = validateSchema("config.yaml", []byte(`
+destination: /tmp/backup
+source:
+  - command: echo hi
+    outptu: db.sql
+`))
+	if err != nil {
+		t.Fatalf("validateSchema failed: %v", err)
+	}
+	got := strings.Join(problems, "\n")
+	if !strings.Contains(got, "source[0].outptu: unknown option") || !strings.Contains(got, "missing required option output") {
+		t.Errorf("Expected unknown and missing option problems, got %v", problems)
+	}
+}
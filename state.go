@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// snapshotStateFile records, in the destination, the snapshots of a
+// configuration that goback left behind after it last changed them, one per
+// line, so snapshots that disappear by other means can be noticed.
+const snapshotStateFile = ".goback-state"
+
+// snapshotStatePath returns the state file of config. Like configSnapshots
+// it goes by the expanded snapshot_name, so configurations that share a
+// prefix but not a host keep separate state.
+func snapshotStatePath(config *Config) (string, error) {
+	key, err := snapshotKey(config)
+	if err != nil {
+		return "", err
+	}
+	name := snapshotStateFile
+	if key != "" {
+		name += "-" + key
+	}
+	return filepath.Join(config.Destination, name), nil
+}
+
+// recordSnapshotState writes the snapshots of config to the state file.
+func recordSnapshotState(ctx context.Context, config *Config) error {
+	path, err := snapshotStatePath(config)
+	if err != nil {
+		return err
+	}
+	snapshots, err := configSnapshots(ctx, config)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, s := range snapshots {
+		fmt.Fprintln(&b, s.Name())
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write snapshot state: %w", err)
+	}
+	return nil
+}
+
+// updateSnapshotState records the snapshots after goback changed them. The
+// change itself succeeded, so failing to record it is only logged.
+func updateSnapshotState(ctx context.Context, config *Config) {
+	if err := recordSnapshotState(ctx, config); err != nil {
+		log.Warn().Err(err).Msg("Could not record snapshot state")
+	}
+}
+
+// missingSnapshots returns the snapshots the state file lists that are no
+// longer in the destination, oldest first. known is false if no state has
+// been recorded yet.
+func missingSnapshots(ctx context.Context, config *Config) (missing []string, known bool, err error) {
+	path, err := snapshotStatePath(config)
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("failed to read snapshot state: %w", err)
+	}
+	snapshots, err := configSnapshots(ctx, config)
+	if err != nil {
+		return nil, true, err
+	}
+	exists := make(map[string]bool)
+	for _, s := range snapshots {
+		exists[s.Name()] = true
+	}
+	for _, name := range strings.Fields(string(data)) {
+		if !exists[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing, true, nil
+}
+
+// warnMissingSnapshots logs the snapshots that disappeared since goback
+// last changed them, which points at tampering or a failing disk.
+func warnMissingSnapshots(ctx context.Context, config *Config) {
+	missing, _, err := missingSnapshots(ctx, config)
+	if err != nil {
+		log.Warn().Err(err).Msg("Could not check snapshot state")
+		return
+	}
+	for _, name := range missing {
+		log.Warn().Str("snapshot", name).Msg("Snapshot disappeared since the last run")
+	}
+}
+
+// stateDir returns the directory goback keeps the state of its runs in:
+// log_dir if set, otherwise $XDG_STATE_HOME/goback, by default
+// ~/.local/state/goback. It is outside the destination, where in simple
+// mode rsync --delete would remove anything that isn't in the sources.
+func stateDir(config *Config) (string, error) {
+	if config.LogDir != "" {
+		return config.LogDir, nil
+	}
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to find a state directory: %w", err)
+		}
+		base = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(base, "goback"), nil
+}
+
+// stateFile returns the path in stateDir of the state file name for
+// config's destination, creating the directory. Each destination sharing
+// the directory gets a file of its own.
+func stateFile(config *Config, name string) (string, error) {
+	dir, err := stateDir(config)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return filepath.Join(dir, name+"-"+url.PathEscape(config.Destination)), nil
+}
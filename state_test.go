@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMissingSnapshots(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	daily, weekly := makePrefixedSnapshots(t, tmpDir)
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "daily"}
+
+	if _, known, err := missingSnapshots(context.Background(), config); err != nil || known {
+		t.Fatalf("Expected no state before the first run, got %v, %v", known, err)
+	}
+
+	if err := recordSnapshotState(context.Background(), config); err != nil {
+		t.Fatalf("recordSnapshotState failed: %v", err)
+	}
+	missing, known, err := missingSnapshots(context.Background(), config)
+	if err != nil || !known || len(missing) != 0 {
+		t.Fatalf("Expected no missing snapshots, got %v, %v, %v", missing, known, err)
+	}
+
+	// Snapshots removed behind goback's back, and one of another prefix,
+	// which has its own state.
+	for _, name := range []string{daily[0], daily[2], weekly[1]} {
+		if err := os.RemoveAll(filepath.Join(tmpDir, name)); err != nil {
+			t.Fatalf("Failed to remove snapshot: %v", err)
+		}
+	}
+	missing, _, err = missingSnapshots(context.Background(), config)
+	if err != nil {
+		t.Fatalf("missingSnapshots failed: %v", err)
+	}
+	if want := []string{daily[0], daily[2]}; !slices.Equal(missing, want) {
+		t.Errorf("Expected missing snapshots %v, got %v", want, missing)
+	}
+
+	// Recording the state again accepts what is on disk.
+	if err := recordSnapshotState(context.Background(), config); err != nil {
+		t.Fatalf("recordSnapshotState failed: %v", err)
+	}
+	if missing, _, _ := missingSnapshots(context.Background(), config); len(missing) != 0 {
+		t.Errorf("Expected no missing snapshots after recording, got %v", missing)
+	}
+}
+
+func TestUpdateSnapshotStateAfterPurge(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	daily, _ := makePrefixedSnapshots(t, tmpDir)
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "daily", Keep: Keep{Daily: 1}, MaxPurgePercent: 100}
+	if err := recordSnapshotState(context.Background(), config); err != nil {
+		t.Fatalf("recordSnapshotState failed: %v", err)
+	}
+
+	// Purged snapshots are expected to be gone once the state is updated,
+	// as runBackup does after purging.
+	if err := purgeBackups(context.Background(), config, false); err != nil {
+		t.Fatalf("purgeBackups failed: %v", err)
+	}
+	if missing, _, _ := missingSnapshots(context.Background(), config); len(missing) != len(daily)-1 {
+		t.Errorf("Expected purged snapshots to be missing before the state is updated, got %v", missing)
+	}
+	updateSnapshotState(context.Background(), config)
+	if missing, _, _ := missingSnapshots(context.Background(), config); len(missing) != 0 {
+		t.Errorf("Expected no missing snapshots after the update, got %v", missing)
+	}
+}
+
+func TestSnapshotStatePerHost(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer func() { hostname = os.Hostname }()
+
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "daily", SnapshotName: "%prefix%_%time%_%host%"}
+	for _, host := range []string{"alpha", "beta"} {
+		if err := os.Mkdir(filepath.Join(tmpDir, "daily_2025-06-28_10:00:00_"+host), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		hostname = func() (string, error) { return host, nil }
+		if err := recordSnapshotState(context.Background(), config); err != nil {
+			t.Fatalf("recordSnapshotState failed: %v", err)
+		}
+	}
+
+	// Each host's state only covers its own snapshots.
+	hostname = func() (string, error) { return "alpha", nil }
+	if err := os.RemoveAll(filepath.Join(tmpDir, "daily_2025-06-28_10:00:00_beta")); err != nil {
+		t.Fatalf("Failed to remove snapshot: %v", err)
+	}
+	if missing, known, err := missingSnapshots(context.Background(), config); err != nil || !known || len(missing) != 0 {
+		t.Errorf("Expected nothing missing for alpha, got %v, %v, %v", missing, known, err)
+	}
+	hostname = func() (string, error) { return "beta", nil }
+	missing, _, err := missingSnapshots(context.Background(), config)
+	if err != nil {
+		t.Fatalf("missingSnapshots failed: %v", err)
+	}
+	if want := []string{"daily_2025-06-28_10:00:00_beta"}; !slices.Equal(missing, want) {
+		t.Errorf("Expected missing snapshots %v, got %v", want, missing)
+	}
+}
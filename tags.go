@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// tagsFile is written into snapshots tagged with -tag and lists their tags,
+// one per line.
+const tagsFile = ".goback-tags"
+
+// snapshotTags returns the tags of snapshot, or nil if it has none.
+// Archives and the snapshots of native backends can't be tagged.
+func snapshotTags(config *Config, snapshot string) []string {
+	data, err := os.ReadFile(filepath.Join(snapshotsDir(config), snapshot, tagsFile))
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(data))
+}
+
+// tagSnapshot adds tags to the tags the snapshot already has and returns
+// them all. Tags follow the rules for labels.
+func tagSnapshot(ctx context.Context, config *Config, snapshot string, tags []string, dryRun bool) ([]string, error) {
+	for _, tag := range tags {
+		if !validLabel.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q: tags must start with a letter or digit and contain only letters, digits, '.', '_' and '-'", tag)
+		}
+	}
+	if _, isArchive := trimArchiveSuffix(snapshot); isArchive || nativeBackend(config) {
+		return nil, fmt.Errorf("only snapshot directories of the hardlink backend can be tagged")
+	}
+	snapshots, err := configSnapshots(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.ContainsFunc(snapshots, func(s os.FileInfo) bool { return s.Name() == snapshot }) {
+		return nil, fmt.Errorf("no snapshot named %s in %s", snapshot, config.Destination)
+	}
+
+	all := snapshotTags(config, snapshot)
+	for _, tag := range tags {
+		if !slices.Contains(all, tag) {
+			all = append(all, tag)
+		}
+	}
+	dir := filepath.Join(snapshotsDir(config), snapshot)
+	if dryRun {
+		log.Info().Str("snapshot", snapshot).Strs("tags", all).Msg("[Dry Run] Would tag snapshot")
+		return all, nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to tag snapshot: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, tagsFile), []byte(strings.Join(all, "\n")+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to tag snapshot: %w", err)
+	}
+	// The snapshot's modification time drives retention.
+	if err := os.Chtimes(dir, info.ModTime(), info.ModTime()); err != nil {
+		return nil, fmt.Errorf("failed to restore snapshot time: %w", err)
+	}
+	log.Info().Str("snapshot", snapshot).Strs("tags", all).Msg("Tagged snapshot")
+	return all, nil
+}
+
+// protectingTag returns the first protect_tagged tag snapshot carries, or ""
+// if there is none.
+func protectingTag(config *Config, snapshot string) string {
+	if len(config.ProtectTagged) == 0 {
+		return ""
+	}
+	tags := snapshotTags(config, snapshot)
+	for _, tag := range config.ProtectTagged {
+		if slices.Contains(tags, tag) {
+			return tag
+		}
+	}
+	return ""
+}
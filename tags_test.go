@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTagSnapshot(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	daily, _ := makePrefixedSnapshots(t, tmpDir)
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "daily"}
+
+	if _, err := tagSnapshot(context.Background(), config, daily[0], []string{"milestone"}, true); err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if tags := snapshotTags(config, daily[0]); tags != nil {
+		t.Errorf("Dry run tagged the snapshot: %v", tags)
+	}
+
+	if _, err := tagSnapshot(context.Background(), config, daily[0], []string{"milestone"}, false); err != nil {
+		t.Fatalf("tagSnapshot failed: %v", err)
+	}
+	tags, err := tagSnapshot(context.Background(), config, daily[0], []string{"release-1.2", "milestone"}, false)
+	if err != nil {
+		t.Fatalf("tagSnapshot failed: %v", err)
+	}
+	want := []string{"milestone", "release-1.2"}
+	if !slices.Equal(tags, want) {
+		t.Errorf("Expected tags %v, got %v", want, tags)
+	}
+	if got := snapshotTags(config, daily[0]); !slices.Equal(got, want) {
+		t.Errorf("Expected stored tags %v, got %v", want, got)
+	}
+
+	for _, tc := range []struct {
+		snapshot string
+		tags     []string
+	}{
+		{daily[1], []string{"two words"}},
+		{daily[1], []string{".hidden"}},
+		{"daily_1999-01-01_00:00:00", []string{"milestone"}},
+		{daily[1] + ".tar.zst", []string{"milestone"}},
+	} {
+		if _, err := tagSnapshot(context.Background(), config, tc.snapshot, tc.tags, false); err == nil {
+			t.Errorf("Expected tagging %s with %v to fail", tc.snapshot, tc.tags)
+		}
+	}
+}
+
+func TestListSnapshotsTagFilter(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	daily, weekly := makePrefixedSnapshots(t, tmpDir)
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "daily"}
+	if _, err := tagSnapshot(context.Background(), config, daily[1], []string{"milestone", "release-1.2"}, false); err != nil {
+		t.Fatalf("tagSnapshot failed: %v", err)
+	}
+	weeklyConfig := &Config{Destination: tmpDir, SnapshotPrefix: "weekly"}
+	if _, err := tagSnapshot(context.Background(), weeklyConfig, weekly[2], []string{"milestone"}, false); err != nil {
+		t.Fatalf("tagSnapshot failed: %v", err)
+	}
+
+	var out strings.Builder
+	if err := listSnapshots(context.Background(), config, true, false, "milestone", &out); err != nil {
+		t.Fatalf("listSnapshots failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], daily[1]) || !strings.Contains(lines[2], weekly[2]) {
+		t.Errorf("Expected only the snapshots tagged milestone, got:\n%s", out.String())
+	}
+	if !strings.Contains(lines[1], "milestone,release-1.2") {
+		t.Errorf("Expected the tags to be listed, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := listSnapshots(context.Background(), config, false, false, "nightly", &out); err != nil {
+		t.Fatalf("listSnapshots failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "No snapshots found.") {
+		t.Errorf("Expected no snapshots tagged nightly, got:\n%s", out.String())
+	}
+}
+
+func TestPurgeBackupsProtectTagged(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	daily, _ := makePrefixedSnapshots(t, tmpDir)
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "daily", Keep: Keep{Daily: 1}, MaxPurgePercent: 100, ProtectTagged: []string{"milestone"}}
+	if _, err := tagSnapshot(context.Background(), config, daily[0], []string{"milestone"}, false); err != nil {
+		t.Fatalf("tagSnapshot failed: %v", err)
+	}
+	if _, err := tagSnapshot(context.Background(), config, daily[1], []string{"scratch"}, false); err != nil {
+		t.Fatalf("tagSnapshot failed: %v", err)
+	}
+
+	if err := purgeBackups(context.Background(), config, false); err != nil {
+		t.Fatalf("purgeBackups failed: %v", err)
+	}
+	for i, name := range daily {
+		_, err := os.Stat(filepath.Join(tmpDir, name))
+		if i == 1 {
+			if !os.IsNotExist(err) {
+				t.Errorf("Expected %s without a protected tag to be purged", name)
+			}
+		} else if err != nil {
+			t.Errorf("Expected %s to be kept, got %v", name, err)
+		}
+	}
+
+	err = deleteSnapshots(context.Background(), config, []string{daily[0]}, false, false)
+	if err == nil || !strings.Contains(err.Error(), "protect_tagged milestone") {
+		t.Errorf("Expected deleting a tagged snapshot to be refused, got %v", err)
+	}
+
+	if err := validateConfig(&Config{ProtectTagged: []string{"bad tag"}}); err == nil {
+		t.Error("Expected an error for an invalid protect_tagged tag")
+	}
+}
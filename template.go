@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// templateData is what the templated config fields can refer to, e.g.
+// "/backups/{{.Hostname}}" or "{{.Env.USER}}".
+type templateData struct {
+	Hostname string
+	Date     string // the date of the run, as 2006-01-02
+	Env      map[string]string
+}
+
+func newTemplateData() (templateData, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return templateData{}, fmt.Errorf("failed to get hostname: %w", err)
+	}
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	return templateData{Hostname: host, Date: timeNow().Format("2006-01-02"), Env: env}, nil
+}
+
+// renderConfigTemplates expands the Go templates in destination,
+// snapshot_prefix, source and exclude, so one config can be shared by many
+// hosts.
+func renderConfigTemplates(config *Config) error {
+	var data *templateData
+	render := func(field, value string) (string, error) {
+		if !strings.Contains(value, "{{") {
+			return value, nil
+		}
+		if data == nil {
+			d, err := newTemplateData()
+			if err != nil {
+				return "", err
+			}
+			data = &d
+		}
+		tmpl, err := template.New(field).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid template in %s: %w", field, err)
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return "", fmt.Errorf("failed to render %s: %w", field, err)
+		}
+		return b.String(), nil
+	}
+
+	var err error
+	if config.Destination, err = render("destination", config.Destination); err != nil {
+		return err
+	}
+	if config.SnapshotPrefix, err = render("snapshot_prefix", config.SnapshotPrefix); err != nil {
+		return err
+	}
+	for i := range config.Source {
+		if config.Source[i], err = render("source", config.Source[i]); err != nil {
+			return err
+		}
+	}
+	for i := range config.Exclude {
+		if config.Exclude[i], err = render("exclude", config.Exclude[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
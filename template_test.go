@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRenderConfigTemplates(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	t.Setenv("GOBACK_TEST_USER", "alice")
+	timeNow = func() time.Time { return time.Date(2025, time.June, 28, 2, 0, 0, 0, time.Local) }
+	defer func() { timeNow = time.Now }()
+
+	configFile := filepath.Join(tmpDir, "config.yaml")
+	configData := `destination: /backups/{{.Hostname}}
+snapshot_prefix: "{{.Hostname}}"
+source:
+  - /home/{{.Env.GOBACK_TEST_USER}}
+exclude:
+  - "*.tmp"
+  - "report-{{.Date}}.csv"
+`
+	if err := os.WriteFile(configFile, []byte(configData), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	config, err := readConfig(configFile)
+	if err != nil {
+		t.Fatalf("readConfig failed: %v", err)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("Failed to get hostname: %v", err)
+	}
+	if want := "/backups/" + host; config.Destination != want {
+		t.Errorf("Expected destination %s, got %s", want, config.Destination)
+	}
+	if config.SnapshotPrefix != host {
+		t.Errorf("Expected snapshot_prefix %s, got %s", host, config.SnapshotPrefix)
+	}
+	if config.Source[0] != "/home/alice" || config.Exclude[0] != "*.tmp" || config.Exclude[1] != "report-2025-06-28.csv" {
+		t.Errorf("Unexpected source %v or exclude %v", config.Source, config.Exclude)
+	}
+}
+
+func TestRenderConfigTemplatesErrors(t *testing.T) {
+	for _, dest := range []string{"/backups/{{.Hostname", "/backups/{{.Env.GOBACK_TEST_UNSET}}", "/backups/{{.Nope}}"} {
+		if err := renderConfigTemplates(&Config{Destination: dest}); err == nil {
+			t.Errorf("Expected an error rendering %q", dest)
+		}
+	}
+}
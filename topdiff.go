@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// topLevelName returns the first component of a path in rsync's output,
+// and whether the path is that component itself.
+func topLevelName(path string) (string, bool) {
+	path = strings.TrimSuffix(path, "/")
+	name, _, nested := strings.Cut(path, "/")
+	return name, !nested
+}
+
+// sourceTopLevel returns the names the sources put at the top of a
+// snapshot: the contents of a source ending in a slash, and the source
+// itself otherwise.
+func sourceTopLevel(sources []string) map[string]bool {
+	names := make(map[string]bool)
+	for _, src := range sources {
+		if !strings.HasSuffix(src, "/") {
+			if _, err := os.Lstat(src); err == nil {
+				names[filepath.Base(src)] = true
+			}
+			continue
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			continue // rsync reports unreadable sources
+		}
+		for _, e := range entries {
+			names[e.Name()] = true
+		}
+	}
+	return names
+}
+
+// dryRunTopLevelDiff compares the top level of the latest snapshot with what
+// the new snapshot would hold. Entries missing from the sources would be
+// gone, and entries rsync would create that the latest snapshot doesn't have
+// would be new. Excluded entries are never itemized, so they don't show up
+// as additions.
+func dryRunTopLevelDiff(config *Config, latest string, created map[string]bool) ([]string, error) {
+	entries, err := os.ReadDir(latest)
+	if err != nil {
+		return nil, err
+	}
+	var before []string
+	inBefore := make(map[string]bool)
+	for _, e := range entries {
+		if !skipChecksum(config, e.Name()) {
+			before = append(before, e.Name())
+			inBefore[e.Name()] = true
+		}
+	}
+	var after []string
+	for name := range sourceTopLevel(config.Source) {
+		if inBefore[name] || created[name] {
+			after = append(after, name)
+		}
+	}
+	return topLevelDiff(before, after), nil
+}
+
+// printTopLevelDiff writes the dryRunTopLevelDiff to w.
+func printTopLevelDiff(w io.Writer, config *Config, latest string, created map[string]bool) error {
+	diff, err := dryRunTopLevelDiff(config, latest, created)
+	if err != nil || len(diff) == 0 {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Top level changes from %s:\n", filepath.Base(latest)); err != nil {
+		return err
+	}
+	for _, line := range diff {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// topLevelDiff returns "- name" for every name only in before and "+ name"
+// for every name only in after, sorted by name.
+func topLevelDiff(before, after []string) []string {
+	inBefore := make(map[string]bool)
+	for _, name := range before {
+		inBefore[name] = true
+	}
+	inAfter := make(map[string]bool)
+	for _, name := range after {
+		inAfter[name] = true
+	}
+
+	var names []string
+	for name := range inBefore {
+		if !inAfter[name] {
+			names = append(names, name)
+		}
+	}
+	for name := range inAfter {
+		if !inBefore[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	diff := make([]string, len(names))
+	for i, name := range names {
+		if inBefore[name] {
+			diff[i] = "- " + name
+		} else {
+			diff[i] = "+ " + name
+		}
+	}
+	return diff
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTopLevelDiff(t *testing.T) {
+	got := topLevelDiff([]string{"docs", "music", "old.iso", "photos"}, []string{"photos", "docs", "new", "music"})
+	want := []string{"+ new", "- old.iso"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got := topLevelDiff([]string{"a"}, []string{"a"}); len(got) != 0 {
+		t.Errorf("Expected no differences, got %q", got)
+	}
+}
+
+func TestDryRunTopLevelDiff(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	source := filepath.Join(tmpDir, "src")
+	latest := filepath.Join(tmpDir, "test_2025-10-18_13:14:20")
+	for _, path := range []string{
+		filepath.Join(source, "docs"), filepath.Join(source, "new"), filepath.Join(source, "cache"),
+		filepath.Join(latest, "docs"), filepath.Join(latest, "removed"),
+	} {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(latest, "rsync.log"), nil, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// rsync's itemized output for the dry run. The excluded cache directory
+	// doesn't appear.
+	w := &rsyncOutputWriter{out: io.Discard, created: make(map[string]bool)}
+	if _, err := io.WriteString(w, "cd+++++++++ docs/\n>f+++++++++ docs/a.txt\ncd+++++++++ new/\n>f+++++++++ new/b.txt\n"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	config := &Config{Source: []string{source + "/"}}
+	var out strings.Builder
+	if err := printTopLevelDiff(&out, config, latest, w.created); err != nil {
+		t.Fatalf("printTopLevelDiff failed: %v", err)
+	}
+	want := "Top level changes from test_2025-10-18_13:14:20:\n+ new\n- removed\n"
+	if out.String() != want {
+		t.Errorf("Expected %q, got %q", want, out.String())
+	}
+}
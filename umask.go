@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"syscall"
+)
+
+// parseUmask parses an octal umask such as "027".
+func parseUmask(s string) (int, error) {
+	mask, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mask > 0777 {
+		return 0, fmt.Errorf("invalid umask %q: must be an octal mode such as 027", s)
+	}
+	return int(mask), nil
+}
+
+// applyUmask sets the process umask from the config, if one is set. rsync
+// inherits it.
+func applyUmask(config *Config) {
+	if config.Umask == "" {
+		return
+	}
+	// validateConfig has already checked the umask.
+	mask, _ := parseUmask(config.Umask)
+	syscall.Umask(mask)
+}
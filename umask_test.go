@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestApplyUmask(t *testing.T) {
+	assumeSourcesExist(t)
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var gotArgs []string
+	execCommand = fakeRsync(&gotArgs, "", 0)
+	defer func() { execCommand = exec.CommandContext }()
+
+	config := &Config{Destination: filepath.Join(tmpDir, "dest"), SnapshotPrefix: "test", Source: []string{"/tmp/source1"}, Umask: "077"}
+	if err := validateConfig(config); err != nil {
+		t.Fatalf("validateConfig failed: %v", err)
+	}
+	old := syscall.Umask(0022)
+	defer syscall.Umask(old)
+	applyUmask(config)
+
+	if err := runSnapshotBackup(context.Background(), config, runOptions{}, false); err != nil {
+		t.Fatalf("runSnapshotBackup failed: %v", err)
+	}
+	snapshot, err := getLatestSnapshot(context.Background(), config)
+	if err != nil || snapshot == "" {
+		t.Fatalf("Failed to find snapshot: %v", err)
+	}
+	for _, dir := range []string{config.Destination, filepath.Join(config.Destination, snapshot)} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", dir, err)
+		}
+		if perm := info.Mode().Perm(); perm != 0700 {
+			t.Errorf("Expected %s to have mode 0700 under umask 077, got %o", dir, perm)
+		}
+	}
+
+	for _, bad := range []string{"8", "rwx", "1000"} {
+		config.Umask = bad
+		if err := validateConfig(config); err == nil {
+			t.Errorf("Expected umask %q to be rejected", bad)
+		}
+	}
+}
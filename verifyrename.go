@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// differingFiles returns the files in rsync's itemized dry-run output that
+// are in the snapshot but whose checksum or size differs. Deletions and
+// attribute-only changes are left out, since goback adds files of its own,
+// such as rsync.log, to snapshots. New files are left out too: they were
+// created after the backup or held back by min_file_age.
+func differingFiles(output string) []string {
+	var files []string
+	for _, line := range strings.Split(output, "\n") {
+		m := itemizedLine.FindStringSubmatch(line)
+		if m == nil || m[1] == "*deleting" || m[1][1] != 'f' || m[1][2] == '+' {
+			continue
+		}
+		if m[1][2] != 'c' && m[1][3] != 's' {
+			continue
+		}
+		files = append(files, strings.TrimSpace(line[len(m[0]):]))
+	}
+	return files
+}
+
+// verifyAgainstSource compares the contents of every file in the sources
+// with snapshot dir using rsync --checksum in dry-run mode, logs each file
+// that differs and fails if there are any. Files that changed in the source
+// while the backup ran count as differences too.
+func verifyAgainstSource(ctx context.Context, config *Config, dir string) error {
+	log.Info().Str("path", dir).Msg("Verifying snapshot against the source")
+	out, err := itemizedDryRun(ctx, config, dir, "--checksum")
+	if err != nil {
+		return fmt.Errorf("failed to verify snapshot: %w", err)
+	}
+	files := differingFiles(out)
+	for _, f := range files {
+		log.Error().Str("path", f).Str("snapshot", dir).Msg("File in snapshot differs from the source")
+	}
+	if len(files) > 0 {
+		return fmt.Errorf("%d files in snapshot %s differ from the source", len(files), dir)
+	}
+	log.Info().Str("path", dir).Msg("Snapshot matches the source")
+	return nil
+}
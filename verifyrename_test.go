@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestVerifyAgainstSource(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	snapshot := filepath.Join(tmpDir, "test_2024-01-02T03:04:05")
+	config := &Config{Destination: tmpDir, SnapshotPrefix: "test", Source: []string{"/home/user"}}
+
+	tests := []struct {
+		name    string
+		output  string
+		wantErr bool
+	}{
+		// goback's own files only show up as deletions, and attribute-only
+		// changes don't count.
+		{"consistent", "sending incremental file list\n*deleting   rsync.log\n.d..t...... user/\n", false},
+		{"tampered", "sending incremental file list\n>fc........ user/notes.txt\n", true},
+		// Files the snapshot lacks appeared after the backup or were held
+		// back by min_file_age.
+		{"new", "sending incremental file list\n>f+++++++++ user/new.txt\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotArgs []string
+			execCommand = fakeRsync(&gotArgs, tt.output, 0)
+			defer func() { execCommand = exec.CommandContext }()
+
+			err := verifyAgainstSource(context.Background(), config, snapshot)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyAgainstSource() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, arg := range []string{"--checksum", "--dry-run", "--itemize-changes"} {
+				if !hasArg(gotArgs, arg) {
+					t.Errorf("Expected %s in %v", arg, gotArgs)
+				}
+			}
+			if !slices.Contains(gotArgs, snapshot) {
+				t.Errorf("Expected comparison against %s, got %v", snapshot, gotArgs)
+			}
+		})
+	}
+}
+
+func TestDifferingFiles(t *testing.T) {
+	output := ">fcst...... a.txt\n" +
+		"cf......... b.txt\n" +
+		".f...p..... c.txt\n" +
+		"cL+++++++++ link -> a.txt\n" +
+		"*deleting   d.txt\n" +
+		">f.st...... e.txt\n" +
+		">f+++++++++ new.txt\n"
+	got := differingFiles(output)
+	if !slices.Equal(got, []string{"a.txt", "e.txt"}) {
+		t.Errorf("Expected [a.txt e.txt], got %v", got)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultWatchDebounce is how long -watch waits for changes to settle when
+// watch_debounce isn't set.
+const defaultWatchDebounce = 30 * time.Second
+
+// watchDebounce returns watch_debounce, which validateConfig has checked.
+func watchDebounce(config *Config) time.Duration {
+	if config.WatchDebounce == "" {
+		return defaultWatchDebounce
+	}
+	d, _ := time.ParseDuration(config.WatchDebounce)
+	return d
+}
+
+// watchTimer is the timer watchSources waits on for changes to settle.
+type watchTimer interface {
+	Chan() <-chan time.Time
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+type realWatchTimer struct{ *time.Timer }
+
+func (t realWatchTimer) Chan() <-chan time.Time { return t.C }
+
+// newWatchTimer returns a stopped watchTimer; tests swap it for a timer they
+// fire themselves.
+var newWatchTimer = func() watchTimer {
+	t := time.NewTimer(time.Hour)
+	t.Stop()
+	return realWatchTimer{t}
+}
+
+// startWatchedRun gives each backup -watch runs a run ID of its own, so its
+// log lines and rsync log can be told apart from those of the others.
+func startWatchedRun(config *Config) {
+	runID = newRunID()
+	setupLogging(os.Stdout, config)
+}
+
+// watchSources watches the source trees and calls backup once no change has
+// been seen for debounce, so a burst of changes leads to a single backup.
+// Changes made while a backup runs lead to another one after it. Failed
+// backups are logged and watching goes on until ctx is cancelled.
+func watchSources(ctx context.Context, config *Config, debounce time.Duration, backup func(context.Context) error) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to start watching: %w", err)
+	}
+	defer watcher.Close()
+
+	for _, src := range config.Source {
+		if err := watchTree(watcher, config, src, src); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", src, err)
+		}
+	}
+	log.Info().Strs("source", config.Source).Dur("debounce", debounce).Msg("Watching sources for changes")
+
+	timer := newWatchTimer()
+	var pending, running bool
+	done := make(chan error, 1)
+	changed := func() {
+		pending = true
+		if !running {
+			timer.Reset(debounce)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if running {
+				<-done
+			}
+			return ctx.Err()
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if insideDestination(config, ev.Name) {
+				continue
+			}
+			if ev.Has(fsnotify.Create) {
+				if info, err := os.Lstat(ev.Name); err == nil && info.IsDir() {
+					if err := watchTree(watcher, config, ev.Name, ""); err != nil {
+						log.Warn().Err(err).Str("path", ev.Name).Msg("Could not watch new directory")
+					}
+				}
+			}
+			changed()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			// Changes may have been missed, so back up to be safe.
+			log.Warn().Err(err).Msg("Watching sources failed")
+			changed()
+		case <-timer.Chan():
+			pending, running = false, true
+			// Nothing else logs while the run's logger is set up.
+			startWatchedRun(config)
+			go func() { done <- backup(ctx) }()
+		case err := <-done:
+			running = false
+			if err != nil && !errors.Is(err, context.Canceled) {
+				log.Error().Err(err).Msg("Backup failed, still watching")
+			}
+			if pending {
+				timer.Reset(debounce)
+			}
+		}
+	}
+}
+
+// watchTree adds root and every directory below it to watcher. With src, the
+// source root is in, directories the exclude rules skip are left out; like
+// findLargeFiles, rsync's "**" and character classes aren't understood.
+// Directories created after watching started are watched whole.
+func watchTree(watcher *fsnotify.Watcher, config *Config, root, src string) error {
+	anchor := sourceAnchor(src)
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && p != root {
+				return nil // removed while walking
+			}
+			return err
+		}
+		if !d.IsDir() {
+			if p == root {
+				return watcher.Add(p)
+			}
+			return nil
+		}
+		if p != root && insideDestination(config, p) {
+			return filepath.SkipDir
+		}
+		if src != "" && p != root {
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			if excludedByRules(strings.TrimSuffix(anchor+filepath.ToSlash(rel), "/"), true, config.Exclude) {
+				return filepath.SkipDir
+			}
+		}
+		return watcher.Add(p)
+	})
+}
+
+// insideDestination reports whether path is in the destination, log_dir or
+// the state directory, whose changes are goback's own.
+func insideDestination(config *Config, path string) bool {
+	state, _ := stateDir(config)
+	for _, dir := range []string{config.Destination, config.LogDir, state} {
+		if dir == "" || isRsyncDaemon(dir) {
+			continue
+		}
+		if path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
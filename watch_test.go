@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeWatchTimer is a watchTimer the test fires itself. Every Reset is
+// reported on armed.
+type fakeWatchTimer struct {
+	c     chan time.Time
+	armed chan time.Duration
+}
+
+func (t *fakeWatchTimer) Chan() <-chan time.Time { return t.c }
+
+func (t *fakeWatchTimer) Reset(d time.Duration) bool {
+	t.armed <- d
+	return true
+}
+
+func (t *fakeWatchTimer) Stop() bool { return true }
+
+func TestWatchSourcesDebounce(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	src := filepath.Join(tmpDir, "source")
+	dest := filepath.Join(src, "backups")
+	for _, dir := range []string{filepath.Join(src, "docs"), filepath.Join(src, "cache"), dest} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+	config := &Config{Source: []string{src}, Destination: dest, Exclude: []string{"cache/"}}
+
+	// The timer is created once the sources are being watched.
+	timer := &fakeWatchTimer{c: make(chan time.Time), armed: make(chan time.Duration, 100)}
+	ready := make(chan struct{})
+	newWatchTimer = func() watchTimer {
+		close(ready)
+		return timer
+	}
+	oldRunID := runID
+	defer func() {
+		newWatchTimer = func() watchTimer { return realWatchTimer{time.NewTimer(0)} }
+		runID = oldRunID
+		setupLogging(os.Stdout, &Config{})
+	}()
+
+	const debounce = 30 * time.Second
+	started := make(chan string)
+	finish := make(chan error)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- watchSources(ctx, config, debounce, func(context.Context) error {
+			started <- runID
+			return <-finish
+		})
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Errorf("Expected watchSources to stop with context.Canceled, got %v", err)
+		}
+	}()
+	<-ready
+
+	write := func(path string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	waitArmed := func(what string) {
+		t.Helper()
+		select {
+		case d := <-timer.armed:
+			if d != debounce {
+				t.Errorf("Expected the timer to be set to %v, got %v", debounce, d)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected the debounce timer to be set after %s", what)
+		}
+	}
+	drainArmed := func() {
+		for {
+			select {
+			case <-timer.armed:
+			default:
+				return
+			}
+		}
+	}
+	fire := func() string {
+		t.Helper()
+		timer.c <- time.Now()
+		select {
+		case id := <-started:
+			return id
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected a backup once the timer fired")
+			return ""
+		}
+	}
+
+	// Neither the destination nor excluded directories count as changes.
+	write(filepath.Join(dest, "snapshot"))
+	write(filepath.Join(src, "cache", "tmp"))
+	select {
+	case <-timer.armed:
+		t.Fatal("Expected no debounce for ignored changes")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// A burst of changes only pushes the timer back; the backup waits for
+	// it to fire.
+	for i := 0; i < 5; i++ {
+		write(filepath.Join(src, "docs", fmt.Sprintf("file%d", i)))
+	}
+	waitArmed("a burst of changes")
+	select {
+	case <-started:
+		t.Fatal("Expected no backup before the timer fired")
+	default:
+	}
+	first := fire()
+	drainArmed()
+
+	// A change during the backup leads to another one after it, with a
+	// run ID of its own.
+	write(filepath.Join(src, "docs", "during"))
+	time.Sleep(100 * time.Millisecond)
+	finish <- nil
+	waitArmed("a change during the backup")
+	if second := fire(); second == first {
+		t.Errorf("Expected each backup to get its own run ID, got %s twice", first)
+	}
+	finish <- nil
+	drainArmed()
+
+	// Directories created while watching are watched too.
+	newDir := filepath.Join(src, "new")
+	if err := os.Mkdir(newDir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	waitArmed("creating a directory")
+	fire()
+	finish <- nil
+	time.Sleep(100 * time.Millisecond)
+	drainArmed()
+	write(filepath.Join(newDir, "file"))
+	waitArmed("changing a file in the new directory")
+	fire()
+	finish <- nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// runHistoryFile keeps the durations of the most recent runs, one per line,
+// in the stateFile of the destination.
+const runHistoryFile = ".goback-durations"
+
+// runHistorySize is the number of past runs the average is taken over.
+const runHistorySize = 5
+
+// averageDuration returns the mean of durations, or 0 if there are none.
+func averageDuration(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+	return total / time.Duration(len(durations))
+}
+
+// slowRun reports whether d took longer than factor times the average of
+// the previous runs. Without any history there is nothing to compare with.
+func slowRun(history []time.Duration, d time.Duration, factor float64) bool {
+	if len(history) == 0 || factor <= 0 {
+		return false
+	}
+	return float64(d) > factor*float64(averageDuration(history))
+}
+
+func readRunHistory(path string) ([]time.Duration, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var history []time.Duration
+	for _, line := range strings.Fields(string(data)) {
+		d, err := time.ParseDuration(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q in %s: %w", line, path, err)
+		}
+		history = append(history, d)
+	}
+	return history, nil
+}
+
+// recordRunDuration warns if the run that took d was unusually slow compared
+// with the last runHistorySize runs, then adds it to the history.
+func recordRunDuration(config *Config, d time.Duration) error {
+	path, err := stateFile(config, runHistoryFile)
+	if err != nil {
+		return err
+	}
+	history, err := readRunHistory(path)
+	if err != nil {
+		return fmt.Errorf("failed to read run history: %w", err)
+	}
+
+	if slowRun(history, d, config.SlowRunFactor) {
+		log.Warn().Str("duration", formatDuration(d)).Str("average", formatDuration(averageDuration(history))).
+			Float64("slow_run_factor", config.SlowRunFactor).Msg("Backup took unusually long")
+	}
+
+	history = append(history, d)
+	if len(history) > runHistorySize {
+		history = history[len(history)-runHistorySize:]
+	}
+	var b strings.Builder
+	for _, h := range history {
+		fmt.Fprintln(&b, h)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write run history: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAverageDuration(t *testing.T) {
+	if got := averageDuration(nil); got != 0 {
+		t.Errorf("Expected 0 for no runs, got %v", got)
+	}
+	history := []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute}
+	if got := averageDuration(history); got != 2*time.Minute {
+		t.Errorf("Expected 2m, got %v", got)
+	}
+}
+
+func TestSlowRun(t *testing.T) {
+	history := []time.Duration{10 * time.Minute, 10 * time.Minute, 13 * time.Minute, 7 * time.Minute, 10 * time.Minute}
+	tests := []struct {
+		d      time.Duration
+		factor float64
+		want   bool
+	}{
+		{15 * time.Minute, 2, false},
+		{20 * time.Minute, 2, false},
+		{21 * time.Minute, 2, true},
+		{31 * time.Minute, 3, true},
+		{31 * time.Minute, 0, false},
+	}
+	for _, tc := range tests {
+		if got := slowRun(history, tc.d, tc.factor); got != tc.want {
+			t.Errorf("slowRun(%v, factor %v) = %v, want %v", tc.d, tc.factor, got, tc.want)
+		}
+	}
+	if slowRun(nil, time.Hour, 2) {
+		t.Error("Expected no warning without history")
+	}
+}
+
+func TestRecordRunDuration(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "goback-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	t.Setenv("XDG_STATE_HOME", filepath.Join(tmpDir, "state"))
+
+	config := &Config{Destination: filepath.Join(tmpDir, "dest"), SlowRunFactor: 2}
+	for i := 1; i <= runHistorySize+2; i++ {
+		if err := recordRunDuration(config, time.Duration(i)*time.Minute); err != nil {
+			t.Fatalf("recordRunDuration failed: %v", err)
+		}
+	}
+
+	path, err := stateFile(config, runHistoryFile)
+	if err != nil {
+		t.Fatalf("stateFile failed: %v", err)
+	}
+	if want := filepath.Join(tmpDir, "state", "goback"); filepath.Dir(path) != want {
+		t.Errorf("Expected the history in %s, got %s", want, path)
+	}
+	history, err := readRunHistory(path)
+	if err != nil {
+		t.Fatalf("readRunHistory failed: %v", err)
+	}
+	if len(history) != runHistorySize || history[0] != 3*time.Minute || history[runHistorySize-1] != 7*time.Minute {
+		t.Errorf("Expected the last %d runs, got %v", runHistorySize, history)
+	}
+
+	// With log_dir the history is kept there, apart from other
+	// destinations'.
+	config.LogDir = filepath.Join(tmpDir, "logs")
+	if err := recordRunDuration(config, time.Minute); err != nil {
+		t.Fatalf("recordRunDuration failed: %v", err)
+	}
+	other := &Config{Destination: filepath.Join(tmpDir, "other"), LogDir: config.LogDir, SlowRunFactor: 2}
+	if err := recordRunDuration(other, time.Hour); err != nil {
+		t.Fatalf("recordRunDuration failed: %v", err)
+	}
+	if entries, _ := os.ReadDir(config.LogDir); len(entries) != 2 {
+		t.Errorf("Expected a history per destination in log_dir, got %v", entries)
+	}
+}
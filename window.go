@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Window is a maintenance window that backups are limited to, such as
+// 22:00 to 06:00. A window whose end is not after its start ends the next
+// day. Days, if set, are the weekdays a window may start on.
+type Window struct {
+	Start string   `yaml:"start" json:"start" toml:"start"`
+	End   string   `yaml:"end" json:"end" toml:"end"`
+	Days  []string `yaml:"days" json:"days" toml:"days"`
+}
+
+// windowTimeFormat is the format of a window's start and end.
+const windowTimeFormat = "15:04"
+
+// weekdays maps the accepted day names to weekdays.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// enabled reports whether a window is configured.
+func (w Window) enabled() bool {
+	return w.Start != "" || w.End != ""
+}
+
+// validate checks the window's times and days.
+func (w Window) validate() error {
+	if !w.enabled() {
+		if len(w.Days) > 0 {
+			return fmt.Errorf("maintenance_window.days needs a start and end")
+		}
+		return nil
+	}
+	start, err := time.Parse(windowTimeFormat, w.Start)
+	if err != nil {
+		return fmt.Errorf("invalid maintenance_window.start %q: must be a time such as 22:00", w.Start)
+	}
+	end, err := time.Parse(windowTimeFormat, w.End)
+	if err != nil {
+		return fmt.Errorf("invalid maintenance_window.end %q: must be a time such as 06:00", w.End)
+	}
+	if start.Equal(end) {
+		return fmt.Errorf("maintenance_window.start and end must differ")
+	}
+	for _, day := range w.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("invalid maintenance_window day %q: must be a weekday such as mon", day)
+		}
+	}
+	return nil
+}
+
+// allows reports whether a window may start on day.
+func (w Window) allows(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// at returns the window that is open at t or, if none is, the next one to
+// open. The window must be valid.
+func (w Window) at(t time.Time) (start, end time.Time) {
+	s, _ := time.Parse(windowTimeFormat, w.Start)
+	e, _ := time.Parse(windowTimeFormat, w.End)
+	// A window that started yesterday may still be open.
+	for i := -1; i <= 7; i++ {
+		day := t.AddDate(0, 0, i)
+		start = time.Date(day.Year(), day.Month(), day.Day(), s.Hour(), s.Minute(), 0, 0, t.Location())
+		end = time.Date(day.Year(), day.Month(), day.Day(), e.Hour(), e.Minute(), 0, 0, t.Location())
+		if !end.After(start) {
+			end = end.AddDate(0, 0, 1)
+		}
+		if w.allows(start.Weekday()) && t.Before(end) {
+			return start, end
+		}
+	}
+	return time.Time{}, time.Time{}
+}
+
+// checkMaintenanceWindow reports whether a backup may start now. If it may,
+// end is when the window closes, or zero without a window. Otherwise next is
+// when the window opens.
+func checkMaintenanceWindow(config *Config) (ok bool, end, next time.Time) {
+	w := config.MaintenanceWindow
+	if !w.enabled() {
+		return true, time.Time{}, time.Time{}
+	}
+	now := timeNow()
+	start, end := w.at(now)
+	if now.Before(start) {
+		return false, time.Time{}, start
+	}
+	return true, end, time.Time{}
+}
+
+// warnAtWindowEnd logs a warning if the run is still going when the
+// maintenance window closes at end. The returned function cancels it.
+func warnAtWindowEnd(end time.Time) func() {
+	if end.IsZero() {
+		return func() {}
+	}
+	timer := time.AfterFunc(end.Sub(timeNow()), func() {
+		log.Warn().Time("end", end).Msg("Backup is still running after the maintenance window closed")
+	})
+	return func() { timer.Stop() }
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckMaintenanceWindow(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+
+	// 2024-01-05 is a Friday.
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2024, 1, day, hour, min, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		name     string
+		window   Window
+		now      time.Time
+		wantOK   bool
+		wantEnd  time.Time
+		wantNext time.Time
+	}{
+		{"no window", Window{}, at(5, 12, 0), true, time.Time{}, time.Time{}},
+		{"inside", Window{Start: "09:00", End: "17:00"}, at(5, 12, 0), true, at(5, 17, 0), time.Time{}},
+		{"at start", Window{Start: "09:00", End: "17:00"}, at(5, 9, 0), true, at(5, 17, 0), time.Time{}},
+		{"before", Window{Start: "09:00", End: "17:00"}, at(5, 8, 59), false, time.Time{}, at(5, 9, 0)},
+		{"at end", Window{Start: "09:00", End: "17:00"}, at(5, 17, 0), false, time.Time{}, at(6, 9, 0)},
+		{"overnight, evening", Window{Start: "22:00", End: "06:00"}, at(5, 23, 0), true, at(6, 6, 0), time.Time{}},
+		{"overnight, morning", Window{Start: "22:00", End: "06:00"}, at(5, 5, 0), true, at(5, 6, 0), time.Time{}},
+		{"overnight, daytime", Window{Start: "22:00", End: "06:00"}, at(5, 12, 0), false, time.Time{}, at(5, 22, 0)},
+		{"weekend only, friday", Window{Start: "00:00", End: "23:59", Days: []string{"sat", "Sunday"}}, at(5, 12, 0), false, time.Time{}, at(6, 0, 0)},
+		{"weekend only, saturday", Window{Start: "00:00", End: "23:59", Days: []string{"sat", "Sunday"}}, at(6, 12, 0), true, at(6, 23, 59), time.Time{}},
+		// Opened on Saturday night, so still open early on Sunday even
+		// though only Saturday is allowed.
+		{"started on allowed day", Window{Start: "22:00", End: "06:00", Days: []string{"sat"}}, at(7, 3, 0), true, at(7, 6, 0), time.Time{}},
+		{"next allowed day", Window{Start: "22:00", End: "06:00", Days: []string{"sat"}}, at(7, 12, 0), false, time.Time{}, at(13, 22, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.window.validate(); err != nil {
+				t.Fatalf("validate failed: %v", err)
+			}
+			timeNow = func() time.Time { return tt.now }
+			ok, end, next := checkMaintenanceWindow(&Config{MaintenanceWindow: tt.window})
+			if ok != tt.wantOK || !end.Equal(tt.wantEnd) || !next.Equal(tt.wantNext) {
+				t.Errorf("checkMaintenanceWindow() = %v, %v, %v; want %v, %v, %v", ok, end, next, tt.wantOK, tt.wantEnd, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestValidateMaintenanceWindow(t *testing.T) {
+	for _, w := range []Window{
+		{Start: "22:00"},
+		{Start: "25:00", End: "06:00"},
+		{Start: "22:00", End: "22:00"},
+		{Start: "22:00", End: "06:00", Days: []string{"someday"}},
+		{Days: []string{"mon"}},
+	} {
+		if err := validateConfig(&Config{MaintenanceWindow: w}); err == nil {
+			t.Errorf("Expected %+v to be rejected", w)
+		}
+	}
+}